@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
+	"github.com/Rocket-Rescue-Node/rescue-api/services"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type adminActorKey struct{}
+
+type adminRouter struct {
+	svc    *services.Service
+	tokens map[string]string
+	logger *zap.Logger
+}
+
+// Returns the name of the admin that authenticated the request.
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(adminActorKey{}).(string)
+	return actor
+}
+
+// Authenticates admin requests using a bearer token.
+// The name associated with the token is stored in the request context, and used for auditing.
+// Every request is refused when no token is configured.
+func (ar *adminRouter) bearerTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(ar.tokens) == 0 {
+			_ = writeJSONResponse(w, http.StatusForbidden, nil, "admin API is disabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			// Compare against every token so that timing does not leak which one matched.
+			var actor string
+			for name, t := range ar.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					actor = name
+				}
+			}
+			if actor != "" {
+				ctx := context.WithValue(r.Context(), adminActorKey{}, actor)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		ar.logger.Warn("Rejected unauthenticated admin request",
+			zap.String("endpoint", r.URL.Path),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		_ = writeJSONResponse(w, http.StatusUnauthorized, nil, "unauthorized")
+	})
+}
+
+func (ar *adminRouter) GetRules(w http.ResponseWriter, r *http.Request) error {
+	rules, err := ar.svc.GetAuthorizationRules(r.Context())
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusOK, rules, "")
+}
+
+func (ar *adminRouter) SetRule(w http.ResponseWriter, r *http.Request) error {
+	req := new(SetRuleRequest)
+	if err := validateJSONRequest(r, req); err != nil {
+		return writeJSONError(w, err)
+	}
+	if req.Action == nil {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: "missing action"})
+	}
+
+	rule := authz.Rule{
+		NodeID:    req.NodeID,
+		Resource:  req.Resource,
+		Action:    *req.Action,
+		NotBefore: req.NotBefore,
+		ExpiresAt: req.ExpiresAt,
+		Reason:    req.Reason,
+	}
+	created, err := ar.svc.SetAuthorizationRule(r.Context(), rule, adminActor(r))
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusCreated, created, "")
+}
+
+func (ar *adminRouter) DeleteRule(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	if !common.IsHexAddress(vars["node_id"]) {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: "invalid node ID"})
+	}
+	var resource authz.Resource
+	if err := resource.UnmarshalText([]byte(vars["resource"])); err != nil {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: err.Error()})
+	}
+	var action authz.Action
+	if err := action.UnmarshalText([]byte(vars["action"])); err != nil {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: err.Error()})
+	}
+
+	nodeID := common.HexToAddress(vars["node_id"])
+	if err := ar.svc.DeleteAuthorizationRule(r.Context(), nodeID, resource, action, adminActor(r)); err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusOK, nil, "")
+}
+
+func (ar *adminRouter) GetAuditEvents(w http.ResponseWriter, r *http.Request) error {
+	var events AuditEventsResponse
+	var err error
+	if events.Rules, err = ar.svc.GetAuthorizationAuditEvents(r.Context()); err != nil {
+		return writeJSONError(w, err)
+	}
+	if events.QuotaOverrides, err = ar.svc.GetQuotaOverrideAuditEvents(r.Context()); err != nil {
+		return writeJSONError(w, err)
+	}
+	if events.EmergencyCredits, err = ar.svc.GetEmergencyCreditsAuditEvents(r.Context()); err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusOK, events, "")
+}
+
+func (ar *adminRouter) GetQuotaOverrides(w http.ResponseWriter, r *http.Request) error {
+	overrides, err := ar.svc.GetQuotaOverrides(r.Context())
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusOK, overrides, "")
+}
+
+func (ar *adminRouter) SetQuotaOverride(w http.ResponseWriter, r *http.Request) error {
+	override := new(services.QuotaOverride)
+	if err := validateJSONRequest(r, override); err != nil {
+		return writeJSONError(w, err)
+	}
+
+	created, err := ar.svc.SetQuotaOverride(r.Context(), *override, adminActor(r))
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusCreated, created, "")
+}
+
+func (ar *adminRouter) DeleteQuotaOverride(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	if !common.IsHexAddress(vars["node_id"]) {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: "invalid node ID"})
+	}
+	ot, err := services.ParseOperatorType(vars["operator_type"])
+	if err != nil {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: err.Error()})
+	}
+
+	nodeID := common.HexToAddress(vars["node_id"])
+	if err := ar.svc.DeleteQuotaOverride(r.Context(), nodeID, ot, adminActor(r)); err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusOK, nil, "")
+}
+
+func (ar *adminRouter) GrantEmergencyCredits(w http.ResponseWriter, r *http.Request) error {
+	req := new(GrantEmergencyCreditsRequest)
+	if err := validateJSONRequest(r, req); err != nil {
+		return writeJSONError(w, err)
+	}
+	ot, err := services.ParseOperatorType(req.OperatorType)
+	if err != nil {
+		return writeJSONError(w, &decodingError{status: http.StatusBadRequest, msg: err.Error()})
+	}
+
+	remaining, err := ar.svc.GrantEmergencyCredits(r.Context(), req.NodeID, ot, req.Credits, adminActor(r))
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	return writeJSONResponse(w, http.StatusCreated, GrantEmergencyCreditsResponse{remaining}, "")
+}
+
+func (ar *adminRouter) wrapHandler(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			ar.logger.Error("Error handling admin request", zap.Error(err))
+		}
+	}
+}
+
+// NewAdminRouter creates the router for the admin API.
+// tokens maps the name of each admin to their bearer token.
+func NewAdminRouter(path string, svc *services.Service, tokens map[string]string, logger *zap.Logger) *mux.Router {
+	ah := &adminRouter{
+		svc,
+		tokens,
+		logger,
+	}
+	r := mux.NewRouter()
+	sr := r.PathPrefix(path).Subrouter()
+
+	sr.Use(ah.bearerTokenMiddleware)
+	sr.Use(MaxBytesReaderMiddleware)
+
+	sr.HandleFunc("/rules", ah.wrapHandler(ah.GetRules)).Methods("GET")
+	sr.HandleFunc("/rules", ah.wrapHandler(ah.SetRule)).Methods("POST")
+	sr.HandleFunc("/rules/{node_id}/{resource}/{action}", ah.wrapHandler(ah.DeleteRule)).Methods("DELETE")
+	sr.HandleFunc("/audit", ah.wrapHandler(ah.GetAuditEvents)).Methods("GET")
+	sr.HandleFunc("/quota-overrides", ah.wrapHandler(ah.GetQuotaOverrides)).Methods("GET")
+	sr.HandleFunc("/quota-overrides", ah.wrapHandler(ah.SetQuotaOverride)).Methods("POST")
+	sr.HandleFunc("/quota-overrides/{node_id}/{operator_type}", ah.wrapHandler(ah.DeleteQuotaOverride)).Methods("DELETE")
+	sr.HandleFunc("/credits", ah.wrapHandler(ah.GrantEmergencyCredits)).Methods("POST")
+
+	return r
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+const testAdminToken = "s3cr3t"
+
+func adminRequest(t *testing.T, router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSetRule(t *testing.T) {
+	svc, _ := setupTestService(t, clockwork.NewFakeClock())
+	router := NewAdminRouter("/admin/v1/", svc, map[string]string{"alice": testAdminToken}, zap.NewNop())
+	const nodeID = "0x0000000000000000000000000000000000000001"
+
+	// A rule without an action is refused, rather than allowing access.
+	w := adminRequest(t, router, "POST", "/admin/v1/rules",
+		`{"nodeId": "`+nodeID+`", "resource": "credential_service", "reason": "abuse"}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing action") {
+		t.Fatalf("Expected a missing action to be refused, got %d: %s", w.Code, w.Body)
+	}
+	w = adminRequest(t, router, "GET", "/admin/v1/rules", "")
+	var rules response
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("Could not decode rules: %v", err)
+	}
+	if rules.Data != nil && len(rules.Data.([]interface{})) != 0 {
+		t.Fatalf("Expected no rule to be created, got %v", rules.Data)
+	}
+
+	// Unknown actions are refused too.
+	w = adminRequest(t, router, "POST", "/admin/v1/rules",
+		`{"nodeId": "`+nodeID+`", "resource": "credential_service", "action": "maybe"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown action to be refused, got %d: %s", w.Code, w.Body)
+	}
+
+	w = adminRequest(t, router, "POST", "/admin/v1/rules",
+		`{"nodeId": "`+nodeID+`", "resource": "credential_service", "action": "deny", "reason": "abuse"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the rule to be created, got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Data struct {
+			Action  string `json:"action"`
+			Creator string `json:"creator"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Could not decode rule: %v", err)
+	}
+	if created.Data.Action != "deny" || created.Data.Creator != "alice" {
+		t.Fatalf("Unexpected rule: %s", w.Body)
+	}
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	svc, _ := setupTestService(t, clockwork.NewFakeClock())
+	router := NewAdminRouter("/admin/v1/", svc, map[string]string{"alice": testAdminToken, "bob": "0th3r"}, zap.NewNop())
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"missing_token", "", http.StatusUnauthorized},
+		{"wrong_token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong_scheme", "Basic " + testAdminToken, http.StatusUnauthorized},
+		{"token_prefix", "Bearer " + testAdminToken[:3], http.StatusUnauthorized},
+		{"correct_token", "Bearer " + testAdminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/v1/rules", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatalf("Expected a WWW-Authenticate header, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// The token authenticates its admin, who is recorded as the creator of rules.
+	req := httptest.NewRequest("POST", "/admin/v1/rules", strings.NewReader(
+		`{"nodeId": "0x0000000000000000000000000000000000000001", "resource": "credential_service", "action": "allow"}`))
+	req.Header.Set("Authorization", "Bearer 0th3r")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"creator":"bob"`) {
+		t.Fatalf("Expected the rule to be created by bob, got %d: %s", w.Code, w.Body)
+	}
+
+	// The admin API is disabled when no token is configured, even for requests without a token.
+	router = NewAdminRouter("/admin/v1/", svc, map[string]string{}, zap.NewNop())
+	for _, authorization := range []string{"", "Bearer ", "Bearer " + testAdminToken} {
+		req := httptest.NewRequest("GET", "/admin/v1/rules", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("Expected the admin API to be disabled, got %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func TestQuotaOverrideAudit(t *testing.T) {
+	svc, _ := setupTestService(t, clockwork.NewFakeClock())
+	router := NewAdminRouter("/admin/v1/", svc, map[string]string{"alice": testAdminToken}, zap.NewNop())
+	const nodeID = "0x0000000000000000000000000000000000000001"
+
+	w := adminRequest(t, router, "POST", "/admin/v1/quota-overrides",
+		`{"nodeId": "`+nodeID+`", "operatorType": "rocketpool", "count": 6, "reason": "client outage"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the override to be created, got %d: %s", w.Code, w.Body)
+	}
+	w = adminRequest(t, router, "DELETE", "/admin/v1/quota-overrides/"+nodeID+"/rocketpool", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the override to be deleted, got %d: %s", w.Code, w.Body)
+	}
+
+	// Both changes are listed, newest first, with the admin who made them.
+	w = adminRequest(t, router, "GET", "/admin/v1/audit", "")
+	var audit struct {
+		Data struct {
+			QuotaOverrides []struct {
+				Actor     string `json:"actor"`
+				Operation string `json:"operation"`
+				Override  struct {
+					Count  uint   `json:"count"`
+					Reason string `json:"reason"`
+				} `json:"override"`
+			} `json:"quotaOverrides"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &audit); err != nil {
+		t.Fatalf("Could not decode audit events: %v", err)
+	}
+	events := audit.Data.QuotaOverrides
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 audit events, got %s", w.Body)
+	}
+	for i, op := range []string{"deleted", "created"} {
+		if e := events[i]; e.Actor != "alice" || e.Operation != op || e.Override.Count != 6 || e.Override.Reason != "client outage" {
+			t.Fatalf("Unexpected audit event %d: %s", i, w.Body)
+		}
+	}
+}
+
+func TestEmergencyCreditsAudit(t *testing.T) {
+	svc, _ := setupTestService(t, clockwork.NewFakeClock())
+	router := NewAdminRouter("/admin/v1/", svc, map[string]string{"alice": testAdminToken}, zap.NewNop())
+	const nodeID = "0x0000000000000000000000000000000000000001"
+
+	w := adminRequest(t, router, "POST", "/admin/v1/credits",
+		`{"nodeId": "`+nodeID+`", "operatorType": "solo", "credits": 3}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the credits to be granted, got %d: %s", w.Code, w.Body)
+	}
+
+	// The grant is listed with the admin who made it.
+	w = adminRequest(t, router, "GET", "/admin/v1/audit", "")
+	var audit struct {
+		Data struct {
+			EmergencyCredits []struct {
+				Actor        string `json:"actor"`
+				NodeID       string `json:"nodeId"`
+				OperatorType string `json:"operatorType"`
+				Credits      uint   `json:"credits"`
+			} `json:"emergencyCredits"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &audit); err != nil {
+		t.Fatalf("Could not decode audit events: %v", err)
+	}
+	events := audit.Data.EmergencyCredits
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 audit event, got %s", w.Body)
+	}
+	if e := events[0]; e.Actor != "alice" || e.NodeID != nodeID || e.OperatorType != "solo" || e.Credits != 3 {
+		t.Fatalf("Unexpected audit event: %s", w.Body)
+	}
+}
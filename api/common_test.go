@@ -0,0 +1,59 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	"github.com/Rocket-Rescue-Node/rescue-api/database"
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-api/services"
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+func initTestMetrics(t *testing.T) {
+	namespace := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, t.Name())
+	if _, err := metrics.Init(namespace); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+// Creates a service backed by its own in-memory database, and returns it with its node registry.
+// database.Open uses a single connection, so every query sees the same database.
+// Signatures are checked with verifiers, or only as EOA signatures if there are none.
+func setupTestService(t *testing.T, clock clockwork.Clock, verifiers ...services.SignatureVerifier) (*services.Service, *models.NodeRegistry) {
+	initTestMetrics(t)
+
+	db, err := database.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	nodes := models.NewNodeRegistry()
+	svc := services.NewService(&services.ServiceConfig{
+		DB:                  db,
+		CM:                  credentials.NewCredentialManager([]byte("test")),
+		Nodes:               nodes,
+		WithdrawalAddresses: models.NewNodeRegistry(),
+		Logger:              zap.NewNop(),
+		Clock:               clock,
+		AllowLegacyRequests: true,
+		ChainID:             1,
+		SignatureVerifiers:  verifiers,
+	})
+	if err := svc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Deinit)
+	return svc, nodes
+}
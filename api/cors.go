@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/rs/cors"
+)
+
+// CORSPolicy is a CORS middleware whose allowed origins can be replaced at runtime.
+type CORSPolicy struct {
+	debug   bool
+	handler atomic.Pointer[cors.Cors]
+}
+
+func NewCORSPolicy(origins []string, debug bool) *CORSPolicy {
+	p := &CORSPolicy{
+		debug: debug,
+	}
+	p.SetAllowedOrigins(origins)
+	return p
+}
+
+// SetAllowedOrigins replaces the allowed origins.
+// Requests that are already being processed are not affected.
+func (p *CORSPolicy) SetAllowedOrigins(origins []string) {
+	p.handler.Store(cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   allowedMethods,
+		ExposedHeaders:   []string{"Accept", "Content-Type", "Retry-After"},
+		AllowCredentials: false,
+		Debug:            p.debug,
+	}))
+}
+
+func (p *CORSPolicy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.handler.Load().Handler(next).ServeHTTP(w, r)
+	})
+}
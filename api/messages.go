@@ -5,15 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Rocket-Rescue-Node/credentials"
 	"github.com/Rocket-Rescue-Node/credentials/pb"
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
 	"github.com/Rocket-Rescue-Node/rescue-api/services"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 type response struct {
@@ -30,11 +33,19 @@ func (br *decodingError) Error() string {
 	return br.msg
 }
 
+// Signed message formats.
+const (
+	formatPersonalSign = "personal_sign"
+	formatEIP712       = "eip712"
+)
+
 type CreateCredentialRequest struct {
 	Address common.Address `json:"address"`
 	Msg     []byte         `json:"msg"`
 	Sig     []byte         `json:"sig"`
 	Version string         `json:"version"`
+	// The format of Msg: a personal_sign message (the default), or EIP-712 typed data.
+	Format string `json:"format"`
 
 	operatorType credentials.OperatorType `json:"-"`
 }
@@ -46,7 +57,7 @@ func (c *CreateCredentialRequest) UnmarshalJSON(data []byte) error {
 		Msg     string `json:"msg"`
 		Sig     string `json:"sig"`
 
-		// Populates the `Version` field
+		// Populates the `Version` and `Format` fields
 		*Alias
 	}{
 		Alias: (*Alias)(c),
@@ -62,11 +73,25 @@ func (c *CreateCredentialRequest) UnmarshalJSON(data []byte) error {
 	// Convert Msg
 	c.Msg = []byte(aux.Msg)
 
+	// Typed data is passed as the JSON object given to eth_signTypedData_v4.
+	switch c.Format {
+	case "", formatPersonalSign:
+		if strings.HasPrefix(aux.Msg, "{") {
+			return fmt.Errorf("msg is typed data, but format is not %s", formatEIP712)
+		}
+	case formatEIP712:
+		if !strings.HasPrefix(aux.Msg, "{") {
+			return fmt.Errorf("msg is not typed data")
+		}
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+
 	// Convert Sig
 	var err error
-	c.Sig, err = hexutil.Decode(aux.Sig)
+	c.Sig, err = util.DecodeSignature(aux.Sig)
 	if err != nil {
-		return fmt.Errorf("invalid signature hex: %v", err)
+		return fmt.Errorf("invalid signature encoding: %v", err)
 	}
 
 	return nil
@@ -79,11 +104,51 @@ type CreateCredentialResponse struct {
 	ExpiresAt int64  `json:"expiresAt"`
 }
 
+type ChallengeResponse struct {
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type RevokeCredentialRequest CreateCredentialRequest
+
+type RevokeCredentialResponse struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
 type OperatorInfoRequest CreateCredentialRequest
 
 type OperatorInfoResponse struct {
 	CredentialEvents []int64          `json:"credentialEvents"`
 	QuotaSettings    *json.RawMessage `json:"quotaSettings"`
+	EmergencyCredits int64            `json:"emergencyCredits"`
+}
+
+// SetRuleRequest creates an authorization rule. The action has no default: Allow is the zero
+// value, so a rule whose action was left out would grant access instead of refusing it.
+type SetRuleRequest struct {
+	NodeID    common.Address `json:"nodeId"`
+	Resource  authz.Resource `json:"resource"`
+	Action    *authz.Action  `json:"action"`
+	NotBefore int64          `json:"notBefore"`
+	ExpiresAt int64          `json:"expiresAt"`
+	Reason    string         `json:"reason"`
+}
+
+// AuditEventsResponse lists the most recent changes made through the admin API, newest first.
+type AuditEventsResponse struct {
+	Rules            []authz.RuleAuditEvent                `json:"rules"`
+	QuotaOverrides   []services.QuotaOverrideAuditEvent    `json:"quotaOverrides"`
+	EmergencyCredits []services.EmergencyCreditsAuditEvent `json:"emergencyCredits"`
+}
+
+type GrantEmergencyCreditsRequest struct {
+	NodeID       common.Address `json:"nodeId"`
+	OperatorType string         `json:"operatorType"`
+	Credits      uint           `json:"credits"`
+}
+
+type GrantEmergencyCreditsResponse struct {
+	RemainingCredits int64 `json:"remainingCredits"`
 }
 
 func validateJSONRequest(r *http.Request, req interface{}) error {
@@ -106,8 +171,9 @@ func validateJSONRequest(r *http.Request, req interface{}) error {
 
 	// Check querystring for operator_type
 	operatorType, ok := r.URL.Query()["operator_type"]
-	if ok && len(operatorType) > 0 && strings.EqualFold(operatorType[0], "solo") {
-		req.(*CreateCredentialRequest).operatorType = credentials.OperatorType(pb.OperatorType_OT_SOLO)
+	ccr, isCredReq := req.(*CreateCredentialRequest)
+	if isCredReq && ok && len(operatorType) > 0 && strings.EqualFold(operatorType[0], "solo") {
+		ccr.operatorType = credentials.OperatorType(pb.OperatorType_OT_SOLO)
 	}
 
 	return nil
@@ -126,6 +192,7 @@ func writeJSONResponse(w http.ResponseWriter, code int, data interface{}, err st
 
 func writeJSONError(w http.ResponseWriter, err error) error {
 	var de *decodingError
+	var ue *services.UnavailableError
 	switch {
 	case errors.As(err, &de):
 		return writeJSONResponse(w, de.status, nil, de.msg)
@@ -135,6 +202,11 @@ func writeJSONError(w http.ResponseWriter, err error) error {
 		return writeJSONResponse(w, http.StatusUnauthorized, nil, err.Error())
 	case errors.Is(err, &services.AuthorizationError{}):
 		return writeJSONResponse(w, http.StatusForbidden, nil, err.Error())
+	case errors.Is(err, &services.NotFoundError{}):
+		return writeJSONResponse(w, http.StatusNotFound, nil, err.Error())
+	case errors.As(err, &ue):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(ue.RetryAfter().Seconds())))))
+		return writeJSONResponse(w, http.StatusServiceUnavailable, nil, err.Error())
 	default:
 		return writeJSONResponse(w, http.StatusInternalServerError, nil, "internal server error")
 	}
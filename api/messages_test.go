@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/Rocket-Rescue-Node/rescue-api/services"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+type retryableTestError struct {
+	retryAfter time.Duration
+}
+
+func (e *retryableTestError) Error() string {
+	return "circuit breaker is open"
+}
+
+func (e *retryableTestError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// An EIP-1271 validator whose dependency refuses calls for a while, like an open circuit breaker.
+type unavailableEIP1271Validator struct {
+	retryAfter time.Duration
+}
+
+func (v *unavailableEIP1271Validator) ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
+	return false, &retryableTestError{v.retryAfter}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	// Get an UnavailableError from the service, since its retry delay can't be set from here.
+	clock := clockwork.NewFakeClockAt(time.Now())
+	verifier := services.NewEIP1271Verifier(services.ProxyEIP1271VerifierName, &unavailableEIP1271Validator{2500 * time.Millisecond}, time.Second)
+	svc, _ := setupTestService(t, clock, verifier)
+	node, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	msg := []byte(fmt.Sprintf("Rescue Node %d", clock.Now().Unix()))
+	sig, err := node.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	_, unavailable := svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if !errors.Is(unavailable, &services.UnavailableError{}) {
+		t.Fatalf("Expected the service to be unavailable, got %v", unavailable)
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		message    string
+		retryAfter string
+	}{
+		{"decoding", &decodingError{status: http.StatusUnsupportedMediaType, msg: "bad content type"}, http.StatusUnsupportedMediaType, "bad content type", ""},
+		{"validation", &services.ValidationError{}, http.StatusBadRequest, "", ""},
+		{"authentication", &services.AuthenticationError{}, http.StatusUnauthorized, "", ""},
+		{"authorization", &services.AuthorizationError{}, http.StatusForbidden, "", ""},
+		{"not_found", &services.NotFoundError{}, http.StatusNotFound, "", ""},
+		{"unavailable", unavailable, http.StatusServiceUnavailable, unavailable.Error(), "3"},
+		{"unavailable_now", &services.UnavailableError{}, http.StatusServiceUnavailable, "", "1"},
+		{"wrapped", fmt.Errorf("creating credential: %w", unavailable), http.StatusServiceUnavailable, "creating credential: " + unavailable.Error(), "3"},
+		{"internal", errors.New("database is locked"), http.StatusInternalServerError, "internal server error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := writeJSONError(w, tt.err); err != nil {
+				t.Fatalf("Could not write error: %v", err)
+			}
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("Expected a JSON response, got %q", contentType)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tt.retryAfter {
+				t.Fatalf("Expected Retry-After %q, got %q", tt.retryAfter, retryAfter)
+			}
+			var resp response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Could not decode response: %v", err)
+			}
+			if resp.Error != tt.message || resp.Data != nil {
+				t.Fatalf("Unexpected response %+v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the API routes, as used in rate limit policy files and metrics.
+const (
+	routeCredentials = "credentials"
+	routeRevoke      = "revoke"
+	routeInfo        = "info"
+	routeChallenge   = "challenge"
+)
+
+var rateLimitedRoutes = []string{routeCredentials, routeRevoke, routeInfo, routeChallenge}
+
+// RateLimit is a token bucket: up to Burst requests are allowed at once, and Rate more per second.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RouteRateLimits are the limits of a route, per client IP and per node address claimed in the
+// request body. Requests are not limited by the limits that are nil.
+type RouteRateLimits struct {
+	PerIP      *RateLimit `yaml:"perIP"`
+	PerAddress *RateLimit `yaml:"perAddress"`
+}
+
+// RateLimitPolicy contains the rate limits of each route, keyed by route name.
+// Routes that are missing are not limited.
+type RateLimitPolicy map[string]RouteRateLimits
+
+// DefaultRateLimitPolicy returns the rate limit policy used when no policy file is provided.
+func DefaultRateLimitPolicy() RateLimitPolicy {
+	signed := RouteRateLimits{
+		PerIP:      &RateLimit{Rate: 0.2, Burst: 10},
+		PerAddress: &RateLimit{Rate: 1.0 / 60, Burst: 5},
+	}
+	return RateLimitPolicy{
+		routeCredentials: signed,
+		routeRevoke:      signed,
+		routeInfo: {
+			PerIP:      &RateLimit{Rate: 0.5, Burst: 20},
+			PerAddress: &RateLimit{Rate: 0.1, Burst: 10},
+		},
+		routeChallenge: {
+			PerIP: &RateLimit{Rate: 1, Burst: 20},
+		},
+	}
+}
+
+// LoadRateLimitPolicy reads a rate limit policy from a YAML file, e.g.
+//
+//	credentials:
+//	  perIP: {rate: 0.2, burst: 10}
+//	  perAddress: {rate: 0.0167, burst: 5}
+//	challenge:
+//	  perIP: {rate: 1, burst: 20}
+//
+// Routes are credentials, revoke, info and challenge. Routes that are missing are not limited,
+// except for challenge, which keeps its default per-IP limit.
+func LoadRateLimitPolicy(path string) (RateLimitPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy RateLimitPolicy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid rate limit policy: %w", err)
+	}
+	for route, limits := range policy {
+		known := false
+		for _, r := range rateLimitedRoutes {
+			known = known || r == route
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid rate limit policy: unknown route %q", route)
+		}
+		for _, l := range []*RateLimit{limits.PerIP, limits.PerAddress} {
+			if l != nil && (l.Rate <= 0 || l.Burst < 1) {
+				return nil, fmt.Errorf("invalid rate limit policy: %s rate must be positive, and burst at least 1", route)
+			}
+		}
+	}
+	if policy == nil {
+		policy = make(RateLimitPolicy)
+	}
+	if challenge := policy[routeChallenge]; challenge.PerIP == nil {
+		challenge.PerIP = DefaultRateLimitPolicy()[routeChallenge].PerIP
+		policy[routeChallenge] = challenge
+	}
+	return policy, nil
+}
+
+type routeRateLimiters struct {
+	perIP      *util.RateLimiter
+	perAddress *util.RateLimiter
+}
+
+// RateLimiter is a middleware that enforces a RateLimitPolicy on the API routes.
+// The policy and trusted proxies can be replaced at runtime.
+type RateLimiter struct {
+	clock  clockwork.Clock
+	logger *zap.Logger
+	m      *metrics.MetricsRegistry
+
+	routes         atomic.Pointer[map[string]routeRateLimiters]
+	trustedProxies atomic.Pointer[[]netip.Prefix]
+}
+
+func NewRateLimiter(policy RateLimitPolicy, trustedProxies []netip.Prefix, clock clockwork.Clock, logger *zap.Logger) *RateLimiter {
+	rl := &RateLimiter{
+		clock:  clock,
+		logger: logger,
+		m:      metrics.NewMetricsRegistry("rate_limit"),
+	}
+	rl.SetPolicy(policy)
+	rl.SetTrustedProxies(trustedProxies)
+	return rl
+}
+
+// SetPolicy replaces the rate limits. Clients start again with full buckets.
+func (rl *RateLimiter) SetPolicy(policy RateLimitPolicy) {
+	routes := make(map[string]routeRateLimiters)
+	for route, limits := range policy {
+		var l routeRateLimiters
+		if limits.PerIP != nil {
+			l.perIP = util.NewRateLimiter(limits.PerIP.Rate, limits.PerIP.Burst, rl.clock)
+		}
+		if limits.PerAddress != nil {
+			l.perAddress = util.NewRateLimiter(limits.PerAddress.Rate, limits.PerAddress.Burst, rl.clock)
+		}
+		routes[route] = l
+	}
+	rl.routes.Store(&routes)
+}
+
+// SetTrustedProxies replaces the proxies whose X-Forwarded-For header is honoured.
+func (rl *RateLimiter) SetTrustedProxies(trustedProxies []netip.Prefix) {
+	rl.trustedProxies.Store(&trustedProxies)
+}
+
+// A reader that fails with err, to replay the error that stopped reading a body to the handler.
+type erroredReader struct {
+	err error
+}
+
+func (r erroredReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// peekAddress returns the node address claimed in a JSON request body, leaving the body unread.
+func peekAddress(r *http.Request) (common.Address, bool) {
+	if r.Body == nil {
+		return common.Address{}, false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), erroredReader{err}))
+		return common.Address{}, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || !common.IsHexAddress(req.Address) {
+		// The handler rejects invalid requests.
+		return common.Address{}, false
+	}
+	return common.HexToAddress(req.Address), true
+}
+
+// Writes a 429 response, asking the client to wait until a token is available.
+func (rl *RateLimiter) reject(w http.ResponseWriter, route string, limit string, wait time.Duration) error {
+	rl.m.Counter("rejected_" + route + "_" + limit).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	return writeJSONResponse(w, http.StatusTooManyRequests, nil, "too many requests, please retry later")
+}
+
+// Records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Returns the key of the per-IP bucket of ip. IPv6 clients are usually given a whole /64,
+// so they are limited per /64 rather than per address.
+func ipKey(ip netip.Addr) string {
+	if ip.Is6() {
+		return netip.PrefixFrom(ip, 64).Masked().String()
+	}
+	return ip.String()
+}
+
+// Tells whether a response means that the request signature was verified: requests either
+// succeed, or are refused afterwards because the node isn't authorized.
+func signatureVerified(status int) bool {
+	return status < http.StatusBadRequest || status == http.StatusForbidden
+}
+
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		limits, ok := (*rl.routes.Load())[name]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if limits.perIP != nil {
+			ip, err := util.ClientIP(r, *rl.trustedProxies.Load())
+			if err != nil {
+				rl.logger.Warn("Could not determine client IP", zap.Error(err))
+			} else if ok, wait := limits.perIP.Allow(ipKey(ip)); !ok {
+				if err := rl.reject(w, name, "ip", wait); err != nil {
+					rl.logger.Error("Error handling request", zap.Error(err))
+				}
+				return
+			}
+		}
+
+		// Anyone can claim any address, so requests are only charged to the address once their
+		// signature was verified. Otherwise, others could exhaust the bucket of a node.
+		if limits.perAddress != nil {
+			if address, ok := peekAddress(r); ok {
+				if ok, wait := limits.perAddress.Peek(address.Hex()); !ok {
+					if err := rl.reject(w, name, "address", wait); err != nil {
+						rl.logger.Error("Error handling request", zap.Error(err))
+					}
+					return
+				}
+				rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(rec, r)
+				if signatureVerified(rec.status) {
+					limits.perAddress.Allow(address.Hex())
+				}
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
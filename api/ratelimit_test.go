@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, nodes := setupTestService(t, clock)
+	policy := RateLimitPolicy{
+		routeChallenge: {
+			PerIP: &RateLimit{Rate: 0.5, Burst: 2},
+		},
+		routeCredentials: {
+			PerAddress: &RateLimit{Rate: 0.1, Burst: 1},
+		},
+	}
+	rl := NewRateLimiter(policy, nil, clock, zap.NewNop())
+	router := NewAPIRouter("/rescue/v1/", svc, NewCORSPolicy(nil, false), rl, zap.NewNop())
+
+	challenge := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/rescue/v1/challenge", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Clients get a burst of requests, then have to wait for tokens to be refilled.
+	for i := 0; i < 2; i++ {
+		if w := challenge("192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("Expected the request to be allowed, got %d: %s", w.Code, w.Body)
+		}
+	}
+	w := challenge("192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the request to be rate limited, got %d: %s", w.Code, w.Body)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatalf("Expected Retry-After to be 2, got %q", retryAfter)
+	}
+	var resp response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+		t.Fatalf("Expected a JSON error, got %s", w.Body)
+	}
+	if w := challenge("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Fatalf("Expected other clients to be allowed, got %d: %s", w.Code, w.Body)
+	}
+	clock.Advance(2 * time.Second)
+	if w := challenge("192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the request to be allowed once refilled, got %d: %s", w.Code, w.Body)
+	}
+
+	// IPv6 clients are limited per /64.
+	for i := 0; i < 2; i++ {
+		if w := challenge(fmt.Sprintf("[2001:db8::%d]:1234", i+1)); w.Code != http.StatusOK {
+			t.Fatalf("Expected the request to be allowed, got %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := challenge("[2001:db8::ffff:1]:1234"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the /64 to be rate limited, got %d: %s", w.Code, w.Body)
+	}
+	if w := challenge("[2001:db8:0:1::1]:1234"); w.Code != http.StatusOK {
+		t.Fatalf("Expected other /64s to be allowed, got %d: %s", w.Code, w.Body)
+	}
+
+	node, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	nodes.Add([]models.NodeID{*node.Address})
+	nodes.SetLastUpdated(clock.Now())
+	msg := fmt.Sprintf("Rescue Node %d", clock.Now().Unix())
+	sig, err := node.Sign([]byte(msg))
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	createCredential := func(sig []byte) *httptest.ResponseRecorder {
+		body, err := json.Marshal(map[string]string{
+			"address": node.Address.Hex(),
+			"msg":     msg,
+			"sig":     hexutil.Encode(sig),
+			"version": "1",
+		})
+		if err != nil {
+			t.Fatalf("Could not encode request: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/rescue/v1/credentials", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Requests that claim the address without its signature don't use up its requests.
+	junk := bytes.Repeat([]byte{0x01}, 65)
+	for i := 0; i < 3; i++ {
+		if w := createCredential(junk); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the signature to be rejected, got %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := createCredential(sig); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the credential to be created, got %d: %s", w.Code, w.Body)
+	}
+	w = createCredential(sig)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the address to be rate limited, got %d: %s", w.Code, w.Body)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Fatalf("Expected Retry-After to be 10, got %q", retryAfter)
+	}
+}
+
+func TestLoadRateLimitPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rate-limits.yaml")
+	if err := os.WriteFile(path, []byte("credentials:\n  perAddress: {rate: 0.5, burst: 2}\n"), 0o600); err != nil {
+		t.Fatalf("Could not write policy: %v", err)
+	}
+	policy, err := LoadRateLimitPolicy(path)
+	if err != nil {
+		t.Fatalf("Could not load policy: %v", err)
+	}
+	if l := policy[routeCredentials].PerAddress; l == nil || l.Rate != 0.5 || l.Burst != 2 || policy[routeCredentials].PerIP != nil {
+		t.Fatalf("Unexpected credentials limits %+v", policy[routeCredentials])
+	}
+	if _, ok := policy[routeInfo]; ok {
+		t.Fatalf("Expected info not to be limited")
+	}
+
+	// Challenges are always limited per IP.
+	if l := policy[routeChallenge].PerIP; l == nil || *l != *DefaultRateLimitPolicy()[routeChallenge].PerIP {
+		t.Fatalf("Expected the default challenge limit, got %+v", policy[routeChallenge])
+	}
+}
@@ -1,14 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/hex"
 	"net/http"
 	"time"
 
 	"github.com/Rocket-Rescue-Node/rescue-api/services"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	"go.uber.org/zap"
 )
 
@@ -29,8 +28,8 @@ func (ar *apiRouter) readJSONRequest(r *http.Request) (*CreateCredentialRequest,
 		zap.String("endpoint", r.URL.Path),
 		zap.String("address", out.Address.Hex()),
 		zap.String("msg", string(out.Msg)),
-		zap.String("sig", hexutil.Encode(out.Sig)),
 		zap.String("version", out.Version),
+		zap.String("format", out.Format),
 		zap.Int("operator_type", int(out.operatorType)),
 	)
 
@@ -45,7 +44,7 @@ func (ar *apiRouter) CreateCredential(w http.ResponseWriter, r *http.Request) er
 	}
 
 	// Create the credential
-	cred, err := ar.svc.CreateCredentialWithRetry(req.Msg, req.Sig, req.Address, req.operatorType)
+	cred, err := ar.svc.CreateCredentialWithRetry(r.Context(), req.Msg, req.Sig, req.Address, req.operatorType)
 	if err != nil {
 		return writeJSONError(w, err)
 	}
@@ -60,7 +59,11 @@ func (ar *apiRouter) CreateCredential(w http.ResponseWriter, r *http.Request) er
 		return writeJSONError(w, err)
 	}
 
-	expires := time.Unix(cred.Credential.Timestamp, 0).Add(services.AuthValidityWindow(cred.Credential.OperatorType))
+	validityWindow, err := ar.svc.NodeAuthValidityWindow(r.Context(), req.Address, cred.Credential.OperatorType)
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+	expires := time.Unix(cred.Credential.Timestamp, 0).Add(validityWindow)
 
 	resp := CreateCredentialResponse{
 		Username:  cred.Base64URLEncodeUsername(),
@@ -72,6 +75,47 @@ func (ar *apiRouter) CreateCredential(w http.ResponseWriter, r *http.Request) er
 	return writeJSONResponse(w, http.StatusCreated, resp, "")
 }
 
+func (ar *apiRouter) CreateChallenge(w http.ResponseWriter, r *http.Request) error {
+	nonce, expires, err := ar.svc.CreateChallenge()
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	resp := ChallengeResponse{
+		Nonce:     nonce,
+		ExpiresAt: expires.Unix(),
+	}
+
+	return writeJSONResponse(w, http.StatusOK, resp, "")
+}
+
+func (ar *apiRouter) RevokeCredential(w http.ResponseWriter, r *http.Request) error {
+	// Try to read the request
+	credReq, err := ar.readJSONRequest(r)
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	req := (*RevokeCredentialRequest)(credReq)
+
+	// Revoke the current credential
+	timestamp, err := ar.svc.RevokeCredential(r.Context(), req.Msg, req.Sig, req.Address, req.operatorType)
+	if err != nil {
+		return writeJSONError(w, err)
+	}
+
+	ar.logger.Info("Revoked credential",
+		zap.String("nodeID", req.Address.Hex()),
+		zap.Int("operator_type", int(req.operatorType)),
+		zap.Int64("timestamp", timestamp))
+
+	resp := RevokeCredentialResponse{
+		Timestamp: timestamp,
+	}
+
+	return writeJSONResponse(w, http.StatusOK, resp, "")
+}
+
 func (ar *apiRouter) GetOperatorInfo(w http.ResponseWriter, r *http.Request) error {
 	// Try to read the request
 	credReq, err := ar.readJSONRequest(r)
@@ -82,7 +126,7 @@ func (ar *apiRouter) GetOperatorInfo(w http.ResponseWriter, r *http.Request) err
 	req := (*OperatorInfoRequest)(credReq)
 
 	// Get operator info
-	operatorInfo, err := ar.svc.GetOperatorInfo(req.Msg, req.Sig, req.Address, req.operatorType)
+	operatorInfo, err := ar.svc.GetOperatorInfo(r.Context(), req.Msg, req.Sig, req.Address, req.operatorType)
 	if err != nil {
 		return writeJSONError(w, err)
 	}
@@ -93,20 +137,33 @@ func (ar *apiRouter) GetOperatorInfo(w http.ResponseWriter, r *http.Request) err
 		zap.Int("operator_type", int(req.operatorType)),
 	)
 
-	// Get operator quota settings
-	quotaSettings, err := services.GetQuotaJSON(req.operatorType)
-	if err != nil {
-		return err
-	}
-
 	resp := OperatorInfoResponse{
 		CredentialEvents: operatorInfo.CredentialEvents,
-		QuotaSettings:    &quotaSettings,
+		QuotaSettings:    &operatorInfo.QuotaSettings,
+		EmergencyCredits: operatorInfo.EmergencyCredits,
 	}
 
 	return writeJSONResponse(w, http.StatusOK, resp, "")
 }
 
+// Healthz reports that the process is up.
+func (ar *apiRouter) Healthz(w http.ResponseWriter, r *http.Request) error {
+	return writeJSONResponse(w, http.StatusOK, nil, "")
+}
+
+// Readyz reports whether the service can handle requests, with the result of each check.
+func (ar *apiRouter) Readyz(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	readiness := ar.svc.CheckReadiness(ctx)
+	if !readiness.Ready {
+		ar.logger.Debug("Service is not ready", zap.Any("checks", readiness.Checks))
+		return writeJSONResponse(w, http.StatusServiceUnavailable, readiness, "not ready")
+	}
+	return writeJSONResponse(w, http.StatusOK, readiness, "")
+}
+
 // Wrapper to log unhandled errors.
 // Note that this wrapper is only for last resort errors. For example, caused by
 // error handling functions not being able to write a response to the client.
@@ -120,43 +177,69 @@ func (ar *apiRouter) wrapHandler(h func(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Request body size limits.
+const (
+	maxRequestBytes = 2048
+	// Signed requests may carry EIP-6492 signatures, which wrap the calldata that deploys the
+	// wallet, e.g. a Safe setup with several owners and modules, or a WebAuthn signature.
+	maxSignedRequestBytes = 32 * 1024
+)
+
 func MaxBytesReaderMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(maxRequestBytes)
+		if route := mux.CurrentRoute(r); route != nil {
+			switch route.GetName() {
+			case routeCredentials, routeRevoke, routeInfo:
+				limit = maxSignedRequestBytes
+			}
+		}
 
-		// Limit the size of the request body to 2 KB
-		r.Body = http.MaxBytesReader(w, r.Body, 2048)
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 		next.ServeHTTP(w, r)
 	})
 }
 
-func NewAPIRouter(path string, svc *services.Service, origins []string, logger *zap.Logger) *mux.Router {
+// How long readiness checks may take before the service is reported as not ready.
+const readinessTimeout = 3 * time.Second
+
+// The HTTP methods allowed by the API router.
+var allowedMethods = []string{"GET", "POST", "OPTIONS"}
+
+func NewAPIRouter(path string, svc *services.Service, cp *CORSPolicy, rl *RateLimiter, logger *zap.Logger) *mux.Router {
 	// Create router.
 	ah := &apiRouter{
 		svc,
 		logger,
 	}
 	r := mux.NewRouter()
+
+	// Liveness and readiness probes, outside of the API path, so that they
+	// are neither rate limited nor subject to CORS.
+	r.HandleFunc("/healthz", ah.wrapHandler(ah.Healthz)).Methods("GET")
+	r.HandleFunc("/readyz", ah.wrapHandler(ah.Readyz)).Methods("GET")
+
 	sr := r.PathPrefix(path).Subrouter()
 
 	// Enforce request byte limits
 	sr.Use(MaxBytesReaderMiddleware)
 
-	// Register handlers.
-	allowedMethods := []string{"GET", "POST", "OPTIONS"}
-	sr.HandleFunc("/credentials", ah.wrapHandler(ah.CreateCredential)).Methods(allowedMethods...)
-	sr.HandleFunc("/credentials/", ah.wrapHandler(ah.CreateCredential)).Methods(allowedMethods...)
-	sr.HandleFunc("/info", ah.wrapHandler(ah.GetOperatorInfo)).Methods(allowedMethods...)
-	sr.HandleFunc("/info/", ah.wrapHandler(ah.GetOperatorInfo)).Methods(allowedMethods...)
+	// Register handlers. Routes are named for rate limiting.
+	sr.HandleFunc("/credentials", ah.wrapHandler(ah.CreateCredential)).Methods(allowedMethods...).Name(routeCredentials)
+	sr.HandleFunc("/credentials/", ah.wrapHandler(ah.CreateCredential)).Methods(allowedMethods...).Name(routeCredentials)
+	sr.HandleFunc("/challenge", ah.wrapHandler(ah.CreateChallenge)).Methods("GET", "OPTIONS").Name(routeChallenge)
+	sr.HandleFunc("/challenge/", ah.wrapHandler(ah.CreateChallenge)).Methods("GET", "OPTIONS").Name(routeChallenge)
+	sr.HandleFunc("/credentials/revoke", ah.wrapHandler(ah.RevokeCredential)).Methods("POST", "OPTIONS").Name(routeRevoke)
+	sr.HandleFunc("/credentials/revoke/", ah.wrapHandler(ah.RevokeCredential)).Methods("POST", "OPTIONS").Name(routeRevoke)
+	sr.HandleFunc("/info", ah.wrapHandler(ah.GetOperatorInfo)).Methods(allowedMethods...).Name(routeInfo)
+	sr.HandleFunc("/info/", ah.wrapHandler(ah.GetOperatorInfo)).Methods(allowedMethods...).Name(routeInfo)
 
 	// CORS support.
-	ch := cors.New(cors.Options{
-		AllowedOrigins:   origins,
-		AllowedMethods:   allowedMethods,
-		ExposedHeaders:   []string{"Accept", "Content-Type"},
-		AllowCredentials: false,
-		Debug:            logger.Level() == zap.DebugLevel,
-	})
-	sr.Use(ch.Handler)
+	sr.Use(cp.Handler)
+
+	// Rate limiting. CORS preflight requests are answered before reaching it,
+	// and rejections carry CORS headers, so that browsers can read Retry-After.
+	sr.Use(rl.Middleware)
 
 	return r
 }
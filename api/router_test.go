@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+// The Safe proxy factory and Safe setup functions, which counterfactual Safe wallets are deployed with.
+const safeABI = `[
+	{"name": "createProxyWithNonce", "type": "function", "inputs": [
+		{"name": "_singleton", "type": "address"},
+		{"name": "initializer", "type": "bytes"},
+		{"name": "saltNonce", "type": "uint256"}
+	]},
+	{"name": "setup", "type": "function", "inputs": [
+		{"name": "_owners", "type": "address[]"},
+		{"name": "_threshold", "type": "uint256"},
+		{"name": "to", "type": "address"},
+		{"name": "data", "type": "bytes"},
+		{"name": "fallbackHandler", "type": "address"},
+		{"name": "paymentToken", "type": "address"},
+		{"name": "payment", "type": "uint256"},
+		{"name": "paymentReceiver", "type": "address"}
+	]}
+]`
+
+// Wraps sig in an EIP-6492 signature that deploys a Safe with 5 owners, and enables modules
+// through a delegate call, as wallet SDKs do.
+func safeEIP6492Signature(t *testing.T, sig []byte) []byte {
+	t.Helper()
+	safe, err := abi.JSON(strings.NewReader(safeABI))
+	if err != nil {
+		t.Fatalf("Could not parse ABI: %v", err)
+	}
+
+	owners := make([]common.Address, 5)
+	for i := range owners {
+		owners[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+	}
+	// The module setup call, e.g. enabling a 4337 module and a passkey signer.
+	moduleSetup := bytes.Repeat([]byte{0xab}, 1024)
+	initializer, err := safe.Pack("setup", owners, big.NewInt(3),
+		common.HexToAddress("0x2dd68b007B46fBe91B9A7c3EDa5A7a1063cB5b47"), moduleSetup,
+		common.HexToAddress("0xfd0732Dc9E303f09fCEf3a7388Ad10A83459Ec99"), common.Address{}, big.NewInt(0), common.Address{})
+	if err != nil {
+		t.Fatalf("Could not pack setup: %v", err)
+	}
+	calldata, err := safe.Pack("createProxyWithNonce",
+		common.HexToAddress("0x29fcB43b46531BcA003ddC8FCB67FFE91900C762"), initializer, big.NewInt(1700000000))
+	if err != nil {
+		t.Fatalf("Could not pack factory call: %v", err)
+	}
+
+	wrapped, err := (&util.EIP6492Signature{
+		Factory:         common.HexToAddress("0x4e1DCf7AD4e460CfD30791CCC4F9c8a4f820ec67"),
+		FactoryCalldata: calldata,
+		Signature:       sig,
+	}).Bytes()
+	if err != nil {
+		t.Fatalf("Could not wrap signature: %v", err)
+	}
+	return wrapped
+}
+
+func TestCreateCredentialEIP6492(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, nodes := setupTestService(t, clock)
+	router := NewAPIRouter("/rescue/v1/", svc, NewCORSPolicy(nil, false), NewRateLimiter(nil, nil, clock, zap.NewNop()), zap.NewNop())
+
+	node, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	nodes.Add([]models.NodeID{*node.Address})
+	nodes.SetLastUpdated(clock.Now())
+
+	msg := fmt.Sprintf("Rescue Node %d", clock.Now().Unix())
+	inner, err := node.Sign([]byte(msg))
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	sig := safeEIP6492Signature(t, inner)
+
+	request := func(sig []byte) *httptest.ResponseRecorder {
+		body, err := json.Marshal(map[string]string{
+			"address": node.Address.Hex(),
+			"msg":     msg,
+			"sig":     hexutil.Encode(sig),
+			"version": "1",
+		})
+		if err != nil {
+			t.Fatalf("Could not encode request: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/rescue/v1/credentials", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The wrapped signature doesn't fit in the limit of other requests.
+	if len(sig)*2 <= maxRequestBytes {
+		t.Fatalf("Expected the signature to be larger than %d bytes once encoded, got %d", maxRequestBytes, len(sig)*2)
+	}
+	if w := request(sig); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the credential to be created, got %d: %s", w.Code, w.Body)
+	}
+
+	// Bodies are still limited.
+	if w := request(bytes.Repeat([]byte{0x01}, maxSignedRequestBytes)); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an oversized request to be rejected, got %d: %s", w.Code, w.Body)
+	}
+}
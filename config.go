@@ -6,21 +6,44 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
+	"os"
 	"strings"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/api"
+	"github.com/Rocket-Rescue-Node/rescue-api/external"
+	"github.com/Rocket-Rescue-Node/rescue-api/services"
+	"gopkg.in/yaml.v3"
 )
 
 // Application configuration.
 type config struct {
 	ListenAddr           string
 	MetricsAddr          string
+	AdminAddr            string
+	AdminTokens          map[string]string
 	CredentialSecret     []byte
 	DBPath               string
 	RescueProxyAPIAddr   string
+	RescueProxyTimeout   time.Duration
+	ExecutionClientURL   string
+	ExecutionTimeout     time.Duration
+	SignatureVerifiers   []string
+	EIP1271CacheTTL      time.Duration
+	EIP1271CacheSize     int
 	AllowedOrigins       []string
 	SecureGRPC           bool
+	RescueProxyTLS       external.TLSConfig
 	Debug                bool
 	EnableSoloValidators bool
+	AllowLegacyRequests  bool
+	ChainID              uint64
+	SIWE                 *services.SIWEConfig
+	Quotas               services.QuotaPolicy
+	RateLimits           api.RateLimitPolicy
+	TrustedProxies       []netip.Prefix
 }
 
 // Check that URL is valid.
@@ -39,23 +62,157 @@ func checkURL(data string, allowedSchemes ...string) error {
 	return errors.New("invalid URL scheme")
 }
 
-// Parse command-line arguments.
+// Parse a comma-separated list of name:token pairs.
+// Returns a map of names to tokens.
+func parseAdminTokens(data string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	if data == "" {
+		return tokens, nil
+	}
+	for _, pair := range strings.Split(data, ",") {
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			return nil, errors.New("expected name:token")
+		}
+		if len(token) < 32 {
+			return nil, fmt.Errorf("token for %s is shorter than 32 characters", name)
+		}
+		if _, ok := tokens[name]; ok {
+			return nil, fmt.Errorf("duplicate name %s", name)
+		}
+		tokens[name] = token
+	}
+	return tokens, nil
+}
+
+// Parse a comma-separated list of IPs and CIDR ranges.
+func parseTrustedProxies(data string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	if data == "" {
+		return prefixes, nil
+	}
+	for _, entry := range strings.Split(data, ",") {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("expected an IP or CIDR range, got %q", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Applies the settings in a YAML config file to the flags that were not set on the command line.
+// The file maps flag names to values, e.g.
+//
+//	enable-solo-validators: false
+//	allowed-origins: https://rescuenode.com
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]string
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	for name, value := range settings {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if setOnCommandLine[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Parse command-line arguments, and the config file if one is provided.
 // Returns a config struct with the parsed arguments.
-func parseArguments() (config, error) {
-	addr := flag.String("addr", "0.0.0.0:8080", "Address on which to listen to HTTP requests")
-	metricsAddr := flag.String("metrics-addr", "0.0.0.0:9000", "Address on which to listen for /metrics requests")
-	credentialSecret := flag.String("hmac-secret", "",
+func parseArguments(args []string, errorHandling flag.ErrorHandling) (config, error) {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	configPath := fs.String("config", "",
+		`Path to a YAML file with settings, keyed by flag name. Command-line flags take precedence.
+The file is read again on SIGHUP, and -allowed-origins, -debug, -enable-solo-validators,
+-allow-legacy-requests, -quota-config, -rate-limit-config and -trusted-proxies are applied without a restart.`,
+	)
+	addr := fs.String("addr", "0.0.0.0:8080", "Address on which to listen to HTTP requests")
+	metricsAddr := fs.String("metrics-addr", "0.0.0.0:9000", "Address on which to listen for /metrics requests")
+	adminAddr := fs.String("admin-addr", "", "Address on which to listen for admin API requests. The admin API is disabled if empty")
+	adminTokens := fs.String("admin-tokens", "",
+		`Comma-separated list of name:token pairs allowed to use the admin API.
+Tokens must be at least 32 characters long. Names are recorded in the audit trail.`,
+	)
+	credentialSecret := fs.String("hmac-secret", "",
 		`The secret to use for HMAC.
 Value must be at least 32 bytes of entropy, base64-encoded.
 Use 'dd if=/dev/urandom bs=4 count=8 | base64' if you need to generate a new secret.`,
 	)
-	dbPath := flag.String("db-path", "db.sqlite3", "sqlite3 database path")
-	proxyAPIAddr := flag.String("rescue-proxy-api-addr", "", "Address for the Rescue Proxy gRPC API")
-	allowedOrigins := flag.String("allowed-origins", "http://localhost:8080", "Comma-separated list of allowed CORS origins")
-	secureGRPC := flag.Bool("secure-grpc", true, "Whether to use gRPC over TLS")
-	debug := flag.Bool("debug", false, "Whether to enable verbose logging")
-	enableSoloValidators := flag.Bool("enable-solo-validators", true, "Whether or not to enable solo validator credentials")
-	flag.Parse()
+	dbPath := fs.String("db-path", "db.sqlite3", "sqlite3 database path")
+	proxyAPIAddr := fs.String("rescue-proxy-api-addr", "", "Address for the Rescue Proxy gRPC API")
+	proxyTimeout := fs.Duration("rescue-proxy-timeout", 5*time.Second, "How long each call to the Rescue Proxy gRPC API may take")
+	executionClientURL := fs.String("execution-client-url", "",
+		`Execution client JSON-RPC URL, used to validate EIP-6492 signatures of smart contract wallets
+that aren't deployed yet. If empty, such signatures are only accepted once the wallet is deployed`,
+	)
+	executionTimeout := fs.Duration("execution-client-timeout", 5*time.Second, "How long each call to -execution-client-url may take")
+	signatureVerifiers := fs.String("signature-verifiers", "eoa,proxy_eip1271",
+		`Comma-separated list of the verifiers that request signatures are checked with, in order.
+eoa: signed by the node's key. proxy_eip1271: EIP-1271 smart contract wallet, checked by the Rescue Proxy.
+rpc_eip1271: EIP-1271 smart contract wallet, checked with -execution-client-url`,
+	)
+	eip1271CacheTTL := fs.Duration("eip1271-cache-ttl", 5*time.Minute,
+		"How long EIP-1271 signature validation results, valid or not, are cached for. Results are not cached if 0",
+	)
+	eip1271CacheSize := fs.Int("eip1271-cache-size", 10000, "The maximum number of cached EIP-1271 signature validation results")
+	allowedOrigins := fs.String("allowed-origins", "http://localhost:8080", "Comma-separated list of allowed CORS origins")
+	secureGRPC := fs.Bool("secure-grpc", true, "Whether to use gRPC over TLS")
+	rescueProxyCA := fs.String("rescue-proxy-ca", "",
+		"Path to a PEM bundle of the CAs that issue the Rescue Proxy's certificate. The system's root CAs are used if empty",
+	)
+	rescueProxyCert := fs.String("rescue-proxy-cert", "",
+		"Path to a PEM client certificate presented to the Rescue Proxy. Requires -rescue-proxy-key",
+	)
+	rescueProxyKey := fs.String("rescue-proxy-key", "", "Path to the PEM private key of -rescue-proxy-cert")
+	rescueProxyServerName := fs.String("rescue-proxy-server-name", "",
+		"Name that the Rescue Proxy's certificate is checked against. The host in -rescue-proxy-api-addr is used if empty",
+	)
+	debug := fs.Bool("debug", false, "Whether to enable verbose logging")
+	enableSoloValidators := fs.Bool("enable-solo-validators", true, "Whether or not to enable solo validator credentials")
+	allowLegacyRequests := fs.Bool("allow-legacy-requests", true,
+		"Whether to accept request messages without a challenge nonce from /challenge. Such messages can be replayed until they are 15 minutes old",
+	)
+	chainID := fs.Uint64("chain-id", 1, "Chain ID that Sign-In with Ethereum and EIP-712 messages must be signed for")
+	siweDomain := fs.String("siwe-domain", "",
+		"Domain that Sign-In with Ethereum (EIP-4361) messages must be issued by, e.g. rescuenode.com. SIWE messages are not accepted if empty",
+	)
+	siweURI := fs.String("siwe-uri", "", "Scheme and host that Sign-In with Ethereum message URIs must match, e.g. https://rescuenode.com")
+	quotaConfig := fs.String("quota-config", "", "Path to a YAML file with the credential quota policy. Built-in defaults are used if empty")
+	rateLimitConfig := fs.String("rate-limit-config", "", "Path to a YAML file with the per-route rate limit policy. Built-in defaults are used if empty")
+	trustedProxies := fs.String("trusted-proxies", "",
+		"Comma-separated list of IPs or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted",
+	)
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			return config{}, fmt.Errorf("invalid -config argument: %v", err)
+		}
+	}
 
 	if *credentialSecret == "" {
 		return config{}, errors.New("missing -hmac-secret, at least one must be provided")
@@ -72,6 +229,96 @@ Use 'dd if=/dev/urandom bs=4 count=8 | base64' if you need to generate a new sec
 		return config{}, fmt.Errorf("invalid -rescue-proxy-api-addr argument: %v", err)
 	}
 
+	if *proxyTimeout <= 0 {
+		return config{}, errors.New("invalid -rescue-proxy-timeout argument: must be positive")
+	}
+	if *executionTimeout <= 0 {
+		return config{}, errors.New("invalid -execution-client-timeout argument: must be positive")
+	}
+
+	rescueProxyTLS := external.TLSConfig{
+		CAFile:     *rescueProxyCA,
+		CertFile:   *rescueProxyCert,
+		KeyFile:    *rescueProxyKey,
+		ServerName: *rescueProxyServerName,
+	}
+	if !*secureGRPC && rescueProxyTLS != (external.TLSConfig{}) {
+		return config{}, errors.New("-rescue-proxy-ca, -rescue-proxy-cert, -rescue-proxy-key and -rescue-proxy-server-name require -secure-grpc")
+	}
+	if (rescueProxyTLS.CertFile == "") != (rescueProxyTLS.KeyFile == "") {
+		return config{}, errors.New("-rescue-proxy-cert and -rescue-proxy-key must be provided together")
+	}
+
+	if *executionClientURL != "" {
+		if err := checkURL(*executionClientURL, "http", "https", "ws", "wss"); err != nil {
+			return config{}, fmt.Errorf("invalid -execution-client-url argument: %v", err)
+		}
+	}
+
+	verifiers := strings.Split(*signatureVerifiers, ",")
+	seenVerifiers := make(map[string]bool)
+	for _, name := range verifiers {
+		switch name {
+		case services.EOAVerifierName, services.ProxyEIP1271VerifierName:
+		case services.RPCEIP1271VerifierName:
+			if *executionClientURL == "" {
+				return config{}, fmt.Errorf("invalid -signature-verifiers argument: %s requires -execution-client-url", name)
+			}
+		default:
+			return config{}, fmt.Errorf("invalid -signature-verifiers argument: unknown verifier %q", name)
+		}
+		if seenVerifiers[name] {
+			return config{}, fmt.Errorf("invalid -signature-verifiers argument: duplicate verifier %s", name)
+		}
+		seenVerifiers[name] = true
+	}
+
+	if *eip1271CacheTTL < 0 {
+		return config{}, errors.New("invalid -eip1271-cache-ttl argument: must not be negative")
+	}
+	if *eip1271CacheSize <= 0 {
+		return config{}, errors.New("invalid -eip1271-cache-size argument: must be positive")
+	}
+
+	tokens, err := parseAdminTokens(*adminTokens)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid -admin-tokens argument: %v", err)
+	}
+	if *adminAddr != "" && len(tokens) == 0 {
+		return config{}, errors.New("-admin-addr requires at least one -admin-tokens entry")
+	}
+
+	quotas := services.DefaultQuotaPolicy()
+	if *quotaConfig != "" {
+		if quotas, err = services.LoadQuotaPolicy(*quotaConfig); err != nil {
+			return config{}, fmt.Errorf("invalid -quota-config argument: %v", err)
+		}
+	}
+
+	rateLimits := api.DefaultRateLimitPolicy()
+	if *rateLimitConfig != "" {
+		if rateLimits, err = api.LoadRateLimitPolicy(*rateLimitConfig); err != nil {
+			return config{}, fmt.Errorf("invalid -rate-limit-config argument: %v", err)
+		}
+	}
+
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid -trusted-proxies argument: %v", err)
+	}
+
+	var siwe *services.SIWEConfig
+	if *siweDomain != "" {
+		u, err := url.Parse(*siweURI)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Scheme+"://"+u.Host != *siweURI {
+			return config{}, errors.New("invalid -siwe-uri argument: expected a scheme and host, e.g. https://rescuenode.com")
+		}
+		siwe = &services.SIWEConfig{
+			Domain: *siweDomain,
+			URI:    *siweURI,
+		}
+	}
+
 	// Check that CORS allowed origins are valid.
 	origins := strings.Split(*allowedOrigins, ",")
 	if *allowedOrigins != "*" {
@@ -85,12 +332,27 @@ Use 'dd if=/dev/urandom bs=4 count=8 | base64' if you need to generate a new sec
 	return config{
 		ListenAddr:           *addr,
 		MetricsAddr:          *metricsAddr,
+		AdminAddr:            *adminAddr,
+		AdminTokens:          tokens,
 		CredentialSecret:     secret,
 		DBPath:               *dbPath,
 		RescueProxyAPIAddr:   *proxyAPIAddr,
+		RescueProxyTimeout:   *proxyTimeout,
+		ExecutionClientURL:   *executionClientURL,
+		ExecutionTimeout:     *executionTimeout,
+		SignatureVerifiers:   verifiers,
+		EIP1271CacheTTL:      *eip1271CacheTTL,
+		EIP1271CacheSize:     *eip1271CacheSize,
 		AllowedOrigins:       origins,
 		SecureGRPC:           *secureGRPC,
+		RescueProxyTLS:       rescueProxyTLS,
 		Debug:                *debug,
 		EnableSoloValidators: *enableSoloValidators,
+		AllowLegacyRequests:  *allowLegacyRequests,
+		ChainID:              *chainID,
+		SIWE:                 siwe,
+		Quotas:               quotas,
+		RateLimits:           rateLimits,
+		TrustedProxies:       proxies,
 	}, nil
 }
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A migration is a single step in the evolution of the database schema.
+// Each migration is applied exactly once, in a transaction, and bumps the
+// schema version stored in PRAGMA user_version.
+// Migrations must never be edited or reordered once released; append new ones instead.
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// The schema migrations, in order. The schema version is the index of the last applied migration plus one.
+var migrations = []migration{
+	{"adopt schema created before versioning", adoptUnversionedSchema},
+	{"create quota_overrides", createQuotaOverrides},
+	{"add emergency credits to credential_events", addEmergencyCredits},
+	{"create registry snapshots", createRegistrySnapshots},
+	{"key revocation events by the revoked credential", keyRevocationsByCredential},
+	{"key authorization rules by action", keyAuthorizationRulesByAction},
+	{"create quota_overrides_audit", createQuotaOverridesAudit},
+	{"create emergency_credits_audit", createEmergencyCreditsAudit},
+}
+
+// Applies all pending schema migrations.
+// Returns an error if the database schema is newer than the latest known migration,
+// which happens when running an older binary against a database migrated by a newer one.
+func Migrate(db *sql.DB) error {
+	return migrate(db, migrations)
+}
+
+func migrate(db *sql.DB, migrations []migration) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d", version, latest)
+	}
+
+	for i := version; i < latest; i++ {
+		if err := applyMigration(db, i+1, &migrations[i]); err != nil {
+			return fmt.Errorf("migration to schema version %d (%s) failed: %w", i+1, migrations[i].description, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, version int, m *migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	// PRAGMA statements do not accept bound parameters.
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version;").Scan(&version)
+	return version, err
+}
+
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	var c int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;`, table, column).Scan(&c)
+	return c > 0, err
+}
+
+// Databases created before schema versioning have user_version 0, and may be in any
+// of the states produced by earlier releases. Bring all of them to the same schema.
+func adoptUnversionedSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS credential_events (
+			node_id BLOB(20) NOT NULL,
+			timestamp INTEGER NOT NULL,
+			type INTEGER CHECK (type >= 0 AND type <= 1) NOT NULL,
+			PRIMARY KEY (node_id, timestamp)
+		);
+		CREATE TABLE IF NOT EXISTS authorization_rules (
+			node_id BLOB(20) NOT NULL,
+			resource INTEGER CHECK (resource >= 0 AND resource <=1) NOT NULL,
+			action INTEGER CHECK (action >= 0 AND action <= 1) NOT NULL,
+			PRIMARY KEY (node_id, resource)
+		);
+		CREATE TABLE IF NOT EXISTS authorization_rules_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			operation INTEGER CHECK (operation >= 0 AND operation <= 1) NOT NULL,
+			node_id BLOB(20) NOT NULL,
+			resource INTEGER NOT NULL,
+			action INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// If operator_type isn't on the table, create it
+	ok, err := hasColumn(tx, "credential_events", "operator_type")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Update the primary key by copying the table, dropping the old version, and renaming it.
+		// Insert 0s for operator_type, as all events prior to the migration were RP NOs, who have operator_type 0.
+		if _, err := tx.Exec(`
+			CREATE TABLE _credential_events_copy (
+				node_id BLOB(20) NOT NULL,
+				timestamp INTEGER NOT NULL,
+				type INTEGER CHECK (type >= 0 AND type <= 1) NOT NULL,
+				operator_type INTEGER NOT NULL,
+				PRIMARY KEY (node_id, operator_type, timestamp)
+			);
+
+			INSERT INTO _credential_events_copy (node_id, timestamp, type, operator_type)
+				SELECT node_id, timestamp, type, 0 FROM credential_events;
+			DROP TABLE credential_events;
+			ALTER TABLE _credential_events_copy RENAME TO credential_events;
+		`); err != nil {
+			return err
+		}
+	}
+
+	// If expires_at isn't on the authorization rules, add the time bounds and annotations.
+	// Existing rules are carried over with no time bounds, so they remain permanent.
+	ok, err = hasColumn(tx, "authorization_rules", "expires_at")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if _, err := tx.Exec(`
+			ALTER TABLE authorization_rules ADD COLUMN not_before INTEGER;
+			ALTER TABLE authorization_rules ADD COLUMN expires_at INTEGER;
+			ALTER TABLE authorization_rules ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+			ALTER TABLE authorization_rules ADD COLUMN creator TEXT NOT NULL DEFAULT '';
+
+			ALTER TABLE authorization_rules_audit ADD COLUMN not_before INTEGER;
+			ALTER TABLE authorization_rules_audit ADD COLUMN expires_at INTEGER;
+			ALTER TABLE authorization_rules_audit ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+		`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Per-node overrides of the quota policy. NULL columns fall back to the policy.
+// Windows are stored in seconds.
+func createQuotaOverrides(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE quota_overrides (
+			node_id BLOB(20) NOT NULL,
+			operator_type INTEGER NOT NULL,
+			count INTEGER CHECK (count >= 0),
+			window_secs INTEGER CHECK (window_secs > 0),
+			auth_validity_window_secs INTEGER CHECK (auth_validity_window_secs > 0),
+			reason TEXT NOT NULL DEFAULT '',
+			creator TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (node_id, operator_type)
+		);
+	`)
+	return err
+}
+
+// Emergency credits are tracked in credential_events: a "credits granted" event adds credits,
+// and a credential issued beyond the quota consumes one. The credits column holds each event's
+// change to the balance. The table is copied to allow the new event type, and to add the type to
+// the primary key so that events of different types can share a timestamp.
+func addEmergencyCredits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE _credential_events_copy (
+			node_id BLOB(20) NOT NULL,
+			timestamp INTEGER NOT NULL,
+			type INTEGER CHECK (type >= 0 AND type <= 2) NOT NULL,
+			operator_type INTEGER NOT NULL,
+			credits INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (node_id, operator_type, type, timestamp)
+		);
+
+		INSERT INTO _credential_events_copy (node_id, timestamp, type, operator_type)
+			SELECT node_id, timestamp, type, operator_type FROM credential_events;
+		DROP TABLE credential_events;
+		ALTER TABLE _credential_events_copy RENAME TO credential_events;
+	`)
+	return err
+}
+
+// Snapshots of the node registries, saved after each successful update,
+// so that they can be used as soon as the service restarts.
+func createRegistrySnapshots(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE registry_snapshots (
+			name TEXT PRIMARY KEY,
+			last_updated INTEGER NOT NULL
+		);
+		CREATE TABLE registry_snapshot_entries (
+			name TEXT NOT NULL,
+			node_id BLOB(20) NOT NULL,
+			PRIMARY KEY (name, node_id)
+		);
+	`)
+	return err
+}
+
+// "Credential revoked" events used to be stored at the time of the revocation, and matched any
+// credential issued up to that second. They now carry the timestamp of the credential they revoke.
+// Existing events are moved to the last credential issued at or before them.
+func keyRevocationsByCredential(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		UPDATE OR IGNORE credential_events AS r SET timestamp = COALESCE((
+			SELECT MAX(i.timestamp) FROM credential_events AS i
+			WHERE i.node_id = r.node_id AND i.operator_type = r.operator_type
+			AND i.type = 0 AND i.timestamp <= r.timestamp
+		), r.timestamp)
+		WHERE r.type = 1;
+	`)
+	return err
+}
+
+// A node used to hold a single rule per resource, so a temporary Deny replaced a permanent Allow.
+// Rules are now keyed by action too, so that both can be set, and the Allow applies again once the Deny expires.
+func keyAuthorizationRulesByAction(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE _authorization_rules_copy (
+			node_id BLOB(20) NOT NULL,
+			resource INTEGER CHECK (resource >= 0 AND resource <=1) NOT NULL,
+			action INTEGER CHECK (action >= 0 AND action <= 1) NOT NULL,
+			not_before INTEGER,
+			expires_at INTEGER,
+			reason TEXT NOT NULL DEFAULT '',
+			creator TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (node_id, resource, action)
+		);
+
+		INSERT INTO _authorization_rules_copy (node_id, resource, action, not_before, expires_at, reason, creator)
+			SELECT node_id, resource, action, not_before, expires_at, reason, creator FROM authorization_rules;
+		DROP TABLE authorization_rules;
+		ALTER TABLE _authorization_rules_copy RENAME TO authorization_rules;
+	`)
+	return err
+}
+
+// The audit trail of quota overrides, like authorization_rules_audit. Deletions record the deleted override.
+func createQuotaOverridesAudit(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE quota_overrides_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			operation INTEGER CHECK (operation >= 0 AND operation <= 1) NOT NULL,
+			node_id BLOB(20) NOT NULL,
+			operator_type INTEGER NOT NULL,
+			count INTEGER,
+			window_secs INTEGER,
+			auth_validity_window_secs INTEGER,
+			reason TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	return err
+}
+
+// The audit trail of emergency credit grants. The grants themselves are credential_events.
+func createEmergencyCreditsAudit(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE emergency_credits_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			node_id BLOB(20) NOT NULL,
+			operator_type INTEGER NOT NULL,
+			credits INTEGER NOT NULL
+		);
+	`)
+	return err
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	// Open limits the pool to a single connection, so every query sees the same in-memory database.
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatalf("Could not read schema version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("Incorrect schema version. Expected %d, got %d", len(migrations), version)
+	}
+
+	// Migrating again is a no-op.
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database twice: %v", err)
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	// The schema created by releases before versioning, with existing data.
+	if _, err := db.Exec(`
+		CREATE TABLE credential_events (
+			node_id BLOB(20) NOT NULL,
+			timestamp INTEGER NOT NULL,
+			type INTEGER CHECK (type >= 0 AND type <= 1) NOT NULL,
+			PRIMARY KEY (node_id, timestamp)
+		);
+		CREATE TABLE authorization_rules (
+			node_id BLOB(20) NOT NULL,
+			resource INTEGER CHECK (resource >= 0 AND resource <=1) NOT NULL,
+			action INTEGER CHECK (action >= 0 AND action <= 1) NOT NULL,
+			PRIMARY KEY (node_id, resource)
+		);
+		INSERT INTO credential_events (node_id, timestamp, type) VALUES (x'01', 1700000000, 0);
+		INSERT INTO authorization_rules (node_id, resource, action) VALUES (x'02', 0, 1);
+	`); err != nil {
+		t.Fatalf("Could not create unversioned schema: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+
+	// Existing credential events are assigned to Rocket Pool node operators, and do not affect credits.
+	var operatorType, credits int
+	if err := db.QueryRow(`SELECT operator_type, credits FROM credential_events WHERE node_id = x'01';`).Scan(&operatorType, &credits); err != nil {
+		t.Fatalf("Could not read credential event: %v", err)
+	}
+	if operatorType != 0 || credits != 0 {
+		t.Fatalf("Unexpected credential event: operator type %d, credits %d", operatorType, credits)
+	}
+
+	// Existing rules are carried over without time bounds.
+	var action int
+	var expiresAt sql.NullInt64
+	if err := db.QueryRow(`SELECT action, expires_at FROM authorization_rules WHERE node_id = x'02';`).Scan(&action, &expiresAt); err != nil {
+		t.Fatalf("Could not read authorization rule: %v", err)
+	}
+	if action != 1 || expiresAt.Valid {
+		t.Fatalf("Unexpected authorization rule: action %d, expires_at %v", action, expiresAt)
+	}
+
+	// The node can also get a rule with the other action.
+	if _, err := db.Exec(`INSERT INTO authorization_rules (node_id, resource, action) VALUES (x'02', 0, 0);`); err != nil {
+		t.Fatalf("Could not add an allow rule next to the deny rule: %v", err)
+	}
+}
+
+func TestMigrateRevocationEvents(t *testing.T) {
+	db := openTestDB(t)
+
+	// Migrate up to the step that keys revocation events by credential.
+	var step int
+	for i, m := range migrations {
+		if m.description == "key revocation events by the revoked credential" {
+			step = i
+		}
+	}
+	if err := migrate(db, migrations[:step]); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+
+	// Credentials issued at 100 and 200, each revoked a few seconds later.
+	if _, err := db.Exec(`
+		INSERT INTO credential_events (node_id, timestamp, type, operator_type) VALUES
+			(x'01', 100, 0, 0), (x'01', 105, 1, 0), (x'01', 200, 0, 0), (x'01', 230, 1, 0);
+	`); err != nil {
+		t.Fatalf("Could not insert credential events: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+	rows, err := db.Query(`SELECT timestamp FROM credential_events WHERE type = 1 ORDER BY timestamp;`)
+	if err != nil {
+		t.Fatalf("Could not read credential events: %v", err)
+	}
+	defer rows.Close()
+	var timestamps []int64
+	for rows.Next() {
+		var ts int64
+		if err := rows.Scan(&ts); err != nil {
+			t.Fatalf("Could not scan credential event: %v", err)
+		}
+		timestamps = append(timestamps, ts)
+	}
+	if len(timestamps) != 2 || timestamps[0] != 100 || timestamps[1] != 200 {
+		t.Fatalf("Expected revocations of the credentials issued at 100 and 200, got %v", timestamps)
+	}
+}
+
+func TestMigrateSteps(t *testing.T) {
+	db := openTestDB(t)
+
+	steps := []migration{
+		{"create table", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE t (a INTEGER);`)
+			return err
+		}},
+		{"fail halfway", func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE t ADD COLUMN b INTEGER;`); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}},
+	}
+
+	// The failing step is rolled back, and the version only reflects the successful one.
+	if err := migrate(db, steps); err == nil {
+		t.Fatalf("Expected migration to fail")
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatalf("Could not read schema version: %v", err)
+	}
+	if version != 1 {
+		t.Fatalf("Incorrect schema version. Expected 1, got %d", version)
+	}
+	var c int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('t') WHERE name = 'b';`).Scan(&c); err != nil {
+		t.Fatalf("Could not read table info: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("Failed migration was not rolled back")
+	}
+
+	// A database that is newer than the known migrations is refused.
+	if _, err := db.Exec(`PRAGMA user_version = 5;`); err != nil {
+		t.Fatalf("Could not set schema version: %v", err)
+	}
+	if err := migrate(db, steps[:1]); err == nil {
+		t.Fatalf("Expected newer database to be refused")
+	}
+}
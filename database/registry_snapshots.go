@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveRegistrySnapshot replaces the snapshot of a registry with ids, updated at lastUpdated.
+func SaveRegistrySnapshot(db *sql.DB, name string, ids []common.Address, lastUpdated time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec(`DELETE FROM registry_snapshot_entries WHERE name = ?;`, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO registry_snapshots (name, last_updated) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET last_updated = excluded.last_updated;
+	`, name, lastUpdated.Unix()); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO registry_snapshot_entries (name, node_id) VALUES (?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		if _, err := stmt.Exec(name, id.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateRegistrySnapshot applies the changes of an update to the snapshot of a registry:
+// added ids are inserted, removed ones are deleted, and the others are left alone.
+// Only the time of the update is written if nothing changed.
+func UpdateRegistrySnapshot(db *sql.DB, name string, added []common.Address, removed []common.Address, lastUpdated time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec(`
+		INSERT INTO registry_snapshots (name, last_updated) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET last_updated = excluded.last_updated;
+	`, name, lastUpdated.Unix()); err != nil {
+		return err
+	}
+
+	if len(removed) > 0 {
+		stmt, err := tx.Prepare(`DELETE FROM registry_snapshot_entries WHERE name = ? AND node_id = ?;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, id := range removed {
+			if _, err := stmt.Exec(name, id.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	if len(added) > 0 {
+		stmt, err := tx.Prepare(`INSERT OR IGNORE INTO registry_snapshot_entries (name, node_id) VALUES (?, ?);`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, id := range added {
+			if _, err := stmt.Exec(name, id.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadRegistrySnapshot returns the ids in the snapshot of a registry, and when it was updated.
+// The returned time is zero if there is no snapshot.
+func LoadRegistrySnapshot(db *sql.DB, name string) ([]common.Address, time.Time, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var lastUpdated int64
+	err = tx.QueryRow(`SELECT last_updated FROM registry_snapshots WHERE name = ?;`, name).Scan(&lastUpdated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	rows, err := tx.Query(`SELECT node_id FROM registry_snapshot_entries WHERE name = ?;`, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+	ids := []common.Address{}
+	for rows.Next() {
+		var id []byte
+		if err := rows.Scan(&id); err != nil {
+			return nil, time.Time{}, err
+		}
+		ids = append(ids, common.BytesToAddress(id))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return ids, time.Unix(lastUpdated, 0), nil
+}
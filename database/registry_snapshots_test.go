@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRegistrySnapshots(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+
+	// There is no snapshot yet.
+	ids, lastUpdated, err := LoadRegistrySnapshot(db, "nodes")
+	if err != nil {
+		t.Fatalf("Could not load snapshot: %v", err)
+	}
+	if len(ids) != 0 || !lastUpdated.IsZero() {
+		t.Fatalf("Expected no snapshot, got %v at %v", ids, lastUpdated)
+	}
+
+	a := common.HexToAddress("0x01")
+	b := common.HexToAddress("0x02")
+	c := common.HexToAddress("0x03")
+	now := time.Unix(1700000000, 0)
+	if err := SaveRegistrySnapshot(db, "nodes", []common.Address{a, b}, now); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+	if err := SaveRegistrySnapshot(db, "withdrawal_addresses", []common.Address{c}, now); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+
+	// Saving again replaces the snapshot, and leaves the others alone.
+	later := now.Add(time.Hour)
+	if err := SaveRegistrySnapshot(db, "nodes", []common.Address{b, c, c}, later); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+
+	for name, expected := range map[string]struct {
+		ids         []common.Address
+		lastUpdated time.Time
+	}{
+		"nodes":                {[]common.Address{b, c}, later},
+		"withdrawal_addresses": {[]common.Address{c}, now},
+	} {
+		expectSnapshot(t, db, name, expected.ids, expected.lastUpdated)
+	}
+
+	// Updates only apply their changes.
+	d := common.HexToAddress("0x04")
+	latest := later.Add(time.Hour)
+	if err := UpdateRegistrySnapshot(db, "nodes", []common.Address{a, d}, []common.Address{b}, latest); err != nil {
+		t.Fatalf("Could not update snapshot: %v", err)
+	}
+	expectSnapshot(t, db, "nodes", []common.Address{a, c, d}, latest)
+	expectSnapshot(t, db, "withdrawal_addresses", []common.Address{c}, now)
+
+	// Only the time is written when nothing changed.
+	latest = latest.Add(time.Hour)
+	if err := UpdateRegistrySnapshot(db, "nodes", nil, nil, latest); err != nil {
+		t.Fatalf("Could not update snapshot: %v", err)
+	}
+	expectSnapshot(t, db, "nodes", []common.Address{a, c, d}, latest)
+
+	// Updating a registry without a snapshot creates it.
+	if err := UpdateRegistrySnapshot(db, "other", []common.Address{a}, nil, latest); err != nil {
+		t.Fatalf("Could not update snapshot: %v", err)
+	}
+	expectSnapshot(t, db, "other", []common.Address{a}, latest)
+}
+
+func expectSnapshot(t *testing.T, db *sql.DB, name string, expectedIDs []common.Address, expectedLastUpdated time.Time) {
+	t.Helper()
+	ids, lastUpdated, err := LoadRegistrySnapshot(db, name)
+	if err != nil {
+		t.Fatalf("Could not load snapshot: %v", err)
+	}
+	slices.SortFunc(ids, func(x, y common.Address) int { return x.Cmp(y) })
+	if !slices.Equal(ids, expectedIDs) || !lastUpdated.Equal(expectedLastUpdated) {
+		t.Fatalf("Unexpected %s snapshot %v at %v", name, ids, lastUpdated)
+	}
+}
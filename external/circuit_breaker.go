@@ -0,0 +1,201 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The number of consecutive failures that open a circuit breaker, and how long it stays open.
+const (
+	circuitFailureThreshold = 5
+	circuitOpenDuration     = 30 * time.Second
+)
+
+type circuitState int
+
+const (
+	// Calls are made, and failures counted.
+	circuitClosed circuitState = iota
+	// Calls are refused until the breaker is half-open.
+	circuitOpen
+	// A single call is made to tell whether the dependency recovered.
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitOpenError is returned instead of calling a dependency that recently failed repeatedly.
+// The call can be retried after RetryAfter.
+type CircuitOpenError struct {
+	Method     string
+	retryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable, retry in %s", e.Method, e.retryAfter)
+}
+
+func (e *CircuitOpenError) Is(err error) bool {
+	_, ok := err.(*CircuitOpenError)
+	return ok
+}
+
+// RetryAfter returns how long to wait before the call is attempted again.
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// circuitAdmission tags a call with the state of the breaker it was let through in.
+type circuitAdmission struct {
+	state circuitState
+	// The number of state changes before the call, so that calls that outlive the state they
+	// were let through in can be told apart.
+	generation uint64
+}
+
+// circuitBreaker stops calling a method of a dependency once it failed circuitFailureThreshold
+// times in a row. After circuitOpenDuration, a single call is let through; the breaker closes
+// again if it succeeds, and opens for another circuitOpenDuration otherwise.
+// Only the results of that call, and of calls let through since the breaker last closed,
+// change its state.
+type circuitBreaker struct {
+	method string
+	clock  clockwork.Clock
+	logger *zap.Logger
+	m      *metrics.MetricsRegistry
+
+	lock       sync.Mutex
+	state      circuitState
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	// Whether the call of the half-open state is in progress.
+	probing bool
+}
+
+func newCircuitBreaker(method string, clock clockwork.Clock, logger *zap.Logger, m *metrics.MetricsRegistry) *circuitBreaker {
+	b := &circuitBreaker{
+		method: method,
+		clock:  clock,
+		logger: logger,
+		m:      m,
+	}
+	b.m.Gauge("circuit_state_" + method).Set(float64(circuitClosed))
+	return b
+}
+
+// The caller must hold the lock.
+func (b *circuitBreaker) setState(state circuitState) {
+	if b.state == state {
+		return
+	}
+	if state == circuitOpen {
+		b.logger.Warn("rescue-proxy circuit breaker opened", zap.String("method", b.method), zap.Duration("duration", circuitOpenDuration))
+	} else {
+		b.logger.Info("rescue-proxy circuit breaker state changed", zap.String("method", b.method), zap.Stringer("state", state))
+	}
+	b.state = state
+	b.generation++
+	b.m.Gauge("circuit_state_" + b.method).Set(float64(state))
+	b.m.Counter("circuit_" + state.String() + "_" + b.method).Inc()
+}
+
+// allow returns a *CircuitOpenError if the method must not be called now, and otherwise
+// the state the call is let through in. Calls let through while half-open tell whether the
+// dependency recovered.
+func (b *circuitBreaker) allow() (circuitAdmission, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == circuitOpen {
+		if wait := b.openedAt.Add(circuitOpenDuration).Sub(b.clock.Now()); wait > 0 {
+			b.m.Counter("circuit_rejected_" + b.method).Inc()
+			return circuitAdmission{}, &CircuitOpenError{Method: b.method, retryAfter: wait}
+		}
+		b.setState(circuitHalfOpen)
+	}
+	if b.state == circuitHalfOpen {
+		if b.probing {
+			// Another call is telling whether the dependency recovered.
+			b.m.Counter("circuit_rejected_" + b.method).Inc()
+			return circuitAdmission{}, &CircuitOpenError{Method: b.method, retryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	return circuitAdmission{state: b.state, generation: b.generation}, nil
+}
+
+// Whether err tells that the dependency is unavailable, rather than that the call was invalid.
+func isDependencyFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// record updates the breaker with the result of a call that was allowed. ctx is the caller's
+// context: calls abandoned by the caller tell nothing about the dependency.
+// Calls that were let through before the breaker last changed state are ignored, e.g. a slow
+// call that started before the breaker opened.
+func (b *circuitBreaker) record(ctx context.Context, admission circuitAdmission, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	probe := admission.state == circuitHalfOpen
+	if probe {
+		b.probing = false
+	}
+	if admission.generation != b.generation {
+		b.m.Counter("circuit_stale_" + b.method).Inc()
+		return
+	}
+	switch {
+	case ctx.Err() != nil && err != nil:
+		// In the half-open state, the next call tells whether the dependency recovered.
+	case err == nil || !isDependencyFailure(err):
+		b.failures = 0
+		b.setState(circuitClosed)
+	default:
+		b.failures++
+		if probe || (b.state == circuitClosed && b.failures >= circuitFailureThreshold) {
+			b.failures = 0
+			b.openedAt = b.clock.Now()
+			b.setState(circuitOpen)
+		}
+	}
+}
+
+// call calls fn unless the breaker is open, and records its result.
+// fn is called with ctx, limited to timeout.
+func (b *circuitBreaker) call(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	admission, err := b.allow()
+	if err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = fn(callCtx)
+	b.record(ctx, admission, err)
+	return err
+}
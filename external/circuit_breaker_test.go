@@ -0,0 +1,154 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	initTestMetrics(t)
+	clock := clockwork.NewFakeClock()
+	b := newCircuitBreaker("test", clock, zap.NewNop(), metrics.NewMetricsRegistry("circuit_breaker"))
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	invalid := status.Error(codes.InvalidArgument, "invalid request")
+	call := func(err error) error {
+		return b.call(context.Background(), time.Second, func(context.Context) error {
+			return err
+		})
+	}
+	expectOpen := func(retryAfter time.Duration) {
+		t.Helper()
+		var open *CircuitOpenError
+		if err := call(nil); !errors.As(err, &open) {
+			t.Fatalf("Expected the breaker to be open, got %v", err)
+		}
+		if open.RetryAfter() != retryAfter {
+			t.Fatalf("Expected retry after %s, got %s", retryAfter, open.RetryAfter())
+		}
+	}
+
+	// Only consecutive failures of the dependency open the breaker.
+	for i := 0; i < circuitFailureThreshold-1; i++ {
+		if err := call(unavailable); err != unavailable {
+			t.Fatalf("Expected the call to be made, got %v", err)
+		}
+	}
+	if err := call(invalid); err != invalid {
+		t.Fatalf("Expected the call to be made, got %v", err)
+	}
+	for i := 0; i < circuitFailureThreshold-1; i++ {
+		if err := call(unavailable); err != unavailable {
+			t.Fatalf("Expected the call to be made, got %v", err)
+		}
+	}
+
+	// Calls abandoned by the caller are not counted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := b.call(ctx, time.Second, func(ctx context.Context) error {
+		return status.FromContextError(ctx.Err()).Err()
+	})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected the call to be made, got %v", err)
+	}
+
+	if err := call(unavailable); err != unavailable {
+		t.Fatalf("Expected the call to be made, got %v", err)
+	}
+	expectOpen(circuitOpenDuration)
+	clock.Advance(circuitOpenDuration / 2)
+	expectOpen(circuitOpenDuration / 2)
+
+	// Once half-open, a single call tells whether the dependency recovered.
+	clock.Advance(circuitOpenDuration / 2)
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	probed := make(chan error)
+	go func() {
+		probed <- b.call(context.Background(), time.Second, func(context.Context) error {
+			close(probing)
+			<-release
+			return unavailable
+		})
+	}()
+	<-probing
+	expectOpen(time.Second)
+	close(release)
+	if err := <-probed; err != unavailable {
+		t.Fatalf("Expected the probe to fail, got %v", err)
+	}
+
+	// The breaker opens again when the probe fails, and closes when it succeeds.
+	expectOpen(circuitOpenDuration)
+	clock.Advance(circuitOpenDuration)
+	if err := call(nil); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	if err := call(unavailable); err != unavailable {
+		t.Fatalf("Expected the breaker to be closed, got %v", err)
+	}
+
+	// A slow call that started before the breaker opened doesn't close it.
+	started := make(chan struct{})
+	release = make(chan struct{})
+	slow := make(chan error)
+	go func() {
+		slow <- b.call(context.Background(), time.Second, func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	for i := 0; i < circuitFailureThreshold-1; i++ {
+		if err := call(unavailable); err != unavailable {
+			t.Fatalf("Expected the call to be made, got %v", err)
+		}
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("Expected the slow call to succeed, got %v", err)
+	}
+	expectOpen(circuitOpenDuration)
+}
+
+func TestRescueProxyAPIClientCircuitBreaker(t *testing.T) {
+	initTestMetrics(t)
+
+	// Nothing listens on the address anymore.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	listener.Close()
+
+	client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < circuitFailureThreshold; i++ {
+		if _, err := client.GetRocketPoolNodes(context.Background()); status.Code(err) != codes.Unavailable {
+			t.Fatalf("Expected the rescue-proxy to be unavailable, got %v", err)
+		}
+	}
+	if _, err := client.GetRocketPoolNodes(context.Background()); !errors.Is(err, &CircuitOpenError{}) {
+		t.Fatalf("Expected the circuit breaker to be open, got %v", err)
+	}
+
+	// Breakers are per method.
+	if _, err := client.GetWithdrawalAddresses(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected the rescue-proxy to be unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// EVM opcodes used by the validator program.
+const (
+	opPop        = 0x50
+	opMstore     = 0x52
+	opGas        = 0x5a
+	opCodecopy   = 0x39
+	opCall       = 0xf1
+	opStaticcall = 0xfa
+	opReturn     = 0xf3
+	opPush1      = 0x60
+	opPush4      = 0x63
+	opPush20     = 0x73
+)
+
+// A minimal EVM assembler, used to build the contract creation code that validates a signature.
+type evmProgram struct {
+	bytes.Buffer
+}
+
+func (p *evmProgram) op(ops ...byte) {
+	p.Write(ops)
+}
+
+func (p *evmProgram) push1(v byte) {
+	p.op(opPush1, v)
+}
+
+// Pushes a 32-bit value. Offsets and lengths always use PUSH4, so that the length of the
+// program doesn't depend on the data it refers to.
+func (p *evmProgram) push4(v uint32) {
+	p.op(opPush4)
+	_ = binary.Write(p, binary.BigEndian, v)
+}
+
+func (p *evmProgram) pushAddress(a common.Address) {
+	p.op(opPush20)
+	p.Write(a.Bytes())
+}
+
+// Copies length bytes, starting at offset in the code, to memory at 0.
+func (p *evmProgram) codecopy(offset, length uint32) {
+	p.push4(length)
+	p.push4(offset)
+	p.push1(0)
+	p.op(opCodecopy)
+}
+
+// Builds the creation code of the validator. It returns isValidSignature's return data,
+// followed by whether the call succeeded, instead of deploying a contract.
+func eip6492ValidatorCode(dataHash *common.Hash, signature *util.EIP6492Signature, address *common.Address) ([]byte, error) {
+	args, err := eip1271Arguments.Pack(*dataHash, signature.Signature)
+	if err != nil {
+		return nil, err
+	}
+	isValidSignatureCalldata := append(append([]byte{}, eip1271MagicValue...), args...)
+	factoryCalldata := signature.FactoryCalldata
+
+	// Return data is written past both calldatas, where memory is still zeroed, so
+	// a call that returns nothing can't be mistaken for a valid signature.
+	result := uint32(len(factoryCalldata))
+	if len(isValidSignatureCalldata) > len(factoryCalldata) {
+		result = uint32(len(isValidSignatureCalldata))
+	}
+	result = (result + 31) / 32 * 32
+
+	build := func(dataOffset uint32) *evmProgram {
+		p := new(evmProgram)
+
+		// Deploy the wallet. The factory may revert if it already was, so the result is ignored.
+		p.codecopy(dataOffset, uint32(len(factoryCalldata)))
+		p.push1(0)
+		p.push1(0)
+		p.push4(uint32(len(factoryCalldata)))
+		p.push1(0)
+		p.push1(0)
+		p.pushAddress(signature.Factory)
+		p.op(opGas, opCall, opPop)
+
+		// Call isValidSignature(dataHash, signature) on the wallet.
+		p.codecopy(dataOffset+uint32(len(factoryCalldata)), uint32(len(isValidSignatureCalldata)))
+		p.push1(32)
+		p.push4(result)
+		p.push4(uint32(len(isValidSignatureCalldata)))
+		p.push1(0)
+		p.pushAddress(*address)
+		p.op(opGas, opStaticcall)
+
+		// Return the first word of return data, and whether the call succeeded.
+		p.push4(result + 32)
+		p.op(opMstore)
+		p.push1(64)
+		p.push4(result)
+		p.op(opReturn)
+		return p
+	}
+
+	// The program has the same length regardless of the offsets it refers to.
+	code := build(uint32(build(0).Len()))
+	code.Write(factoryCalldata)
+	code.Write(isValidSignatureCalldata)
+	return code.Bytes(), nil
+}
+
+// ValidateEIP6492 validates an EIP-6492 signature with eth_call, by simulating the creation of a
+// contract which deploys the wallet using the factory calldata, and then calls isValidSignature on it.
+// Nothing is deployed on chain, and wallets that were already deployed are supported too.
+func (c *ExecutionClient) ValidateEIP6492(ctx context.Context, dataHash *common.Hash, signature *util.EIP6492Signature, address *common.Address) (bool, error) {
+	code, err := eip6492ValidatorCode(dataHash, signature, address)
+	if err != nil {
+		return false, err
+	}
+
+	c.logger.Debug("requesting eip6492 validation", zap.String("address", address.Hex()))
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	r, err := c.caller.CallContract(ctx, ethereum.CallMsg{Data: code}, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(r) != 64 {
+		return false, fmt.Errorf("unexpected validator result length %d", len(r))
+	}
+	if r[63] != 1 {
+		// isValidSignature reverted, e.g. because the wallet doesn't exist.
+		return false, nil
+	}
+	return bytes.Equal(r[:4], eip1271MagicValue), nil
+}
@@ -0,0 +1,234 @@
+package external
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+)
+
+// Opcodes used by the test contracts, in addition to the validator's.
+const (
+	opStop         = 0x00
+	opEq           = 0x14
+	opShl          = 0x1b
+	opShr          = 0x1c
+	opCalldataload = 0x35
+	opMload        = 0x51
+	opJumpi        = 0x57
+	opJumpdest     = 0x5b
+	opCreate2      = 0xf5
+)
+
+// Returns creation code that deploys runtime.
+func testDeployCode(runtime []byte) []byte {
+	build := func(offset uint32) *evmProgram {
+		p := new(evmProgram)
+		p.codecopy(offset, uint32(len(runtime)))
+		p.push4(uint32(len(runtime)))
+		p.push1(0)
+		p.op(opReturn)
+		return p
+	}
+	code := build(uint32(build(0).Len()))
+	code.Write(runtime)
+	return code.Bytes()
+}
+
+// Returns the runtime code of a wallet which implements isValidSignature(bytes32, bytes)
+// by checking that the 65-byte signature was made by owner.
+func testWalletCode(owner common.Address) []byte {
+	p := new(evmProgram)
+	// Lay out ecrecover's input in memory: hash, v, r, s.
+	p.push1(4)
+	p.op(opCalldataload)
+	p.push1(0)
+	p.op(opMstore)
+	p.push1(164)
+	p.op(opCalldataload)
+	p.push1(248)
+	p.op(opShr)
+	p.push1(32)
+	p.op(opMstore)
+	p.push1(100)
+	p.op(opCalldataload)
+	p.push1(64)
+	p.op(opMstore)
+	p.push1(132)
+	p.op(opCalldataload)
+	p.push1(96)
+	p.op(opMstore)
+	p.push1(32)
+	p.push1(128)
+	p.push1(128)
+	p.push1(0)
+	p.push1(1)
+	p.op(opGas, opStaticcall, opPop)
+
+	// Jump to the valid branch if the recovered address is the owner.
+	p.push1(128)
+	p.op(opMload)
+	p.pushAddress(owner)
+	p.op(opEq)
+	p.push1(byte(p.Len() + 3 + 5))
+	p.op(opJumpi)
+
+	// Invalid: return a zero word.
+	p.push1(32)
+	p.push1(160)
+	p.op(opReturn)
+
+	// Valid: return the magic value.
+	p.op(opJumpdest)
+	p.push4(0x1626ba7e)
+	p.push1(224)
+	p.op(opShl)
+	p.push1(0)
+	p.op(opMstore)
+	p.push1(32)
+	p.push1(0)
+	p.op(opReturn)
+	return p.Bytes()
+}
+
+// Returns the runtime code of a factory which deploys walletInit with CREATE2,
+// using its 32-byte calldata as the salt.
+func testFactoryCode(walletInit []byte) []byte {
+	build := func(offset uint32) *evmProgram {
+		p := new(evmProgram)
+		p.codecopy(offset, uint32(len(walletInit)))
+		p.push1(0)
+		p.op(opCalldataload)
+		p.push4(uint32(len(walletInit)))
+		p.push1(0)
+		p.push1(0)
+		p.op(opCreate2, opStop)
+		return p
+	}
+	code := build(uint32(build(0).Len()))
+	code.Write(walletInit)
+	return code.Bytes()
+}
+
+func TestValidateEIP6492(t *testing.T) {
+	owner, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	other, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+
+	walletInit := testDeployCode(testWalletCode(*owner.Address))
+	factory := common.HexToAddress("0x00000000000000000000000000000000000fac70")
+	walletAt := func(salt common.Hash) common.Address {
+		return crypto.CreateAddress2(factory, salt, crypto.Keccak256(walletInit))
+	}
+	undeployedSalt := common.HexToHash("0x01")
+	deployedSalt := common.HexToHash("0x02")
+
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		factory: {Code: testFactoryCode(walletInit), Balance: new(big.Int)},
+		// A wallet that was already deployed at its counterfactual address.
+		walletAt(deployedSalt): {Code: testWalletCode(*owner.Address), Balance: new(big.Int)},
+	}, 30_000_000)
+	defer backend.Close()
+	verifier := NewExecutionClient(zap.NewNop(), backend, 5*time.Second)
+
+	dataHash := common.BytesToHash(crypto.Keccak256([]byte("Rescue Node 1700000000")))
+	sign := func(w *util.Wallet, hash common.Hash) []byte {
+		sig, err := w.SignHash(hash.Bytes())
+		if err != nil {
+			t.Fatalf("Could not sign hash: %v", err)
+		}
+		return sig
+	}
+
+	tests := []struct {
+		name     string
+		salt     common.Hash
+		address  common.Address
+		dataHash common.Hash
+		sig      []byte
+		valid    bool
+	}{
+		{
+			name:     "Undeployed wallet",
+			salt:     undeployedSalt,
+			address:  walletAt(undeployedSalt),
+			dataHash: dataHash,
+			sig:      sign(owner, dataHash),
+			valid:    true,
+		},
+		{
+			name:     "Undeployed wallet, wrong signer",
+			salt:     undeployedSalt,
+			address:  walletAt(undeployedSalt),
+			dataHash: dataHash,
+			sig:      sign(other, dataHash),
+		},
+		{
+			name:     "Undeployed wallet, wrong hash",
+			salt:     undeployedSalt,
+			address:  walletAt(undeployedSalt),
+			dataHash: common.HexToHash("0x1234"),
+			sig:      sign(owner, dataHash),
+		},
+		{
+			name:     "Factory calldata deploys another wallet",
+			salt:     common.HexToHash("0x03"),
+			address:  walletAt(undeployedSalt),
+			dataHash: dataHash,
+			sig:      sign(owner, dataHash),
+		},
+		{
+			name:     "Deployed wallet",
+			salt:     deployedSalt,
+			address:  walletAt(deployedSalt),
+			dataHash: dataHash,
+			sig:      sign(owner, dataHash),
+			valid:    true,
+		},
+		{
+			name:     "Deployed wallet, wrong signer",
+			salt:     deployedSalt,
+			address:  walletAt(deployedSalt),
+			dataHash: dataHash,
+			sig:      sign(other, dataHash),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := &util.EIP6492Signature{
+				Factory:         factory,
+				FactoryCalldata: tt.salt.Bytes(),
+				Signature:       tt.sig,
+			}
+			valid, err := verifier.ValidateEIP6492(context.Background(), &tt.dataHash, sig, &tt.address)
+			if err != nil {
+				t.Fatalf("Could not validate signature: %v", err)
+			}
+			if valid != tt.valid {
+				t.Fatalf("Expected valid=%v, got %v", tt.valid, valid)
+			}
+		})
+	}
+
+	// Validation is simulated, so the wallet is still not deployed.
+	code, err := backend.CodeAt(context.Background(), walletAt(undeployedSalt), nil)
+	if err != nil {
+		t.Fatalf("Could not get code: %v", err)
+	}
+	if len(code) != 0 {
+		t.Fatalf("Expected wallet not to be deployed")
+	}
+}
@@ -0,0 +1,65 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// The value returned by EIP-1271 isValidSignature for valid signatures, which is also its selector.
+var eip1271MagicValue = common.FromHex("0x1626ba7e")
+
+// The arguments of isValidSignature(bytes32 hash, bytes signature).
+var eip1271Arguments = func() abi.Arguments {
+	bytes32Type, _ := abi.NewType("bytes32", "", nil)
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	return abi.Arguments{{Type: bytes32Type}, {Type: bytesType}}
+}()
+
+// ExecutionClient validates smart contract wallet signatures with eth_call, using an
+// execution client's JSON-RPC API, e.g. an *ethclient.Client, instead of the rescue-proxy.
+type ExecutionClient struct {
+	caller ethereum.ContractCaller
+	logger *zap.Logger
+
+	// How long each call may take.
+	timeout time.Duration
+}
+
+func NewExecutionClient(logger *zap.Logger, caller ethereum.ContractCaller, timeout time.Duration) *ExecutionClient {
+	return &ExecutionClient{
+		caller:  caller,
+		logger:  logger,
+		timeout: timeout,
+	}
+}
+
+// ValidateEIP1271 calls isValidSignature on the wallet at address.
+// Addresses without code are EOAs or undeployed wallets, whose signatures are not valid.
+func (c *ExecutionClient) ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
+	args, err := eip1271Arguments.Pack(*dataHash, *signature)
+	if err != nil {
+		return false, err
+	}
+
+	c.logger.Debug("requesting eip1271 validation", zap.String("address", address.Hex()))
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	r, err := c.caller.CallContract(ctx, ethereum.CallMsg{
+		To:   address,
+		Data: append(append([]byte{}, eip1271MagicValue...), args...),
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	// The result is a bytes4 value, left-aligned in a 32-byte word.
+	if len(r) != 32 {
+		return false, nil
+	}
+	return bytes.Equal(r[:4], eip1271MagicValue) && bytes.Equal(r[4:], make([]byte, 28)), nil
+}
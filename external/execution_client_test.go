@@ -0,0 +1,95 @@
+package external
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+)
+
+func TestValidateEIP1271(t *testing.T) {
+	owner, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	other, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000a11e")
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		wallet:         {Code: testWalletCode(*owner.Address), Balance: new(big.Int)},
+		*owner.Address: {Balance: big.NewInt(1)},
+	}, 30_000_000)
+	defer backend.Close()
+	client := NewExecutionClient(zap.NewNop(), backend, 5*time.Second)
+
+	dataHash := common.BytesToHash(crypto.Keccak256([]byte("Rescue Node 1700000000")))
+	sign := func(w *util.Wallet) []byte {
+		sig, err := w.SignHash(dataHash.Bytes())
+		if err != nil {
+			t.Fatalf("Could not sign hash: %v", err)
+		}
+		return sig
+	}
+
+	tests := []struct {
+		name     string
+		address  common.Address
+		dataHash common.Hash
+		sig      []byte
+		valid    bool
+	}{
+		{
+			name:     "Valid signature",
+			address:  wallet,
+			dataHash: dataHash,
+			sig:      sign(owner),
+			valid:    true,
+		},
+		{
+			name:     "Wrong signer",
+			address:  wallet,
+			dataHash: dataHash,
+			sig:      sign(other),
+		},
+		{
+			name:     "Wrong hash",
+			address:  wallet,
+			dataHash: common.HexToHash("0x1234"),
+			sig:      sign(owner),
+		},
+		{
+			name:     "EOA",
+			address:  *owner.Address,
+			dataHash: dataHash,
+			sig:      sign(owner),
+		},
+		{
+			name:     "Undeployed wallet",
+			address:  common.HexToAddress("0x000000000000000000000000000000000000dead"),
+			dataHash: dataHash,
+			sig:      sign(owner),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := client.ValidateEIP1271(context.Background(), &tt.dataHash, &tt.sig, &tt.address)
+			if err != nil {
+				t.Fatalf("Could not validate signature: %v", err)
+			}
+			if valid != tt.valid {
+				t.Fatalf("Expected valid=%v, got %v", tt.valid, valid)
+			}
+		})
+	}
+}
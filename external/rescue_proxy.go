@@ -2,111 +2,218 @@ package external
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
 	proxy "github.com/Rocket-Rescue-Node/rescue-proxy/pb"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	// Registers the client side of the gRPC health-checking protocol.
+	_ "google.golang.org/grpc/health"
+)
+
+// The service config of the rescue-proxy connection. Subchannels are only used while the
+// gRPC health-checking service reports the server as serving. Health checking requires the
+// round_robin policy, which behaves like pick_first with a single address.
+const rescueProxyServiceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": ""}
+}`
+
+// Names of the rescue-proxy API methods, as used in metrics and CircuitOpenError.
+const (
+	methodGetRocketPoolNodes     = "get_rocket_pool_nodes"
+	methodGetWithdrawalAddresses = "get_withdrawal_addresses"
+	methodValidateEIP1271        = "validate_eip1271"
 )
 
+// RescueProxyAPIClient is a long-lived client of the rescue-proxy API, safe for concurrent use.
+// The connection is re-established with exponential backoff when it fails, and each method
+// fails fast with a *CircuitOpenError while the rescue-proxy keeps failing to serve it.
 type RescueProxyAPIClient struct {
 	address string
-	secure  bool
 	logger  *zap.Logger
+	m       *metrics.MetricsRegistry
+
+	// How long each call may take.
+	timeout time.Duration
 
 	conn   *grpc.ClientConn
 	client proxy.ApiClient
-}
 
-func NewRescueProxyAPIClient(logger *zap.Logger, address string, secure bool) *RescueProxyAPIClient {
-	return &RescueProxyAPIClient{
-		address: address,
-		secure:  secure,
-		logger:  logger,
-	}
-}
+	nodesBreaker               *circuitBreaker
+	withdrawalAddressesBreaker *circuitBreaker
+	eip1271Breaker             *circuitBreaker
 
-func (c *RescueProxyAPIClient) connect() error {
-	var err error
+	// Stops watching the connection state, and is closed once it stopped.
+	cancel  context.CancelFunc
+	watched chan struct{}
 
-	c.logger.Debug("connecting to rescue-proxy", zap.Bool("tls", c.secure))
+	// Created before watching starts, since the metrics registry can't create collectors concurrently.
+	connectionState        prometheus.Gauge
+	connectionReady        prometheus.Gauge
+	connectionStateChanges prometheus.Counter
+}
+
+// NewRescueProxyAPIClient creates a client of the rescue-proxy API at address, whose calls time out
+// after timeout. TLS is used unless tlsConfig is nil.
+func NewRescueProxyAPIClient(logger *zap.Logger, address string, timeout time.Duration, tlsConfig *TLSConfig) (*RescueProxyAPIClient, error) {
 	var transportCredentials credentials.TransportCredentials
-	if !c.secure {
+	if tlsConfig == nil {
 		transportCredentials = insecure.NewCredentials()
 	} else {
-		// An empty TLS config will use the system's root CAs.
-		transportCredentials = credentials.NewTLS(&tls.Config{})
+		files, err := newTLSFiles(*tlsConfig, address, logger)
+		if err != nil {
+			return nil, err
+		}
+		transportCredentials = credentials.NewTLS(files.tlsConfig())
 	}
 
-	if c.conn, err = grpc.NewClient(
-		c.address,
+	conn, err := grpc.NewClient(
+		address,
 		grpc.WithTransportCredentials(transportCredentials),
-	); err != nil {
-		return err
+		grpc.WithDefaultServiceConfig(rescueProxyServiceConfig),
+		// Detect dead connections. Servers refusing pings this frequent make the
+		// client back off, so this is safe with any server keepalive policy.
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   30 * time.Second,
+			},
+			MinConnectTimeout: 5 * time.Second,
+		}),
+		// Stay connected between the periodic updates, so that failures are noticed early.
+		grpc.WithIdleTimeout(0),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := clockwork.NewRealClock()
+	m := metrics.NewMetricsRegistry("rescue_proxy")
+	c := &RescueProxyAPIClient{
+		address: address,
+		logger:  logger,
+		m:       m,
+		timeout: timeout,
+		conn:    conn,
+		client:  proxy.NewApiClient(conn),
+
+		nodesBreaker:               newCircuitBreaker(methodGetRocketPoolNodes, clock, logger, m),
+		withdrawalAddressesBreaker: newCircuitBreaker(methodGetWithdrawalAddresses, clock, logger, m),
+		eip1271Breaker:             newCircuitBreaker(methodValidateEIP1271, clock, logger, m),
+
+		cancel:  cancel,
+		watched: make(chan struct{}),
+
+		connectionState:        m.Gauge("connection_state"),
+		connectionReady:        m.Gauge("connection_ready"),
+		connectionStateChanges: m.Counter("connection_state_changes"),
 	}
-	c.client = proxy.NewApiClient(c.conn)
-	c.logger.Debug("connected to rescue-proxy", zap.String("address", c.address))
-	return nil
+	logger.Debug("connecting to rescue-proxy", zap.String("address", address), zap.Bool("tls", tlsConfig != nil))
+	conn.Connect()
+	go c.watchState(ctx)
+	return c, nil
 }
 
-func (c *RescueProxyAPIClient) ensureConnection() error {
-	if c.conn == nil || c.client == nil {
-		c.logger.Debug("not yet connected - connecting", zap.String("address", c.address))
-		if err := c.connect(); err != nil {
-			return err
+// Logs connection state changes, and exposes the state as metrics, until ctx is done.
+func (c *RescueProxyAPIClient) watchState(ctx context.Context) {
+	defer close(c.watched)
+	state := c.conn.GetState()
+	for {
+		c.connectionState.Set(float64(state))
+		if state == connectivity.Ready {
+			c.connectionReady.Set(1)
+		} else {
+			c.connectionReady.Set(0)
 		}
-	}
 
-	return nil
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		next := c.conn.GetState()
+		c.connectionStateChanges.Inc()
+		if next == connectivity.TransientFailure {
+			c.logger.Warn("rescue-proxy connection failed", zap.String("address", c.address), zap.Stringer("previous_state", state))
+		} else {
+			c.logger.Info("rescue-proxy connection state changed", zap.String("address", c.address), zap.Stringer("state", next))
+		}
+		state = next
+	}
 }
 
-func (c *RescueProxyAPIClient) GetRocketPoolNodes() ([][]byte, error) {
-	// Connect if not yet connected.
-	if err := c.ensureConnection(); err != nil {
-		return nil, err
+// CheckConnection waits until the connection to the rescue-proxy is ready or fails.
+// It returns an error if the connection isn't ready when ctx is done.
+func (c *RescueProxyAPIClient) CheckConnection(ctx context.Context) error {
+	c.conn.Connect()
+	for {
+		state := c.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("connection to %s is %s", c.address, state)
+		}
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection to %s is %s: %w", c.address, state, ctx.Err())
+		}
 	}
+}
+
+func (c *RescueProxyAPIClient) GetRocketPoolNodes(ctx context.Context) ([][]byte, error) {
 	c.logger.Debug("requesting rp nodes")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	r, err := c.client.GetRocketPoolNodes(ctx, &proxy.RocketPoolNodesRequest{})
+	var r *proxy.RocketPoolNodes
+	err := c.nodesBreaker.call(ctx, c.timeout, func(ctx context.Context) (err error) {
+		r, err = c.client.GetRocketPoolNodes(ctx, &proxy.RocketPoolNodesRequest{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return r.GetNodeIds(), nil
 }
 
-func (c *RescueProxyAPIClient) GetWithdrawalAddresses() ([][]byte, error) {
-	// Connect if not yet connected.
-	if err := c.ensureConnection(); err != nil {
-		return nil, err
-	}
+func (c *RescueProxyAPIClient) GetWithdrawalAddresses(ctx context.Context) ([][]byte, error) {
 	c.logger.Debug("requesting solo validator withdrawal addresses")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	r, err := c.client.GetSoloValidators(ctx, &proxy.SoloValidatorsRequest{})
+	var r *proxy.SoloValidators
+	err := c.withdrawalAddressesBreaker.call(ctx, c.timeout, func(ctx context.Context) (err error) {
+		r, err = c.client.GetSoloValidators(ctx, &proxy.SoloValidatorsRequest{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return r.GetWithdrawalAddresses(), nil
 }
 
-func (c *RescueProxyAPIClient) ValidateEIP1271(dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
-	// Connect if not yet connected.
-	if err := c.ensureConnection(); err != nil {
-		return false, err
-	}
+func (c *RescueProxyAPIClient) ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
 	c.logger.Debug("requesting eip1271 validation")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	r, err := c.client.ValidateEIP1271(ctx, &proxy.ValidateEIP1271Request{
-		DataHash:  dataHash.Bytes(),
-		Signature: *signature,
-		Address:   address.Bytes(),
+	var r *proxy.ValidateEIP1271Response
+	err := c.eip1271Breaker.call(ctx, c.timeout, func(ctx context.Context) (err error) {
+		r, err = c.client.ValidateEIP1271(ctx, &proxy.ValidateEIP1271Request{
+			DataHash:  dataHash.Bytes(),
+			Signature: *signature,
+			Address:   address.Bytes(),
+		})
+		return err
 	})
 	if err != nil {
 		return false, err
@@ -119,11 +226,7 @@ func (c *RescueProxyAPIClient) ValidateEIP1271(dataHash *common.Hash, signature
 }
 
 func (c *RescueProxyAPIClient) Close() error {
-	if c.conn == nil {
-		return nil
-	}
-	err := c.conn.Close()
-	c.conn = nil
-	c.client = nil
-	return err
+	c.cancel()
+	<-c.watched
+	return c.conn.Close()
 }
@@ -0,0 +1,121 @@
+package external
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	proxy "github.com/Rocket-Rescue-Node/rescue-proxy/pb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testProxyServer struct {
+	proxy.UnimplementedApiServer
+	nodes [][]byte
+}
+
+func (s *testProxyServer) GetRocketPoolNodes(context.Context, *proxy.RocketPoolNodesRequest) (*proxy.RocketPoolNodes, error) {
+	return &proxy.RocketPoolNodes{NodeIds: s.nodes}, nil
+}
+
+// Starts a rescue-proxy API server, with the health-checking service, on a random port.
+func startTestProxyServer(t *testing.T, opts ...grpc.ServerOption) (net.Listener, *health.Server) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := grpc.NewServer(opts...)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	proxy.RegisterApiServer(server, &testProxyServer{nodes: [][]byte{{1}, {2}}})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return listener, healthServer
+}
+
+// Initializes metrics in a namespace of the test's own, since each client registers its metrics.
+func initTestMetrics(t *testing.T) {
+	namespace := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, t.Name())
+	if _, err := metrics.Init(namespace); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+func TestRescueProxyAPIClient(t *testing.T) {
+	initTestMetrics(t)
+	listener, healthServer := startTestProxyServer(t)
+
+	client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.CheckConnection(ctx); err != nil {
+		t.Fatalf("Expected connection to be ready, got %v", err)
+	}
+	nodes, err := client.GetRocketPoolNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get nodes: %v", err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(nodes))
+	}
+
+	// The connection fails while the server reports that it isn't serving, and recovers after.
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	for client.CheckConnection(ctx) == nil {
+		if ctx.Err() != nil {
+			t.Fatalf("Expected connection to fail")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	for client.CheckConnection(ctx) != nil {
+		if ctx.Err() != nil {
+			t.Fatalf("Expected connection to recover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRescueProxyAPIClientUnreachable(t *testing.T) {
+	initTestMetrics(t)
+
+	// Nothing listens on the address anymore.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	listener.Close()
+
+	client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.CheckConnection(ctx); err == nil {
+		t.Fatalf("Expected connection to fail")
+	}
+}
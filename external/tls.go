@@ -0,0 +1,182 @@
+package external
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TLSConfig configures the TLS connection to the rescue-proxy.
+type TLSConfig struct {
+	// PEM bundle of the CAs that the server certificate must be issued by.
+	// The system's root CAs are used if empty.
+	CAFile string
+	// PEM client certificate and key, presented to servers that require client authentication.
+	// No certificate is presented if empty.
+	CertFile string
+	KeyFile  string
+	// The name the server certificate is checked against, instead of the host in the address.
+	ServerName string
+}
+
+// The size and modification time of a file, to tell when it changed.
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+// tlsFiles holds the CA bundle and client certificate of a TLSConfig, and reloads them when
+// their files change on disk. Files are checked at each handshake, so that new certificates are
+// used when reconnecting, and established connections are left alone.
+type tlsFiles struct {
+	config TLSConfig
+	logger *zap.Logger
+	// The name the server certificate is checked against: config.ServerName, or else the
+	// host in the dial address, which may be an IP address.
+	serverName string
+
+	lock     sync.Mutex
+	versions map[string]fileVersion
+	roots    *x509.CertPool
+	cert     *tls.Certificate
+}
+
+func newTLSFiles(config TLSConfig, address string, logger *zap.Logger) (*tlsFiles, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("a client certificate requires both a certificate and a key file")
+	}
+	serverName := config.ServerName
+	if serverName == "" {
+		serverName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			serverName = host
+		}
+	}
+	f := &tlsFiles{
+		config:     config,
+		logger:     logger,
+		serverName: serverName,
+	}
+	if _, err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Returns the current versions of the files, and whether any changed since they were loaded.
+func (f *tlsFiles) changed() (map[string]fileVersion, bool, error) {
+	versions := make(map[string]fileVersion)
+	changed := false
+	for _, path := range []string{f.config.CAFile, f.config.CertFile, f.config.KeyFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, false, err
+		}
+		versions[path] = fileVersion{info.Size(), info.ModTime()}
+		changed = changed || versions[path] != f.versions[path]
+	}
+	return versions, changed || f.versions == nil, nil
+}
+
+// load reads the files if they changed since they were last read. Returns whether they were read.
+// The caller must hold the lock, unless the files are loaded for the first time.
+func (f *tlsFiles) load() (bool, error) {
+	versions, changed, err := f.changed()
+	if err != nil || !changed {
+		return false, err
+	}
+
+	var roots *x509.CertPool
+	if f.config.CAFile != "" {
+		pem, err := os.ReadFile(f.config.CAFile)
+		if err != nil {
+			return false, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificates found in %s", f.config.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if f.config.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(f.config.CertFile, f.config.KeyFile)
+		if err != nil {
+			return false, err
+		}
+		cert = &c
+	}
+
+	f.versions = versions
+	f.roots = roots
+	f.cert = cert
+	return true, nil
+}
+
+// Reloads the files if they changed. If they can't be read, e.g. because they are
+// being replaced, the previous ones are kept.
+func (f *tlsFiles) reload() {
+	if reloaded, err := f.load(); err != nil {
+		f.logger.Warn("Failed to reload rescue-proxy TLS files, keeping the previous ones", zap.Error(err))
+	} else if reloaded {
+		f.logger.Info("Reloaded rescue-proxy TLS files")
+	}
+}
+
+func (f *tlsFiles) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.reload()
+	return f.cert, nil
+}
+
+// Verifies the server certificate against the CA bundle. The certificate must be issued for
+// serverName, as a DNS name or an IP address. The name sent with SNI can't be used, since
+// there is none when dialing an IP address.
+func (f *tlsFiles) verifyConnection(cs tls.ConnectionState) error {
+	f.lock.Lock()
+	f.reload()
+	roots := f.roots
+	f.lock.Unlock()
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       f.serverName,
+	})
+	return err
+}
+
+// tlsConfig returns the TLS configuration for connections to the rescue-proxy.
+func (f *tlsFiles) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		ServerName: f.serverName,
+	}
+	if f.config.CAFile != "" {
+		// The default verification can't use a CA bundle that changes, so it is replaced
+		// by verifyConnection, which checks the server certificate in the same way.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = f.verifyConnection
+	}
+	if f.config.CertFile != "" {
+		config.GetClientCertificate = f.getClientCertificate
+	}
+	return config
+}
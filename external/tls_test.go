@@ -0,0 +1,291 @@
+package external
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// Issues a certificate for name, signed by issuer. The certificate is a CA if issuer is nil.
+func newTestCert(t *testing.T, name string, issuer *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{name}
+		}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	return &testCert{cert, key, der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// Writes the certificate and key as PEM files, and returns their paths.
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("Could not marshal key: %v", err)
+	}
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	writeTestFile(t, certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}))
+	writeTestFile(t, keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPath, keyPath
+}
+
+// Writes a file, making sure that its modification time changes even on coarse filesystems.
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	info, statErr := os.Stat(path)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Could not write %s: %v", path, err)
+	}
+	if statErr == nil {
+		modTime := info.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Could not change modification time of %s: %v", path, err)
+		}
+	}
+}
+
+func TestRescueProxyAPIClientTLS(t *testing.T) {
+	ca := newTestCert(t, "Test CA", nil)
+	otherCA := newTestCert(t, "Other CA", nil)
+	serverCert := newTestCert(t, "proxy.internal", ca)
+	clientCert := newTestCert(t, "rescue-api", ca)
+	untrustedClientCert := newTestCert(t, "rescue-api", otherCA)
+
+	// The server requires client certificates issued by the CA.
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	listener, _ := startTestProxyServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))
+
+	dir := t.TempDir()
+	caPath, _ := ca.write(t, dir, "ca")
+	otherCAPath, _ := otherCA.write(t, dir, "other-ca")
+	clientCertPath, clientKeyPath := clientCert.write(t, dir, "client")
+
+	tests := []struct {
+		name   string
+		config TLSConfig
+		ready  bool
+	}{
+		{
+			name: "mTLS",
+			config: TLSConfig{
+				CAFile:     caPath,
+				CertFile:   clientCertPath,
+				KeyFile:    clientKeyPath,
+				ServerName: "proxy.internal",
+			},
+			ready: true,
+		},
+		{
+			name: "No client certificate",
+			config: TLSConfig{
+				CAFile:     caPath,
+				ServerName: "proxy.internal",
+			},
+		},
+		{
+			name: "Wrong server name",
+			config: TLSConfig{
+				CAFile:     caPath,
+				CertFile:   clientCertPath,
+				KeyFile:    clientKeyPath,
+				ServerName: "other.internal",
+			},
+		},
+		{
+			name: "Wrong CA",
+			config: TLSConfig{
+				CAFile:     otherCAPath,
+				CertFile:   clientCertPath,
+				KeyFile:    clientKeyPath,
+				ServerName: "proxy.internal",
+			},
+		},
+		{
+			name: "System roots",
+			config: TLSConfig{
+				CertFile:   clientCertPath,
+				KeyFile:    clientKeyPath,
+				ServerName: "proxy.internal",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestMetrics(t)
+			client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, &tt.config)
+			if err != nil {
+				t.Fatalf("Could not create client: %v", err)
+			}
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.CheckConnection(ctx)
+			if tt.ready && err != nil {
+				t.Fatalf("Expected connection to be ready, got %v", err)
+			}
+			if !tt.ready && err == nil {
+				t.Fatalf("Expected connection to fail")
+			}
+			if tt.ready {
+				if _, err := client.GetRocketPoolNodes(context.Background()); err != nil {
+					t.Fatalf("Could not get nodes: %v", err)
+				}
+			}
+		})
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		initTestMetrics(t)
+
+		// Start with a client certificate that the server doesn't trust.
+		untrustedDir := t.TempDir()
+		certPath, keyPath := untrustedClientCert.write(t, untrustedDir, "client")
+		client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, &TLSConfig{
+			CAFile:     caPath,
+			CertFile:   certPath,
+			KeyFile:    keyPath,
+			ServerName: "proxy.internal",
+		})
+		if err != nil {
+			t.Fatalf("Could not create client: %v", err)
+		}
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.CheckConnection(ctx); err == nil {
+			t.Fatalf("Expected connection to fail")
+		}
+
+		// The new certificate is used when reconnecting.
+		clientCert.write(t, untrustedDir, "client")
+		for client.CheckConnection(ctx) != nil {
+			if ctx.Err() != nil {
+				t.Fatalf("Expected connection to succeed with the new certificate")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	t.Run("Invalid files", func(t *testing.T) {
+		for _, config := range []TLSConfig{
+			{CAFile: filepath.Join(dir, "missing.crt")},
+			{CAFile: clientKeyPath},
+			{CertFile: clientCertPath},
+			{CertFile: clientCertPath, KeyFile: filepath.Join(dir, "ca.key")},
+		} {
+			if _, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, &config); err == nil {
+				t.Fatalf("Expected invalid config %+v to be refused", config)
+			}
+		}
+	})
+}
+
+func TestRescueProxyAPIClientTLSIPAddress(t *testing.T) {
+	ca := newTestCert(t, "Test CA", nil)
+	dir := t.TempDir()
+	caPath, _ := ca.write(t, dir, "ca")
+
+	tests := []struct {
+		name       string
+		serverCert string
+		serverName string
+		ready      bool
+	}{
+		// No name is sent with SNI when dialing an IP address, but the certificate is still checked.
+		{name: "Certificate for another name", serverCert: "proxy.internal"},
+		{name: "Certificate for another IP address", serverCert: "127.0.0.2"},
+		{name: "Certificate for the IP address", serverCert: "127.0.0.1", ready: true},
+		{name: "Server name", serverCert: "proxy.internal", serverName: "proxy.internal", ready: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestMetrics(t)
+			serverCert := newTestCert(t, tt.serverCert, ca)
+			listener, _ := startTestProxyServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+				Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+			})))
+
+			client, err := NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, &TLSConfig{
+				CAFile:     caPath,
+				ServerName: tt.serverName,
+			})
+			if err != nil {
+				t.Fatalf("Could not create client: %v", err)
+			}
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.CheckConnection(ctx)
+			if tt.ready && err != nil {
+				t.Fatalf("Expected connection to be ready, got %v", err)
+			}
+			if !tt.ready && err == nil {
+				t.Fatalf("Expected connection to fail")
+			}
+		})
+	}
+}
@@ -1,35 +1,49 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"slices"
 	"sync"
 	"syscall"
 
 	"github.com/Rocket-Rescue-Node/credentials"
 	"github.com/Rocket-Rescue-Node/rescue-api/api"
 	"github.com/Rocket-Rescue-Node/rescue-api/database"
+	"github.com/Rocket-Rescue-Node/rescue-api/external"
 	"github.com/Rocket-Rescue-Node/rescue-api/models"
 	"github.com/Rocket-Rescue-Node/rescue-api/services"
 	"github.com/Rocket-Rescue-Node/rescue-api/tasks"
 	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/jonboulle/clockwork"
 
 	"go.uber.org/zap"
 )
 
-func waitForTermination() {
-	// Trap termination signals
+// Blocks until a termination signal is received.
+// SIGHUP does not terminate, and calls reload instead.
+func waitForTermination(reload func()) {
+	// Trap termination and reload signals
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	// Block until a signal is received.
-	<-c
+	// Block until a termination signal is received.
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload()
+	}
 
 	// Allow subsequent termination signals to quickly shut down by removing the trap.
 	signal.Reset()
@@ -38,6 +52,9 @@ func waitForTermination() {
 
 var logger *zap.Logger
 
+// The level of the logger, which can be changed at runtime.
+var logLevel zap.AtomicLevel
+
 // Logger initialization.
 func initLogger(debug bool) error {
 	var cfg zap.Config
@@ -49,16 +66,85 @@ func initLogger(debug bool) error {
 		cfg = zap.NewProductionConfig()
 	}
 
+	logLevel = cfg.Level
 	logger, err = cfg.Build()
 	return err
 }
 
+// Changes the log level at runtime.
+// The output format chosen by initLogger is kept.
+func setDebugLogging(debug bool) {
+	if debug {
+		logLevel.SetLevel(zap.DebugLevel)
+	} else {
+		logLevel.SetLevel(zap.InfoLevel)
+	}
+}
+
+// Reads the command-line arguments and config file again, and applies the settings that
+// can change at runtime. Other settings are only reported if they changed.
+func reloadConfig(current *config, svc *services.Service, cp *api.CORSPolicy, rl *api.RateLimiter) {
+	logger.Info("Received SIGHUP, reloading configuration...")
+	next, err := parseArguments(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping the current one", zap.Error(err))
+		return
+	}
+
+	for setting, changed := range map[string]bool{
+		"addr":                  next.ListenAddr != current.ListenAddr,
+		"metrics-addr":          next.MetricsAddr != current.MetricsAddr,
+		"admin-addr":            next.AdminAddr != current.AdminAddr,
+		"admin-tokens":          !maps.Equal(next.AdminTokens, current.AdminTokens),
+		"hmac-secret":           !bytes.Equal(next.CredentialSecret, current.CredentialSecret),
+		"db-path":               next.DBPath != current.DBPath,
+		"rescue-proxy-api-addr": next.RescueProxyAPIAddr != current.RescueProxyAPIAddr,
+		"secure-grpc":           next.SecureGRPC != current.SecureGRPC,
+		"rescue-proxy-ca, rescue-proxy-cert, rescue-proxy-key, rescue-proxy-server-name": next.RescueProxyTLS != current.RescueProxyTLS,
+		"rescue-proxy-timeout":     next.RescueProxyTimeout != current.RescueProxyTimeout,
+		"execution-client-url":     next.ExecutionClientURL != current.ExecutionClientURL,
+		"execution-client-timeout": next.ExecutionTimeout != current.ExecutionTimeout,
+		"signature-verifiers":      !slices.Equal(next.SignatureVerifiers, current.SignatureVerifiers),
+		"eip1271-cache-ttl":        next.EIP1271CacheTTL != current.EIP1271CacheTTL,
+		"eip1271-cache-size":       next.EIP1271CacheSize != current.EIP1271CacheSize,
+		"chain-id":                 next.ChainID != current.ChainID,
+		"siwe-domain, siwe-uri":    !reflect.DeepEqual(next.SIWE, current.SIWE),
+	} {
+		if changed {
+			logger.Warn("Setting changed, but it can only be applied by restarting", zap.String("setting", setting))
+		}
+	}
+
+	svc.SetQuotaPolicy(next.Quotas)
+	svc.SetEnableSoloValidators(next.EnableSoloValidators)
+	svc.SetAllowLegacyRequests(next.AllowLegacyRequests)
+	cp.SetAllowedOrigins(next.AllowedOrigins)
+	rl.SetPolicy(next.RateLimits)
+	rl.SetTrustedProxies(next.TrustedProxies)
+	setDebugLogging(next.Debug)
+
+	current.Quotas = next.Quotas
+	current.EnableSoloValidators = next.EnableSoloValidators
+	current.AllowLegacyRequests = next.AllowLegacyRequests
+	current.AllowedOrigins = next.AllowedOrigins
+	current.RateLimits = next.RateLimits
+	current.TrustedProxies = next.TrustedProxies
+	current.Debug = next.Debug
+
+	logger.Info("Configuration reloaded",
+		zap.Bool("enable_solo_validators", next.EnableSoloValidators),
+		zap.Bool("allow_legacy_requests", next.AllowLegacyRequests),
+		zap.Strings("allowed_origins", next.AllowedOrigins),
+		zap.Bool("debug", next.Debug),
+	)
+}
+
 func main() {
 	var cfg config
 	var err error
 
 	// Parse command line arguments.
-	if cfg, err = parseArguments(); err != nil {
+	if cfg, err = parseArguments(os.Args[1:], flag.ExitOnError); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing command-line arguments: %v\n", err)
 		os.Exit(1)
 	}
@@ -88,29 +174,57 @@ func main() {
 	cm := credentials.NewCredentialManager(cfg.CredentialSecret)
 	logger.Debug("Initialized credential manager", zap.String("primary id", cm.ID().String()))
 
-	// Background task to update the list of current Rocket Pool nodes.
+	// The current Rocket Pool nodes, and withdrawal addresses. They are updated by background tasks.
 	nodes := models.NewNodeRegistry()
-	updateNodes := tasks.NewUpdateNodesTask(
-		cfg.RescueProxyAPIAddr,
-		nodes,
-		cfg.SecureGRPC,
-		logger,
-	)
-	go updateNodes.Run()
-
-	// Background task to update the list of withdrawal addresses.
 	withdrawalAddresses := models.NewNodeRegistry()
-	updateWithdrawalAddresses := tasks.NewUpdateWithdrawalAddressesTask(
-		cfg.RescueProxyAPIAddr,
-		withdrawalAddresses,
-		cfg.SecureGRPC,
-		logger,
-	)
-	go updateWithdrawalAddresses.Run()
 
 	// Clock
 	clock := clockwork.NewRealClock()
 
+	// Execution client used to validate smart contract wallet signatures, if configured.
+	var executionClient *external.ExecutionClient
+	var eip6492Verifier services.EIP6492Verifier
+	if cfg.ExecutionClientURL != "" {
+		ec, err := ethclient.Dial(cfg.ExecutionClientURL)
+		if err != nil {
+			logger.Fatal("Unable to connect to the execution client", zap.Error(err))
+		}
+		defer ec.Close()
+		executionClient = external.NewExecutionClient(logger, ec, cfg.ExecutionTimeout)
+		eip6492Verifier = executionClient
+	}
+
+	// The connection to the rescue-proxy, shared by the background tasks and the service layer.
+	var rescueProxyTLS *external.TLSConfig
+	if cfg.SecureGRPC {
+		rescueProxyTLS = &cfg.RescueProxyTLS
+	}
+	rescueProxyClient, err := external.NewRescueProxyAPIClient(logger, cfg.RescueProxyAPIAddr, cfg.RescueProxyTimeout, rescueProxyTLS)
+	if err != nil {
+		logger.Fatal("Unable to create the rescue-proxy client", zap.Error(err))
+	}
+
+	// Request signatures are checked by each verifier in turn.
+	// EIP-1271 results are cached, since each validation is a call to the rescue-proxy or execution client.
+	eip1271CacheMetrics := metrics.NewMetricsRegistry("eip1271_cache")
+	cached := func(v services.SignatureVerifier) services.SignatureVerifier {
+		if cfg.EIP1271CacheTTL == 0 {
+			return v
+		}
+		return services.NewCachingVerifier(v, clock, cfg.EIP1271CacheTTL, cfg.EIP1271CacheSize, eip1271CacheMetrics)
+	}
+	var signatureVerifiers []services.SignatureVerifier
+	for _, name := range cfg.SignatureVerifiers {
+		switch name {
+		case services.EOAVerifierName:
+			signatureVerifiers = append(signatureVerifiers, services.EOAVerifier{})
+		case services.ProxyEIP1271VerifierName:
+			signatureVerifiers = append(signatureVerifiers, cached(services.NewEIP1271Verifier(name, rescueProxyClient, cfg.RescueProxyTimeout)))
+		case services.RPCEIP1271VerifierName:
+			signatureVerifiers = append(signatureVerifiers, cached(services.NewEIP1271Verifier(name, executionClient, cfg.ExecutionTimeout)))
+		}
+	}
+
 	// Services contain the business logic and are used by the API handlers.
 	// Only CreateCredential is implemented for now.
 	svcCfg := &services.ServiceConfig{
@@ -121,18 +235,56 @@ func main() {
 		Logger:               logger,
 		Clock:                clock,
 		EnableSoloValidators: cfg.EnableSoloValidators,
-
-		RescueProxyAddr:       cfg.RescueProxyAPIAddr,
-		RescueProxySecureGRPC: cfg.SecureGRPC,
+		AllowLegacyRequests:  cfg.AllowLegacyRequests,
+		ChainID:              cfg.ChainID,
+		SIWE:                 cfg.SIWE,
+		Quotas:               cfg.Quotas,
+
+		SignatureVerifiers: signatureVerifiers,
+		EIP6492Verifier:    eip6492Verifier,
+		RescueProxy:        rescueProxyClient,
 	}
 	svc := services.NewService(svcCfg)
 	if err := svc.Init(); err != nil {
 		logger.Fatal("Unable to initialize the service layer", zap.Error(err))
 	}
 
+	// Background tasks that update the registries. They start from the last snapshot,
+	// so requests can be served before the first update.
+	scheduler := tasks.NewScheduler(clock, logger)
+
+	// Background task to update the list of current Rocket Pool nodes.
+	updateNodes := tasks.NewUpdateNodesTask(
+		rescueProxyClient,
+		nodes,
+		db,
+		clock,
+		logger,
+	)
+	if err := updateNodes.LoadSnapshot(); err != nil {
+		logger.Error("Unable to load the node registry snapshot", zap.Error(err))
+	}
+	scheduler.Add(updateNodes, tasks.DefaultSchedule)
+
+	// Background task to update the list of withdrawal addresses.
+	updateWithdrawalAddresses := tasks.NewUpdateWithdrawalAddressesTask(
+		rescueProxyClient,
+		withdrawalAddresses,
+		db,
+		clock,
+		logger,
+	)
+	if err := updateWithdrawalAddresses.LoadSnapshot(); err != nil {
+		logger.Error("Unable to load the withdrawal address registry snapshot", zap.Error(err))
+	}
+	scheduler.Add(updateWithdrawalAddresses, tasks.DefaultSchedule)
+	scheduler.Start(context.Background())
+
 	// Create the API router.
 	path := "/rescue/v1/"
-	router := api.NewAPIRouter(path, svc, cfg.AllowedOrigins, logger)
+	corsPolicy := api.NewCORSPolicy(cfg.AllowedOrigins, cfg.Debug)
+	rateLimiter := api.NewRateLimiter(cfg.RateLimits, cfg.TrustedProxies, clock, logger)
+	router := api.NewAPIRouter(path, svc, corsPolicy, rateLimiter, logger)
 
 	// Listen on the provided address. This listener will be used by the HTTP server.
 	listener, err := net.Listen("tcp", cfg.ListenAddr)
@@ -148,6 +300,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Listen on the admin address, if enabled.
+	var adminListener net.Listener
+	if cfg.AdminAddr != "" {
+		adminListener, err = net.Listen("tcp", cfg.AdminAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to listen on provided admin address %s\n%v\n", cfg.AdminAddr, err)
+			os.Exit(1)
+		}
+	}
+
 	// Spin up the HTTP server on a different goroutine, since it blocks.
 	server := http.Server{
 		Handler: router,
@@ -155,6 +317,9 @@ func main() {
 	metricsServer := http.Server{
 		Handler: metricsHandler,
 	}
+	adminServer := http.Server{
+		Handler: api.NewAdminRouter("/admin/v1/", svc, cfg.AdminTokens, logger),
+	}
 	var serverWaitGroup sync.WaitGroup
 	serverWaitGroup.Add(2)
 	go func() {
@@ -171,8 +336,20 @@ func main() {
 		}
 		serverWaitGroup.Done()
 	}()
+	if adminListener != nil {
+		serverWaitGroup.Add(1)
+		go func() {
+			logger.Info("Starting admin HTTP server", zap.String("url", cfg.AdminAddr))
+			if err := adminServer.Serve(adminListener); err != nil {
+				logger.Error("admin HTTP server stopped", zap.Error(err))
+			}
+			serverWaitGroup.Done()
+		}()
+	}
 
-	waitForTermination()
+	waitForTermination(func() {
+		reloadConfig(&cfg, svc, corsPolicy, rateLimiter)
+	})
 
 	// Shut down gracefully
 	logger.Info("Received termination signal, shutting down...")
@@ -180,6 +357,10 @@ func main() {
 	listener.Close()
 	_ = metricsServer.Shutdown(context.Background())
 	metricsListener.Close()
+	if adminListener != nil {
+		_ = adminServer.Shutdown(context.Background())
+		adminListener.Close()
+	}
 
 	// Wait for the listener/server to exit
 	serverWaitGroup.Wait()
@@ -187,12 +368,12 @@ func main() {
 	// Shut down the service layer
 	svc.Deinit()
 
-	// Stop the background tasks
-	if err = updateNodes.Stop(); err != nil {
-		logger.Error("Error stopping node background tasks", zap.Error(err))
-	}
-	if err = updateWithdrawalAddresses.Stop(); err != nil {
-		logger.Error("Error stopping withdrawal addresses background tasks", zap.Error(err))
+	// Stop the background tasks, cancelling the updates in progress.
+	scheduler.Stop()
+
+	// Close the rescue-proxy connection, which is no longer used.
+	if err = rescueProxyClient.Close(); err != nil {
+		logger.Error("Error closing the rescue-proxy connection", zap.Error(err))
 	}
 
 	metrics.Deinit()
@@ -0,0 +1,31 @@
+package authorization
+
+import "fmt"
+
+type RuleOperation int
+
+const (
+	RuleCreated RuleOperation = iota
+	RuleDeleted
+)
+
+var ruleOperationNames = map[RuleOperation]string{
+	RuleCreated: "created",
+	RuleDeleted: "deleted",
+}
+
+func (o RuleOperation) MarshalText() ([]byte, error) {
+	name, ok := ruleOperationNames[o]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule operation %d", int(o))
+	}
+	return []byte(name), nil
+}
+
+// RuleAuditEvent records a change made to the authorization rules, and who made it.
+type RuleAuditEvent struct {
+	Timestamp int64         `json:"timestamp"`
+	Actor     string        `json:"actor"`
+	Operation RuleOperation `json:"operation"`
+	Rule      Rule          `json:"rule"`
+}
@@ -1,6 +1,10 @@
 package authorization
 
-import "github.com/Rocket-Rescue-Node/rescue-api/models"
+import (
+	"fmt"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+)
 
 type Action int
 
@@ -9,16 +13,80 @@ const (
 	Deny
 )
 
+var actionNames = map[Action]string{
+	Allow: "allow",
+	Deny:  "deny",
+}
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	name, ok := actionNames[a]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %d", int(a))
+	}
+	return []byte(name), nil
+}
+
+func (a *Action) UnmarshalText(text []byte) error {
+	for action, name := range actionNames {
+		if name == string(text) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %q", string(text))
+}
+
 type Resource int
 
 const (
 	CredentialService Resource = iota
 )
 
+var resourceNames = map[Resource]string{
+	CredentialService: "credential_service",
+}
+
+func (r Resource) String() string {
+	if name, ok := resourceNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Resource(%d)", int(r))
+}
+
+func (r Resource) MarshalText() ([]byte, error) {
+	name, ok := resourceNames[r]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %d", int(r))
+	}
+	return []byte(name), nil
+}
+
+func (r *Resource) UnmarshalText(text []byte) error {
+	for resource, name := range resourceNames {
+		if name == string(text) {
+			*r = resource
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown resource %q", string(text))
+}
+
 // Rule represents a rule that can be applied to Nodes while trying to access a Resource.
 // Right now, only the CredentialService resource is supported.
+// NotBefore and ExpiresAt are unix timestamps bounding when the rule applies. Zero means unbounded.
 type Rule struct {
-	NodeID   models.NodeID
-	Resource Resource
-	Action   Action
+	NodeID    models.NodeID `json:"nodeId"`
+	Resource  Resource      `json:"resource"`
+	Action    Action        `json:"action"`
+	NotBefore int64         `json:"notBefore,omitempty"`
+	ExpiresAt int64         `json:"expiresAt,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	Creator   string        `json:"creator,omitempty"`
 }
@@ -10,6 +10,8 @@ type CredentialEventType int
 const (
 	CredentialIssued CredentialEventType = iota
 	CredentialRevoked
+	// Emergency credits granted by an admin, consumed by credentials issued beyond the quota.
+	CreditsGranted
 )
 
 type CredentialEvent struct {
@@ -17,4 +19,6 @@ type CredentialEvent struct {
 	Timestamp    int64
 	Type         CredentialEventType
 	OperatorType credentials.OperatorType
+	// The change to the node's emergency credit balance.
+	Credits int64
 }
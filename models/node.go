@@ -14,7 +14,7 @@ type NodeID = common.Address
 type NodeRegistry struct {
 	registry    map[NodeID]interface{}
 	lock        sync.RWMutex
-	LastUpdated time.Time
+	lastUpdated time.Time
 }
 
 func NewNodeRegistry() *NodeRegistry {
@@ -42,9 +42,54 @@ func (c *NodeRegistry) Has(id NodeID) bool {
 	return ok
 }
 
-func (c *NodeRegistry) Reset() {
+// Replace atomically replaces the nodes in the registry with ids, which were up to date at lastUpdated.
+// It returns the nodes that were added, and the nodes that were removed.
+func (c *NodeRegistry) Replace(ids []NodeID, lastUpdated time.Time) (added []NodeID, removed []NodeID) {
+	registry := make(map[NodeID]interface{}, len(ids))
+	for _, id := range ids {
+		registry[id] = struct{}{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id := range registry {
+		if _, ok := c.registry[id]; !ok {
+			added = append(added, id)
+		}
+	}
+	for id := range c.registry {
+		if _, ok := registry[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	c.registry = registry
+	c.lastUpdated = lastUpdated
+	return added, removed
+}
+
+// LastUpdated returns when the registry was last up to date, or zero if it never was.
+func (c *NodeRegistry) LastUpdated() time.Time {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.lastUpdated
+}
+
+// SetLastUpdated records that the registry was up to date at lastUpdated, without changing its nodes.
+func (c *NodeRegistry) SetLastUpdated(lastUpdated time.Time) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	c.registry = make(map[NodeID]interface{})
+	c.lastUpdated = lastUpdated
+}
+
+// Len returns the number of nodes in the registry.
+func (c *NodeRegistry) Len() int {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return len(c.registry)
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func sortedIDs(ids []NodeID) []NodeID {
+	slices.SortFunc(ids, func(a, b NodeID) int { return a.Cmp(b) })
+	return ids
+}
+
+func TestNodeRegistryReplace(t *testing.T) {
+	a := common.HexToAddress("0x01")
+	b := common.HexToAddress("0x02")
+	c := common.HexToAddress("0x03")
+
+	r := NewNodeRegistry()
+	if !r.LastUpdated().IsZero() {
+		t.Fatalf("Expected a new registry never to have been updated")
+	}
+	now := time.Unix(1700000000, 0)
+	added, removed := r.Replace([]NodeID{a, b, b}, now)
+	if !slices.Equal(sortedIDs(added), []NodeID{a, b}) || len(removed) != 0 {
+		t.Fatalf("Unexpected diff: added %v, removed %v", added, removed)
+	}
+	if r.Len() != 2 || !r.LastUpdated().Equal(now) {
+		t.Fatalf("Expected 2 nodes updated at %v, got %d at %v", now, r.Len(), r.LastUpdated())
+	}
+
+	now = now.Add(time.Minute)
+	added, removed = r.Replace([]NodeID{b, c}, now)
+	if !slices.Equal(added, []NodeID{c}) || !slices.Equal(removed, []NodeID{a}) {
+		t.Fatalf("Unexpected diff: added %v, removed %v", added, removed)
+	}
+	if r.Has(a) || !r.Has(b) || !r.Has(c) || !r.LastUpdated().Equal(now) {
+		t.Fatalf("Unexpected registry contents")
+	}
+
+	// Replacing with the same nodes changes nothing.
+	added, removed = r.Replace([]NodeID{c, b}, now)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("Unexpected diff: added %v, removed %v", added, removed)
+	}
+
+	added, removed = r.Replace(nil, now)
+	if len(added) != 0 || !slices.Equal(sortedIDs(removed), []NodeID{b, c}) || r.Len() != 0 {
+		t.Fatalf("Unexpected diff: added %v, removed %v", added, removed)
+	}
+}
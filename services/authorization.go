@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+const (
+	// The maximum number of audit events of each kind returned by the Get*AuditEvents methods.
+	maxAuditEvents = 1000
+)
+
+func validateRule(rule *authz.Rule) error {
+	if _, err := rule.Resource.MarshalText(); err != nil {
+		return &ValidationError{err.Error()}
+	}
+	if _, err := rule.Action.MarshalText(); err != nil {
+		return &ValidationError{err.Error()}
+	}
+	if rule.NodeID == (common.Address{}) {
+		return &ValidationError{"missing node ID"}
+	}
+	if rule.NotBefore < 0 || rule.ExpiresAt < 0 {
+		return &ValidationError{"timestamps must not be negative"}
+	}
+	if rule.NotBefore != 0 && rule.ExpiresAt != 0 && rule.ExpiresAt <= rule.NotBefore {
+		return &ValidationError{"rule expires before it starts"}
+	}
+	return nil
+}
+
+// Converts a rule timestamp to a database value. Zero means unbounded, and is stored as NULL.
+func nullableTimestamp(ts int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: ts, Valid: ts != 0}
+}
+
+// Returns all authorization rules.
+func (s *Service) GetAuthorizationRules(ctx context.Context) ([]authz.Rule, error) {
+	rows, err := s.getAuthzRulesStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []authz.Rule{}
+	for rows.Next() {
+		var nodeID []byte
+		var notBefore, expiresAt sql.NullInt64
+		var rule authz.Rule
+		if err := rows.Scan(&nodeID, &rule.Resource, &rule.Action, &notBefore, &expiresAt, &rule.Reason, &rule.Creator); err != nil {
+			return nil, err
+		}
+		rule.NodeID = common.BytesToAddress(nodeID)
+		rule.NotBefore = notBefore.Int64
+		rule.ExpiresAt = expiresAt.Int64
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// Creates an authorization rule, replacing any existing rule for the same node, resource and action.
+// A node may hold both an Allow and a Deny rule, e.g. a temporary ban on top of a permanent allowlist entry.
+// The rule's creator is set to actor, and the change is recorded in the audit trail.
+func (s *Service) SetAuthorizationRule(ctx context.Context, rule authz.Rule, actor string) (*authz.Rule, error) {
+	if err := validateRule(&rule); err != nil {
+		return nil, err
+	}
+	if rule.ExpiresAt != 0 && rule.ExpiresAt <= s.clock.Now().Unix() {
+		return nil, &ValidationError{"rule has already expired"}
+	}
+	rule.Creator = actor
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	sars := tx.StmtContext(ctx, s.setAuthzRuleStmt)
+	defer sars.Close()
+	if _, err := sars.ExecContext(ctx,
+		rule.NodeID.Bytes(),
+		rule.Resource,
+		rule.Action,
+		nullableTimestamp(rule.NotBefore),
+		nullableTimestamp(rule.ExpiresAt),
+		rule.Reason,
+		rule.Creator,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := s.addAuthzAuditEvent(ctx, tx, actor, authz.RuleCreated, &rule); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Set authorization rule",
+		zap.String("nodeID", rule.NodeID.Hex()),
+		zap.Stringer("resource", rule.Resource),
+		zap.Stringer("action", rule.Action),
+		zap.Int64("notBefore", rule.NotBefore),
+		zap.Int64("expiresAt", rule.ExpiresAt),
+		zap.String("reason", rule.Reason),
+		zap.String("actor", actor),
+	)
+	s.m.Counter("authorization_rule_set").Inc()
+	return &rule, nil
+}
+
+// Deletes the authorization rule for a node, resource and action.
+// The change is recorded in the audit trail on behalf of actor.
+func (s *Service) DeleteAuthorizationRule(ctx context.Context, nodeID common.Address, resource authz.Resource, action authz.Action, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	rule := authz.Rule{NodeID: nodeID, Resource: resource, Action: action}
+	dars := tx.StmtContext(ctx, s.deleteAuthzRuleStmt)
+	defer dars.Close()
+	var notBefore, expiresAt sql.NullInt64
+	err = dars.QueryRowContext(ctx, nodeID.Bytes(), resource, action).Scan(&notBefore, &expiresAt, &rule.Reason, &rule.Creator)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{"authorization rule not found"}
+	}
+	if err != nil {
+		return err
+	}
+	rule.NotBefore = notBefore.Int64
+	rule.ExpiresAt = expiresAt.Int64
+
+	if err := s.addAuthzAuditEvent(ctx, tx, actor, authz.RuleDeleted, &rule); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Deleted authorization rule",
+		zap.String("nodeID", nodeID.Hex()),
+		zap.Stringer("resource", resource),
+		zap.Stringer("action", action),
+		zap.String("actor", actor),
+	)
+	s.m.Counter("authorization_rule_deleted").Inc()
+	return nil
+}
+
+// Returns the most recent changes made to the authorization rules, newest first.
+func (s *Service) GetAuthorizationAuditEvents(ctx context.Context) ([]authz.RuleAuditEvent, error) {
+	rows, err := s.getAuthzAuditEventsStmt.QueryContext(ctx, maxAuditEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []authz.RuleAuditEvent{}
+	for rows.Next() {
+		var nodeID []byte
+		var notBefore, expiresAt sql.NullInt64
+		var e authz.RuleAuditEvent
+		if err := rows.Scan(
+			&e.Timestamp,
+			&e.Actor,
+			&e.Operation,
+			&nodeID,
+			&e.Rule.Resource,
+			&e.Rule.Action,
+			&notBefore,
+			&expiresAt,
+			&e.Rule.Reason,
+		); err != nil {
+			return nil, err
+		}
+		e.Rule.NodeID = common.BytesToAddress(nodeID)
+		e.Rule.NotBefore = notBefore.Int64
+		e.Rule.ExpiresAt = expiresAt.Int64
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Service) addAuthzAuditEvent(ctx context.Context, tx *sql.Tx, actor string, op authz.RuleOperation, rule *authz.Rule) error {
+	aaes := tx.StmtContext(ctx, s.addAuthzAuditEventStmt)
+	defer aaes.Close()
+	_, err := aaes.ExecContext(ctx,
+		s.clock.Now().Unix(),
+		actor,
+		op,
+		rule.NodeID.Bytes(),
+		rule.Resource,
+		rule.Action,
+		nullableTimestamp(rule.NotBefore),
+		nullableTimestamp(rule.ExpiresAt),
+		rule.Reason,
+	)
+	return err
+}
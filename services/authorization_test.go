@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+// Returns the rules that apply to a node.
+// The database is shared between tests, so other tests' rules may be present.
+func getNodeRules(t *testing.T, svc *Service, nodeID common.Address) []authz.Rule {
+	t.Helper()
+	rules, err := svc.GetAuthorizationRules(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get rules: %v", err)
+	}
+	out := []authz.Rule{}
+	for _, r := range rules {
+		if r.NodeID == nodeID {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func TestAuthorizationRules(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	// Ban the node.
+	rule := authz.Rule{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Deny}
+	created, err := svc.SetAuthorizationRule(context.Background(), rule, "alice")
+	if err != nil {
+		t.Fatalf("Could not set rule: %v", err)
+	}
+	if created.Creator != "alice" {
+		t.Fatalf("Unexpected rule creator %s", created.Creator)
+	}
+	rules := getNodeRules(t, svc, *node.Address)
+	if len(rules) != 1 || rules[0] != *created {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+	if _, err := createValidCredential(svc, node); !errors.Is(err, &AuthorizationError{}) {
+		t.Fatalf("Expected AuthorizationError, got %v", err)
+	}
+
+	// Replace the ban.
+	clock.Advance(time.Second)
+	rule.Reason = "cool-off"
+	created, err = svc.SetAuthorizationRule(context.Background(), rule, "bob")
+	if err != nil {
+		t.Fatalf("Could not set rule: %v", err)
+	}
+	rules = getNodeRules(t, svc, *node.Address)
+	if len(rules) != 1 || rules[0] != *created {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+
+	// Delete the rule.
+	clock.Advance(time.Second)
+	if err := svc.DeleteAuthorizationRule(context.Background(), *node.Address, authz.CredentialService, authz.Deny, "alice"); err != nil {
+		t.Fatalf("Could not delete rule: %v", err)
+	}
+	if _, err := createValidCredential(svc, node); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	err = svc.DeleteAuthorizationRule(context.Background(), *node.Address, authz.CredentialService, authz.Deny, "alice")
+	if !errors.Is(err, &NotFoundError{}) {
+		t.Fatalf("Expected NotFoundError, got %v", err)
+	}
+
+	// Invalid rules are rejected.
+	invalid := []authz.Rule{
+		{NodeID: *node.Address, Resource: authz.Resource(42), Action: authz.Deny},
+		{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Action(42)},
+		{NodeID: common.Address{}, Resource: authz.CredentialService, Action: authz.Deny},
+		{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Deny, NotBefore: 20, ExpiresAt: 10},
+		{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Deny, ExpiresAt: clock.Now().Unix()},
+	}
+	for _, r := range invalid {
+		if _, err := svc.SetAuthorizationRule(context.Background(), r, "alice"); !errors.Is(err, &ValidationError{}) {
+			t.Fatalf("Expected ValidationError for %v, got %v", r, err)
+		}
+	}
+
+	// Every change was audited, newest first. Only look at this node's events.
+	allEvents, err := svc.GetAuthorizationAuditEvents(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get audit events: %v", err)
+	}
+	events := []authz.RuleAuditEvent{}
+	for _, e := range allEvents {
+		if e.Rule.NodeID == *node.Address {
+			events = append(events, e)
+		}
+	}
+	expected := []struct {
+		actor  string
+		op     authz.RuleOperation
+		action authz.Action
+	}{
+		{"alice", authz.RuleDeleted, authz.Deny},
+		{"bob", authz.RuleCreated, authz.Deny},
+		{"alice", authz.RuleCreated, authz.Deny},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Incorrect audit event count. Expected %d, got %d", len(expected), len(events))
+	}
+	for i, e := range expected {
+		if events[i].Actor != e.actor || events[i].Operation != e.op || events[i].Rule.Action != e.action {
+			t.Fatalf("Unexpected audit event %d: %+v", i, events[i])
+		}
+	}
+}
+
+func TestCheckNodeAuthorizationPrecedence(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	allow := []authz.Action{authz.Allow}
+	deny := []authz.Action{authz.Deny}
+	both := []authz.Action{authz.Allow, authz.Deny}
+
+	data := []struct {
+		name       string
+		registered bool
+		actions    []authz.Action
+		ot         credentials.OperatorType
+		shedSolo   bool
+		err        error
+	}{
+		{"registered_no_rule", true, nil, pb.OperatorType_OT_ROCKETPOOL, false, nil},
+		{"unregistered_no_rule", false, nil, pb.OperatorType_OT_ROCKETPOOL, false, &AuthorizationError{}},
+		{"registered_allow", true, allow, pb.OperatorType_OT_ROCKETPOOL, false, nil},
+		{"unregistered_allow", false, allow, pb.OperatorType_OT_ROCKETPOOL, false, nil},
+		{"registered_deny", true, deny, pb.OperatorType_OT_ROCKETPOOL, false, &AuthorizationError{}},
+		{"unregistered_deny", false, deny, pb.OperatorType_OT_ROCKETPOOL, false, &AuthorizationError{}},
+		{"registered_allow_deny", true, both, pb.OperatorType_OT_ROCKETPOOL, false, &AuthorizationError{}},
+		{"unregistered_allow_deny", false, both, pb.OperatorType_OT_ROCKETPOOL, false, &AuthorizationError{}},
+		{"solo_registered_no_rule", true, nil, pb.OperatorType_OT_SOLO, false, nil},
+		{"solo_unregistered_no_rule", false, nil, pb.OperatorType_OT_SOLO, false, &AuthorizationError{}},
+		{"solo_unregistered_allow", false, allow, pb.OperatorType_OT_SOLO, false, nil},
+		{"solo_registered_deny", true, deny, pb.OperatorType_OT_SOLO, false, &AuthorizationError{}},
+		{"solo_unregistered_allow_deny", false, both, pb.OperatorType_OT_SOLO, false, &AuthorizationError{}},
+		{"solo_shedding_allow", true, allow, pb.OperatorType_OT_SOLO, true, &AuthorizationError{}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			var wallet *util.Wallet
+			if d.ot == pb.OperatorType_OT_SOLO {
+				wallet, err = createTestWithdrawalAddress(svc, d.registered)
+			} else {
+				wallet, err = createTestNode(svc, d.registered)
+			}
+			if err != nil {
+				t.Fatalf("Could not create wallet: %v", err)
+			}
+			for _, action := range d.actions {
+				rule := authz.Rule{NodeID: *wallet.Address, Resource: authz.CredentialService, Action: action}
+				if _, err := svc.SetAuthorizationRule(context.Background(), rule, "test"); err != nil {
+					t.Fatalf("Could not set rule: %v", err)
+				}
+			}
+			svc.SetEnableSoloValidators(!d.shedSolo)
+			defer svc.SetEnableSoloValidators(true)
+
+			err := svc.checkNodeAuthorization(context.Background(), wallet.Address, d.ot)
+			if !errors.Is(err, d.err) {
+				t.Fatalf("Expected error %v, got %v", d.err, err)
+			}
+		})
+	}
+
+	// Allow rules also let nodes through while the registry is stale.
+	node, err := createTestNode(svc, false)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	rule := authz.Rule{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Allow}
+	if _, err := svc.SetAuthorizationRule(context.Background(), rule, "test"); err != nil {
+		t.Fatalf("Could not set rule: %v", err)
+	}
+	clock.Advance(2 * nodeRegistryMaxAge)
+	if err := svc.checkNodeAuthorization(context.Background(), node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Expected allowed node to be authorized, got %v", err)
+	}
+}
+
+func TestTimeBoundedAuthorizationRules(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	// The node isn't registered, but is permanently allowed, e.g. by a partner allowlist.
+	node, err := createTestNode(svc, false)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	allow := authz.Rule{NodeID: *node.Address, Resource: authz.CredentialService, Action: authz.Allow}
+	if _, err := svc.SetAuthorizationRule(context.Background(), allow, "alice"); err != nil {
+		t.Fatalf("Could not set rule: %v", err)
+	}
+
+	// A cool-off ban starting in an hour, and lasting for a day.
+	start := clock.Now().Add(time.Hour)
+	rule := authz.Rule{
+		NodeID:    *node.Address,
+		Resource:  authz.CredentialService,
+		Action:    authz.Deny,
+		NotBefore: start.Unix(),
+		ExpiresAt: start.Add(24 * time.Hour).Unix(),
+		Reason:    "cool-off",
+	}
+	if _, err := svc.SetAuthorizationRule(context.Background(), rule, "alice"); err != nil {
+		t.Fatalf("Could not set rule: %v", err)
+	}
+
+	check := func(expected error) {
+		t.Helper()
+		// Keep the node registry fresh while moving the clock around.
+		svc.nodes.SetLastUpdated(svc.clock.Now())
+		err := svc.checkNodeAuthorization(context.Background(), node.Address, pb.OperatorType_OT_ROCKETPOOL)
+		if !errors.Is(err, expected) {
+			t.Fatalf("Expected error %v, got %v", expected, err)
+		}
+	}
+
+	// Not yet in effect.
+	check(nil)
+
+	// In effect.
+	clock.Advance(time.Hour)
+	check(&AuthorizationError{})
+	clock.Advance(24*time.Hour - time.Second)
+	check(&AuthorizationError{})
+
+	// Expired, so the allow rule applies again.
+	clock.Advance(time.Second)
+	check(nil)
+
+	// The annotations are kept, and recorded in the audit trail.
+	rules := getNodeRules(t, svc, *node.Address)
+	if len(rules) != 2 || rules[0].Action != authz.Allow {
+		t.Fatalf("Unexpected rules %+v", rules)
+	}
+	if r := rules[1]; r.Reason != "cool-off" || r.Creator != "alice" || r.NotBefore != rule.NotBefore || r.ExpiresAt != rule.ExpiresAt {
+		t.Fatalf("Unexpected rule %+v", r)
+	}
+	events, err := svc.GetAuthorizationAuditEvents(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get audit events: %v", err)
+	}
+	if events[0].Rule.Reason != "cool-off" || events[0].Rule.ExpiresAt != rule.ExpiresAt {
+		t.Fatalf("Unexpected audit event %+v", events[0])
+	}
+}
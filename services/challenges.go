@@ -0,0 +1,119 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// How long a challenge nonce can be used for after it was issued.
+	challengeTTL = 5 * time.Minute
+	// The number of bytes in a challenge nonce: its expiry time, a serial number, and a truncated MAC of both.
+	challengeNonceBytes = 16
+	challengeMACBytes   = challengeNonceBytes - 8
+	// The maximum number of used, unexpired challenges kept in memory.
+	maxUsedChallenges = 100000
+)
+
+// Returns the MAC of the expiry time and serial number of a challenge nonce.
+func (s *Service) challengeMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.challengeKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:challengeMACBytes]
+}
+
+// CreateChallenge issues a single-use nonce that clients embed in their signed request message,
+// e.g. "Rescue Node 1700000000 nonce 0123456789abcdef0123456789abcdef".
+// Returns the nonce and the time after which it is no longer accepted.
+// Nonces are authenticated with a key generated by Init rather than stored, so issuing them
+// takes no memory, and a restart invalidates them.
+func (s *Service) CreateChallenge() (string, time.Time, error) {
+	expires := time.Unix(s.clock.Now().Add(challengeTTL).Unix(), 0)
+
+	// The serial number keeps nonces that expire in the same second distinct. It would take
+	// billions of challenges in a second for it to wrap around to a nonce that is still valid.
+	b := make([]byte, challengeNonceBytes)
+	binary.BigEndian.PutUint32(b, uint32(expires.Unix()))
+	binary.BigEndian.PutUint32(b[4:], s.challengeSerial.Add(1))
+	copy(b[8:], s.challengeMAC(b[:8]))
+
+	s.m.Counter("challenge_created").Inc()
+	return hex.EncodeToString(b), expires, nil
+}
+
+// consumeChallenge checks that a nonce was issued by CreateChallenge, and that it is neither expired
+// nor already used. Used nonces are remembered until they expire.
+func (s *Service) consumeChallenge(nonce string) error {
+	invalid := &AuthenticationError{"challenge nonce is invalid, expired, or was already used"}
+
+	b, err := hex.DecodeString(nonce)
+	if err != nil || len(b) != challengeNonceBytes {
+		return invalid
+	}
+	if !hmac.Equal(b[8:], s.challengeMAC(b[:8])) {
+		return invalid
+	}
+	now := s.clock.Now()
+	expires := time.Unix(int64(binary.BigEndian.Uint32(b)), 0)
+	if !expires.After(now) {
+		return invalid
+	}
+
+	s.challengesLock.Lock()
+	defer s.challengesLock.Unlock()
+	if _, ok := s.usedChallenges[nonce]; ok {
+		return invalid
+	}
+	if len(s.usedChallenges) >= maxUsedChallenges {
+		// Make room by forgetting expired challenges, which are rejected anyway. If there are none,
+		// clients can retry once the oldest one expires.
+		next := expires
+		for n, e := range s.usedChallenges {
+			if !e.After(now) {
+				delete(s.usedChallenges, n)
+			} else if e.Before(next) {
+				next = e
+			}
+		}
+		if len(s.usedChallenges) >= maxUsedChallenges {
+			s.m.Counter("challenges_exhausted").Inc()
+			return &UnavailableError{"too many recent challenges, please retry later", next.Sub(now)}
+		}
+	}
+	s.usedChallenges[nonce] = expires
+	return nil
+}
+
+// checkRequestNonce makes sure that a request message can only be used once.
+// Messages without a nonce are only accepted while legacy requests are allowed.
+func (s *Service) checkRequestNonce(rm *requestMessage) error {
+	nonce := rm.nonce
+	if nonce == "" {
+		if !s.allowLegacyRequests.Load() {
+			s.m.Counter("legacy_request_rejected").Inc()
+			return &ValidationError{"request message must include a challenge nonce"}
+		}
+		s.m.Counter("legacy_request").Inc()
+		return nil
+	}
+
+	if err := s.consumeChallenge(nonce); err != nil {
+		if _, ok := err.(*AuthenticationError); ok {
+			s.logger.Warn("Rejected invalid or reused challenge nonce", zap.String("nonce", nonce))
+			s.m.Counter("invalid_challenge_nonce").Inc()
+		}
+		return err
+	}
+	return nil
+}
+
+// SetAllowLegacyRequests controls whether request messages without a challenge nonce are accepted.
+// It is safe to call while requests are being processed.
+func (s *Service) SetAllowLegacyRequests(allow bool) {
+	s.allowLegacyRequests.Store(allow)
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/jonboulle/clockwork"
+)
+
+// Requests a credential with a message embedding the given nonce.
+func createCredentialWithNonce(svc *Service, node *util.Wallet, nonce string) error {
+	msg := []byte(fmt.Sprintf("Rescue Node %d nonce %s", svc.clock.Now().Unix(), nonce))
+	sig, err := node.Sign(msg)
+	if err != nil {
+		return fmt.Errorf("Could not sign message: %v", err)
+	}
+	_, err = svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	return err
+}
+
+func TestChallenges(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	nonce, expires, err := svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	if len(nonce) != 2*challengeNonceBytes {
+		t.Fatalf("Unexpected nonce %s", nonce)
+	}
+	if expires.Unix() != clock.Now().Add(challengeTTL).Unix() {
+		t.Fatalf("Unexpected challenge expiry %v", expires)
+	}
+
+	// A nonce can only be used once.
+	if err := createCredentialWithNonce(svc, node, nonce); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if err := createCredentialWithNonce(svc, node, nonce); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected reused nonce to be rejected, got %v", err)
+	}
+
+	// Nonces that were never issued are rejected.
+	if err := createCredentialWithNonce(svc, node, "0123456789abcdef0123456789abcdef"); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected unknown nonce to be rejected, got %v", err)
+	}
+
+	// Nonces are authenticated, so their expiry can't be extended.
+	nonce, _, err = svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	b, _ := hex.DecodeString(nonce)
+	b[3]++
+	if err := createCredentialWithNonce(svc, node, hex.EncodeToString(b)); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected tampered nonce to be rejected, got %v", err)
+	}
+
+	// Expired nonces are rejected.
+	nonce, _, err = svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	clock.Advance(challengeTTL)
+	if err := createCredentialWithNonce(svc, node, nonce); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected expired nonce to be rejected, got %v", err)
+	}
+
+	// A request with an invalid signature does not consume the nonce.
+	nonce, _, err = svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	other, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	msg := []byte(fmt.Sprintf("Rescue Node %d nonce %s", clock.Now().Unix(), nonce))
+	sig, err := other.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected invalid signature to be rejected, got %v", err)
+	}
+	if err := createCredentialWithNonce(svc, node, nonce); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// Legacy messages are only accepted while allowed.
+	if _, err := createValidCredential(svc, node); err != nil {
+		t.Fatalf("Could not create credential with legacy message: %v", err)
+	}
+	svc.SetAllowLegacyRequests(false)
+	if _, err := createValidCredential(svc, node); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected legacy message to be rejected, got %v", err)
+	}
+}
+
+func TestChallengesExhausted(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	// Issuing challenges takes no memory.
+	nonce, _, err := svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	if n := len(svc.usedChallenges); n != 0 {
+		t.Fatalf("Expected no used challenges, got %d", n)
+	}
+
+	// Fill the used challenges, half of which expire a minute before the others.
+	for i := 0; i < maxUsedChallenges; i++ {
+		if i == maxUsedChallenges/2 {
+			clock.Advance(time.Minute)
+		}
+		n, _, err := svc.CreateChallenge()
+		if err != nil {
+			t.Fatalf("Could not create challenge: %v", err)
+		}
+		if err := svc.consumeChallenge(n); err != nil {
+			t.Fatalf("Could not use challenge %d: %v", i, err)
+		}
+	}
+
+	// Clients are asked to retry once the oldest challenges expire.
+	clock.Advance(challengeTTL - 2*time.Minute - time.Second)
+	err = svc.consumeChallenge(nonce)
+	var ue *UnavailableError
+	if !errors.As(err, &ue) {
+		t.Fatalf("Expected challenges to be exhausted, got %v", err)
+	}
+	if ue.RetryAfter() > time.Minute+time.Second || ue.RetryAfter() <= 0 {
+		t.Fatalf("Expected to retry within a minute, got %s", ue.RetryAfter())
+	}
+
+	// Expired challenges are forgotten to make room.
+	clock.Advance(time.Minute + time.Second)
+	nonce, _, err = svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	if err := svc.consumeChallenge(nonce); err != nil {
+		t.Fatalf("Could not use challenge: %v", err)
+	}
+	if n := len(svc.usedChallenges); n != maxUsedChallenges/2+1 {
+		t.Fatalf("Expected %d used challenges, got %d", maxUsedChallenges/2+1, n)
+	}
+}
@@ -57,6 +57,8 @@ func setupTestService(t *testing.T, clock clockwork.Clock) (*Service, error) {
 		Logger:               logger,
 		Clock:                clock,
 		EnableSoloValidators: true,
+		AllowLegacyRequests:  true,
+		ChainID:              1,
 	}
 
 	_, err = metrics.Init(t.Name())
@@ -77,7 +79,7 @@ func createTestWithdrawalAddress(svc *Service, register bool) (*util.Wallet, err
 	}
 	if register {
 		svc.withdrawalAddresses.Add([]models.NodeID{*wallet.Address})
-		svc.withdrawalAddresses.LastUpdated = svc.clock.Now()
+		svc.withdrawalAddresses.SetLastUpdated(svc.clock.Now())
 	}
 	return wallet, nil
 }
@@ -90,7 +92,7 @@ func createTestNode(svc *Service, register bool) (*util.Wallet, error) {
 	}
 	if register {
 		svc.nodes.Add([]models.NodeID{*wallet.Address})
-		svc.nodes.LastUpdated = svc.clock.Now()
+		svc.nodes.SetLastUpdated(svc.clock.Now())
 	}
 	return wallet, nil
 }
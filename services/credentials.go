@@ -1,16 +1,18 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Rocket-Rescue-Node/credentials"
-	"github.com/Rocket-Rescue-Node/credentials/pb"
 	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 
 	"github.com/mattn/go-sqlite3"
@@ -23,95 +25,35 @@ const (
 	credsMinValidityWindow = time.Duration(48) * time.Hour
 
 	// The pattern for credential request messages.
-	credentialRequestPattern = `(?i)^Rescue Node ([0-9]{10})$`
+	// The challenge nonce is optional while legacy requests are allowed.
+	credentialRequestPattern = `(?i)^Rescue Node ([0-9]{10})(?: nonce ([0-9a-f]{32}))?$`
 	// The maximum age for a credential request to be considered valid.
 	credsRequestMaxAge = time.Duration(15) * time.Minute
 )
 
-type quota struct {
-	// Max number of credentials that can be requested in a given time window.
-	count uint
-	// Time window in which the credential quota is calculated.
-	window time.Duration
-	// Duration a credential is valid for
-	authValidityWindow time.Duration
-}
-
 var (
 	// The delay between retries when creating a credential.
 	// Values are taken from SQLite's default busy handler.
 	dbTryDelayMs = []int{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
-
-	quotas = map[credentials.OperatorType]quota{
-		pb.OperatorType_OT_ROCKETPOOL: {
-			count:              4,
-			window:             time.Duration(365*24) * time.Hour,
-			authValidityWindow: time.Duration(15*24) * time.Hour,
-		},
-		pb.OperatorType_OT_SOLO: {
-			count:              3,
-			window:             time.Duration(365*24) * time.Hour,
-			authValidityWindow: time.Duration(10*24) * time.Hour,
-		},
-	}
 )
 
-func credsQuotaWindow(ot credentials.OperatorType) time.Duration {
-	quota, ok := quotas[ot]
-	if !ok {
-		// Default to a year
-		return time.Duration(365*24) * time.Hour
-	}
-
-	return quota.window
-}
-
-func credsQuota(ot credentials.OperatorType) int64 {
-	quota, ok := quotas[ot]
-	if !ok {
-		// Default to one
-		return 1
-	}
-
-	return int64(quota.count)
-}
-
-func AuthValidityWindow(ot credentials.OperatorType) time.Duration {
-	quota, ok := quotas[ot]
-	if !ok {
-		// Default to 10 days
-		return time.Duration(10*24) * time.Hour
-	}
-
-	return quota.authValidityWindow
-}
-
-func GetQuotaJSON(ot credentials.OperatorType) (json.RawMessage, error) {
-	quotaData := map[string]interface{}{
-		"count":              uint(credsQuota(ot)),
-		"window":             int64(credsQuotaWindow(ot).Seconds()),
-		"authValidityWindow": int64(AuthValidityWindow(ot).Seconds()),
-	}
-
-	quotaJson, err := json.Marshal(quotaData)
-	if err != nil {
-		return nil, err
-	}
-
-	return quotaJson, nil
-}
-
 // Creates a new credential for a node. If a valid credential already exists, it will be returned instead.
 // This method will retry if creating a credential fails.
-func (s *Service) CreateCredentialWithRetry(msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*models.AuthenticatedCredential, error) {
+func (s *Service) CreateCredentialWithRetry(ctx context.Context, msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*models.AuthenticatedCredential, error) {
 	var cred *models.AuthenticatedCredential
 	var err error
 
+	// Validate the request once, as its nonce can only be used once.
+	nodeID, err := s.validateSignedRequest(ctx, msg, sig, expectedNodeId, ot, opCreateCredential)
+	if err != nil {
+		return nil, err
+	}
+
 	var try int
 	s.m.Counter("create_credential_with_retry").Inc()
 	for try = range dbTryDelayMs {
 		// Try to create the credential.
-		if cred, err = s.CreateCredential(msg, sig, expectedNodeId, ot); err == nil {
+		if cred, err = s.issueCredential(ctx, nodeID, ot); err == nil {
 			break
 		}
 
@@ -135,7 +77,15 @@ func (s *Service) CreateCredentialWithRetry(msg []byte, sig []byte, expectedNode
 			zap.Int("retryMs", sleepFor),
 			zap.Error(err),
 		)
-		s.clock.Sleep(time.Duration(time.Duration(sleepFor) * time.Millisecond))
+		select {
+		case <-s.clock.After(time.Duration(sleepFor) * time.Millisecond):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// The client is gone, or the request timed out.
+			err = ctx.Err()
+			break
+		}
 	}
 
 	if err != nil {
@@ -149,17 +99,21 @@ func (s *Service) CreateCredentialWithRetry(msg []byte, sig []byte, expectedNode
 
 // Creates a new credential for a node. If a valid credential exists, it will be returned instead.
 // No retry logic is implemented, so it is up to the caller to retry if it does not succeed.
-func (s *Service) CreateCredential(msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*models.AuthenticatedCredential, error) {
-	var err error
-
+func (s *Service) CreateCredential(ctx context.Context, msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*models.AuthenticatedCredential, error) {
 	// Validate request
-	nodeID, err := s.validateSignedRequest(msg, sig, expectedNodeId, ot)
+	nodeID, err := s.validateSignedRequest(ctx, msg, sig, expectedNodeId, ot, opCreateCredential)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.issueCredential(ctx, nodeID, ot)
+}
+
+// Issues a credential to a node whose request has already been validated.
+// If a valid credential exists, it will be returned instead.
+func (s *Service) issueCredential(ctx context.Context, nodeID common.Address, ot credentials.OperatorType) (*models.AuthenticatedCredential, error) {
 	// Start a transaction to ensure that parallel requests do not create duplicate credentials.
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -170,43 +124,76 @@ func (s *Service) CreateCredential(msg []byte, sig []byte, expectedNodeId common
 	// - If a valid credential still exists, reissue it instead of creating a new one.
 	// - The node does not request more credentials than allowed.
 	now := s.clock.Now()
+	gqos := tx.StmtContext(ctx, s.getQuotaOverrideStmt)
+	defer gqos.Close()
+	q, err := s.getNodeQuota(ctx, gqos, nodeID, ot)
+	if err != nil {
+		return nil, err
+	}
+	credsQuota := int64(q.count)
 	// The timestamp of the first event in the current window.
-	currentWindowStart := now.Add(-credsQuotaWindow(ot)).Unix()
-	gcs := tx.Stmt(s.getCredEventsStmt)
+	currentWindowStart := now.Add(-q.window).Unix()
+	gcs := tx.StmtContext(ctx, s.getCredEventsStmt)
 	defer gcs.Close()
-	row := gcs.QueryRow(nodeID.Bytes(), currentWindowStart, models.CredentialIssued, ot)
+	row := gcs.QueryRowContext(ctx, nodeID.Bytes(), currentWindowStart, models.CredentialIssued, ot)
 	var credsCount, lastCredTimestamp int64
 	if err = row.Scan(&lastCredTimestamp, &credsCount); err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	// Reissue the last credential if it's still valid, and
+	// Check whether the last credential has been revoked by its owner.
+	gcrvs := tx.StmtContext(ctx, s.getCredRevokedStmt)
+	defer gcrvs.Close()
+	revoked, err := isCredentialRevoked(ctx, gcrvs, nodeID, lastCredTimestamp, ot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reissue the last credential if it's still valid, not revoked, and
 	//  * It expires in more than credsMinValidityWindow seconds, or
 	//  * No more credentials can be issued in the current window.
 	created := time.Unix(lastCredTimestamp, 0)
-	expires := created.Add(AuthValidityWindow(ot))
-	if expires.After(now) && (expires.Sub(now) > credsMinValidityWindow || credsCount == credsQuota(ot)) {
+	expires := created.Add(q.authValidityWindow)
+	if !revoked && expires.After(now) && (expires.Sub(now) > credsMinValidityWindow || credsCount >= credsQuota) {
 		s.m.Counter("create_credential_recycled").Inc()
 		return s.cm.Create(created, nodeID.Bytes(), ot)
 	}
 
 	// Has the node reached its quota for the current window?
-	if credsCount >= credsQuota(ot) {
-		s.logger.Warn("Node has reached its quota for the current window",
+	// If so, the credential can only be issued by consuming an emergency credit.
+	var credits int64
+	if credsCount >= credsQuota {
+		gcrs := tx.StmtContext(ctx, s.getCreditsStmt)
+		defer gcrs.Close()
+		remaining, err := getEmergencyCredits(ctx, gcrs, nodeID, ot)
+		if err != nil {
+			return nil, err
+		}
+		if remaining <= 0 {
+			s.logger.Warn("Node has reached its quota for the current window",
+				zap.String("nodeID", nodeID.Hex()),
+				zap.Int64("credsCount", credsCount),
+				zap.Int64("credsQuota", credsQuota),
+				zap.Int64("currentWindowStart", currentWindowStart),
+				zap.String("operatorType", ot.String()),
+			)
+			s.m.Counter("create_credential_quota_exceeded").Inc()
+			return nil, &AuthorizationError{"node has requested too many credentials"}
+		}
+
+		s.logger.Info("Node has reached its quota for the current window, consuming an emergency credit",
 			zap.String("nodeID", nodeID.Hex()),
-			zap.Int64("credsCount", credsCount),
-			zap.Int64("credsQuota", credsQuota(ot)),
-			zap.Int64("currentWindowStart", currentWindowStart),
+			zap.Int64("remainingCredits", remaining-1),
 			zap.String("operatorType", ot.String()),
 		)
-		s.m.Counter("create_credential_quota_exceeded").Inc()
-		return nil, &AuthorizationError{"node has requested too many credentials"}
+		s.m.Counter("create_credential_emergency_credit").Inc()
+		credits = -1
 	}
 
-	// Store a "credential issued" event in the database.
-	acs := tx.Stmt(s.addCredEventStmt)
+	// Store a "credential issued" event in the database, along with any credit it consumed.
+	acs := tx.StmtContext(ctx, s.addCredEventStmt)
 	defer acs.Close()
-	_, err = acs.Exec(nodeID.Bytes(), now.Unix(), models.CredentialIssued, ot)
+	_, err = acs.ExecContext(ctx, nodeID.Bytes(), now.Unix(), models.CredentialIssued, ot, credits)
 	if err != nil {
 		return nil, err
 	}
@@ -233,10 +220,142 @@ func (s *Service) CreateCredential(msg []byte, sig []byte, expectedNodeId common
 	return cred, nil
 }
 
-func (s *Service) getTimestampFromRequest(msg string) (int64, error) {
-	matches := s.credRequestRegexp.FindStringSubmatch(msg)
-	if len(matches) != 2 {
-		return -1, &ValidationError{"invalid request format"}
+// Revokes the current credential of a node, so that it is no longer reissued by CreateCredential.
+// Returns the timestamp of the revoked credential.
+// Revoking a credential does not restore the node's quota.
+func (s *Service) RevokeCredential(ctx context.Context, msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (int64, error) {
+	var err error
+
+	// Validate request
+	nodeID, err := s.validateSignedRequest(ctx, msg, sig, expectedNodeId, ot, opRevokeCredential)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rollback(tx)
+
+	// Fetch the last credential issued for this node.
+	now := s.clock.Now()
+	gqos := tx.StmtContext(ctx, s.getQuotaOverrideStmt)
+	defer gqos.Close()
+	q, err := s.getNodeQuota(ctx, gqos, nodeID, ot)
+	if err != nil {
+		return 0, err
+	}
+	currentWindowStart := now.Add(-q.window).Unix()
+	gcs := tx.StmtContext(ctx, s.getCredEventsStmt)
+	defer gcs.Close()
+	row := gcs.QueryRowContext(ctx, nodeID.Bytes(), currentWindowStart, models.CredentialIssued, ot)
+	var credsCount, lastCredTimestamp int64
+	if err = row.Scan(&lastCredTimestamp, &credsCount); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Only credentials that are still valid can be revoked.
+	gcrvs := tx.StmtContext(ctx, s.getCredRevokedStmt)
+	defer gcrvs.Close()
+	revoked, err := isCredentialRevoked(ctx, gcrvs, nodeID, lastCredTimestamp, ot)
+	if err != nil {
+		return 0, err
+	}
+	expires := time.Unix(lastCredTimestamp, 0).Add(q.authValidityWindow)
+	if credsCount == 0 || revoked || !expires.After(now) {
+		s.m.Counter("revoke_credential_not_found").Inc()
+		return 0, &ValidationError{"node has no active credential"}
+	}
+
+	// Store a "credential revoked" event in the database, with the timestamp of the revoked credential,
+	// so that a credential issued in the same second as the revocation isn't mistaken for it.
+	acs := tx.StmtContext(ctx, s.addCredEventStmt)
+	defer acs.Close()
+	if _, err = acs.ExecContext(ctx, nodeID.Bytes(), lastCredTimestamp, models.CredentialRevoked, ot, 0); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	s.logger.Info(
+		"Revoked credential",
+		zap.String("nodeID", nodeID.Hex()),
+		zap.String("operatorType", ot.String()),
+		zap.Int64("timestamp", lastCredTimestamp),
+	)
+
+	s.m.Counter("revoke_credential_revoked").Inc()
+	return lastCredTimestamp, nil
+}
+
+// isCredentialRevoked checks whether a "credential revoked" event was stored for the credential
+// with the given timestamp. gcrvs must be the getCredRevokedStmt, bound to the caller's transaction.
+func isCredentialRevoked(ctx context.Context, gcrvs *sql.Stmt, nodeID common.Address, credTimestamp int64, ot credentials.OperatorType) (bool, error) {
+	var revokedCount int64
+	row := gcrvs.QueryRowContext(ctx, nodeID.Bytes(), credTimestamp, models.CredentialRevoked, ot)
+	if err := row.Scan(&revokedCount); err != nil {
+		return false, err
 	}
-	return strconv.ParseInt(matches[1], 10, 64)
+	return revokedCount > 0, nil
+}
+
+// A parsed request message.
+type requestMessage struct {
+	// When the message was signed.
+	issuedAt time.Time
+	// The challenge nonce. Empty for legacy messages.
+	nonce string
+	// The digest that was signed.
+	hash []byte
+	// Set for Sign-In with Ethereum messages.
+	siwe *siweMessage
+	// Set for EIP-712 requests.
+	typed *typedRequest
+}
+
+// parseRequestMessage parses a request message, which is either in the "Rescue Node <timestamp>" format,
+// an EIP-712 request, or a Sign-In with Ethereum message if enabled.
+func (s *Service) parseRequestMessage(msg string) (*requestMessage, error) {
+	if matches := s.credRequestRegexp.FindStringSubmatch(msg); len(matches) == 3 {
+		ts, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, &ValidationError{"invalid timestamp"}
+		}
+		return &requestMessage{
+			issuedAt: time.Unix(ts, 0),
+			nonce:    strings.ToLower(matches[2]),
+			hash:     accounts.TextHash([]byte(msg)),
+		}, nil
+	}
+
+	if strings.HasPrefix(msg, "{") {
+		req, hash, err := parseTypedRequest([]byte(msg), s.chainID)
+		if err != nil {
+			return nil, &ValidationError{fmt.Sprintf("invalid EIP-712 request: %v", err)}
+		}
+		return &requestMessage{
+			issuedAt: time.Unix(req.timestamp, 0),
+			nonce:    req.nonce,
+			hash:     hash,
+			typed:    req,
+		}, nil
+	}
+
+	if s.siwe != nil && strings.Contains(msg, siwePreambleSuffix) {
+		m, err := parseSIWEMessage(msg)
+		if err != nil {
+			return nil, &ValidationError{fmt.Sprintf("invalid Sign-In with Ethereum message: %v", err)}
+		}
+		return &requestMessage{
+			issuedAt: m.issuedAt,
+			nonce:    m.nonce,
+			hash:     accounts.TextHash([]byte(msg)),
+			siwe:     m,
+		}, nil
+	}
+
+	return nil, &ValidationError{"invalid request format"}
 }
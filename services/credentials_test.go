@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,7 +27,7 @@ func createValidCredential(svc *Service, node *util.Wallet) (*credentials.Authen
 		return nil, fmt.Errorf("Could not sign message: %v", err)
 	}
 	// Create credential.
-	cred, err := svc.CreateCredentialWithRetry(msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	cred, err := svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +67,10 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create node: %v", err)
 	}
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get quota: %v", err)
+	}
 
 	// First node credential.
 	c0, err := createValidCredential(svc, node)
@@ -73,9 +78,9 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 		t.Fatalf("Could not create credential: %v", err)
 	}
 	// Advance the clock just before credsMinValidityWindow expires.
-	clock.Advance(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - credsMinValidityWindow - 1*time.Second)
+	clock.Advance(svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - credsMinValidityWindow - 1*time.Second)
 	// Make sure that the node registry is considered up-to-date.
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	// Check that the credential c0 is reused, since it is still valid and
 	// within the minValidityWindow.
 	c0Reissued, err := createValidCredential(svc, node)
@@ -91,7 +96,7 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 	// Advance the clock past minValidityWindow. This should cause a new credential
 	// to be created, even though the current one is still valid for a few days.
 	clock.Advance(2 * time.Second)
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	c1, err := createValidCredential(svc, node)
 	if err != nil {
 		t.Fatalf("Could not create credential: %v", err)
@@ -105,9 +110,9 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 	// by authValidityWindow each time, and making sure that new credentials are
 	// created each time.
 	prevCred := c1
-	for i := 2; i < int(quotas[pb.OperatorType_OT_ROCKETPOOL].count); i++ {
-		clock.Advance(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL))
-		svc.nodes.LastUpdated = svc.clock.Now()
+	for i := 2; i < int(q.count); i++ {
+		clock.Advance(svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL))
+		svc.nodes.SetLastUpdated(svc.clock.Now())
 		cred, err := createValidCredential(svc, node)
 		if err != nil {
 			t.Fatalf("Could not create credential: %v", err)
@@ -121,9 +126,9 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 
 	// Advance the clock just before the credential expires.
 	// This should cause the credential to be reused, even though it is
-	// older than minValidityWindow, because we have exhausted credsQuota.
-	clock.Advance(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - 1*time.Second)
-	svc.nodes.LastUpdated = svc.clock.Now()
+	// older than minValidityWindow, because we have exhausted the quota.
+	clock.Advance(svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - 1*time.Second)
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	cred, err := createValidCredential(svc, node)
 	if err != nil {
 		t.Fatalf("Could not create credential: %v", err)
@@ -136,19 +141,19 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 	// At this point, the credential quota is exhausted.
 	// Make sure we cannot create more credentials.
 	clock.Advance(2 * time.Second)
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	_, err = createValidCredential(svc, node)
 	if !errors.Is(err, &AuthorizationError{}) {
 		t.Fatalf("Expected AuthorizationError, got %v", err)
 	}
 
 	// Advance the clock just enough so that the oldest credential is not within
-	// credsQuotaWindow anymore. This should increase the available quota to 1,
+	// the quota window anymore. This should increase the available quota to 1,
 	// and allow us to create a new credential.
-	c0QuotaExpiry := time.Unix(c0.Credential.Timestamp, 0).Add(quotas[pb.OperatorType_OT_ROCKETPOOL].window)
+	c0QuotaExpiry := time.Unix(c0.Credential.Timestamp, 0).Add(q.window)
 	clock.Advance(c0QuotaExpiry.Sub(clock.Now()))
 
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	cred, err = createValidCredential(svc, node)
 	if err != nil {
 		t.Fatalf("Could not create credential: %v", err)
@@ -159,6 +164,149 @@ func TestCreateCredentialLifecycle(t *testing.T) {
 	}
 }
 
+func revokeCredentialWithNonce(t *testing.T, svc *Service, node *util.Wallet) (int64, error) {
+	nonce, _, err := svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	msg := []byte(fmt.Sprintf("Rescue Node %d nonce %s", svc.clock.Now().Unix(), nonce))
+	sig, err := node.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	return svc.RevokeCredential(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+}
+
+func TestRevokeCredential(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	revoke := func() (int64, error) {
+		return revokeCredentialWithNonce(t, svc, node)
+	}
+
+	// Nothing to revoke yet.
+	if _, err = revoke(); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+
+	c0, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// Legacy messages could be replayed, so they can't revoke credentials.
+	msg := []byte(fmt.Sprintf("Rescue Node %d", svc.clock.Now().Unix()))
+	sig, err := node.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	if _, err := svc.RevokeCredential(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected legacy revocation to be rejected, got %v", err)
+	}
+
+	// Revoke the credential.
+	clock.Advance(1 * time.Second)
+	ts, err := revoke()
+	if err != nil {
+		t.Fatalf("Could not revoke credential: %v", err)
+	}
+	if ts != c0.Credential.Timestamp {
+		t.Fatalf("Revoked wrong credential (%d != %d)", ts, c0.Credential.Timestamp)
+	}
+
+	// A revoked credential cannot be revoked again.
+	clock.Advance(1 * time.Second)
+	if _, err = revoke(); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+
+	// The revoked credential must not be recycled.
+	c1, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if c0.Credential.Timestamp == c1.Credential.Timestamp {
+		t.Fatalf("Revoked credential was reissued (%d == %d)",
+			c0.Credential.Timestamp, c1.Credential.Timestamp)
+	}
+
+	// The new credential is recycled as usual.
+	clock.Advance(1 * time.Second)
+	c1Reissued, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if c1.Credential.Timestamp != c1Reissued.Credential.Timestamp {
+		t.Fatalf("Credentials do not match (%d != %d)",
+			c1.Credential.Timestamp, c1Reissued.Credential.Timestamp)
+	}
+
+	// Revoking does not restore the quota.
+	info, err := getOperatorInfo(svc, node)
+	if err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if len(info.CredentialEvents) != 2 {
+		t.Fatalf("Incorrect credential event count. Expected 2, got %d", len(info.CredentialEvents))
+	}
+}
+
+func TestRevokeCredentialSameSecond(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	c0, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// Revoke the credential, and get a new one within the same second.
+	clock.Advance(1 * time.Second)
+	if _, err := revokeCredentialWithNonce(t, svc, node); err != nil {
+		t.Fatalf("Could not revoke credential: %v", err)
+	}
+	c1, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if c1.Credential.Timestamp == c0.Credential.Timestamp {
+		t.Fatalf("Revoked credential was reissued")
+	}
+
+	// The new credential isn't mistaken for the revoked one, and is reissued.
+	c1Reissued, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not reissue credential: %v", err)
+	}
+	if c1Reissued.Credential.Timestamp != c1.Credential.Timestamp {
+		t.Fatalf("Credentials do not match (%d != %d)", c1.Credential.Timestamp, c1Reissued.Credential.Timestamp)
+	}
+}
+
 func TestCreateCredentialRequests(t *testing.T) {
 	// Create a fake clock and set the validity window.
 	clock := clockwork.NewFakeClockAt(time.Now())
@@ -251,7 +399,7 @@ func TestCreateCredentialRequests(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			_, err := svc.CreateCredentialWithRetry(d.msg, d.sig, d.adr, d.ot)
+			_, err := svc.CreateCredentialWithRetry(context.Background(), d.msg, d.sig, d.adr, d.ot)
 			if !errors.Is(err, d.err) {
 				t.Fatalf("Expected error %v, got %v", d.err, err)
 			}
@@ -302,7 +450,7 @@ func TestCreateCredentialConcurrent(t *testing.T) {
 				errChan <- err
 				return
 			}
-			_, err = svc.CreateCredentialWithRetry(msg, sig, *nodes[i].Address, pb.OperatorType_OT_ROCKETPOOL)
+			_, err = svc.CreateCredentialWithRetry(context.Background(), msg, sig, *nodes[i].Address, pb.OperatorType_OT_ROCKETPOOL)
 			if err != nil {
 				t.Errorf("Could not create credential %d: %v", i, err)
 				errChan <- err
@@ -324,16 +472,76 @@ func TestCreateCredentialConcurrent(t *testing.T) {
 	}
 }
 
+func TestCreateCredentialCancelled(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	msg := []byte(fmt.Sprintf("Rescue Node %d", svc.clock.Now().Unix()))
+	sig, err := node.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+
+	// No credential is issued once the client is gone.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := svc.CreateCredentialWithRetry(ctx, msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := createValidCredential(svc, node); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+}
+
 func TestGetQuotaJson(t *testing.T) {
+	svc, err := setupTestService(t, clockwork.NewRealClock())
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	// The quota settings include the node's overrides.
+	count := uint(6)
+	if _, err := svc.SetQuotaOverride(context.Background(), QuotaOverride{
+		NodeID:       *node.Address,
+		OperatorType: pb.OperatorType_OT_ROCKETPOOL,
+		Count:        &count,
+	}, "alice"); err != nil {
+		t.Fatalf("Could not set quota override: %v", err)
+	}
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get quota: %v", err)
+	}
+
 	// Test getting quota settings json message
-	quotaJson, err := GetQuotaJSON(pb.OperatorType_OT_ROCKETPOOL)
+	info, err := getOperatorInfo(svc, node)
 	if err != nil {
-		t.Fatalf("Could not get quota settings json: %v", err)
+		t.Fatalf("Could not get operator info: %v", err)
 	}
 
 	// Unmarshall raw message to check values
 	var quota map[string]interface{}
-	if err := json.Unmarshal(quotaJson, &quota); err != nil {
+	if err := json.Unmarshal(info.QuotaSettings, &quota); err != nil {
 		t.Fatalf("Error unmarshalling quota json: %v", err)
 	}
 
@@ -342,9 +550,8 @@ func TestGetQuotaJson(t *testing.T) {
 	if !ok {
 		t.Fatalf("Error parsing count from quota json")
 	}
-	count := int64(fCount)
-	if count != credsQuota(pb.OperatorType_OT_ROCKETPOOL) {
-		t.Fatalf("Incorrect quota count. Expected %d, got %d", credsQuota(pb.OperatorType_OT_ROCKETPOOL), count)
+	if uint(fCount) != count {
+		t.Fatalf("Incorrect quota count. Expected %d, got %d", count, uint(fCount))
 	}
 
 	// Check window
@@ -353,8 +560,8 @@ func TestGetQuotaJson(t *testing.T) {
 		t.Fatalf("Error parsing window from quota json")
 	}
 	window := int64(fWindow)
-	if window != int64(credsQuotaWindow(pb.OperatorType_OT_ROCKETPOOL).Seconds()) {
-		t.Fatalf("Incorrect quota window. Expected %d, got %d", int64(credsQuotaWindow(pb.OperatorType_OT_ROCKETPOOL)), window)
+	if window != int64(q.window.Seconds()) {
+		t.Fatalf("Incorrect quota window. Expected %d, got %d", int64(q.window.Seconds()), window)
 	}
 
 	// Check authValidityWindow
@@ -363,7 +570,7 @@ func TestGetQuotaJson(t *testing.T) {
 	if !ok {
 		t.Fatalf("Error parsing authValidityWindow from quota json")
 	}
-	if authValidityWindow != int64(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL).Seconds()) {
-		t.Fatalf("Incorrect quota authValidityWindow. Expected %d,  got %d", AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL), authValidityWindow)
+	if authValidityWindow != int64(q.authValidityWindow.Seconds()) {
+		t.Fatalf("Incorrect quota authValidityWindow. Expected %d,  got %d", int64(q.authValidityWindow.Seconds()), authValidityWindow)
 	}
 }
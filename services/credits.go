@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// getEmergencyCredits returns the number of emergency credits a node has left.
+// gcrs must be the getCreditsStmt, optionally bound to a transaction.
+func getEmergencyCredits(ctx context.Context, gcrs *sql.Stmt, nodeID common.Address, ot credentials.OperatorType) (int64, error) {
+	var remaining int64
+	if err := gcrs.QueryRowContext(ctx, nodeID.Bytes(), ot).Scan(&remaining); err != nil {
+		return 0, err
+	}
+	return remaining, nil
+}
+
+// GrantEmergencyCredits grants a node extra credential issuances, which are consumed by
+// CreateCredential once the node has exhausted its quota. Credits do not expire.
+// The grant is recorded in the audit trail on behalf of actor.
+// Returns the number of credits the node has left after the grant.
+func (s *Service) GrantEmergencyCredits(ctx context.Context, nodeID common.Address, ot credentials.OperatorType, credits uint, actor string) (int64, error) {
+	if nodeID == (common.Address{}) {
+		return 0, &ValidationError{"missing node ID"}
+	}
+	if _, ok := operatorTypeName(ot); !ok {
+		return 0, &ValidationError{fmt.Sprintf("unknown operator type %d", ot)}
+	}
+	if credits == 0 {
+		return 0, &ValidationError{"credits must be at least 1"}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rollback(tx)
+
+	now := s.clock.Now().Unix()
+	gcs := tx.StmtContext(ctx, s.grantCreditsStmt)
+	defer gcs.Close()
+	if _, err := gcs.ExecContext(ctx, nodeID.Bytes(), now, models.CreditsGranted, ot, credits); err != nil {
+		return 0, err
+	}
+
+	acas := tx.StmtContext(ctx, s.addCreditsAuditEventStmt)
+	defer acas.Close()
+	if _, err := acas.ExecContext(ctx, now, actor, nodeID.Bytes(), ot, credits); err != nil {
+		return 0, err
+	}
+
+	gcrs := tx.StmtContext(ctx, s.getCreditsStmt)
+	defer gcrs.Close()
+	remaining, err := getEmergencyCredits(ctx, gcrs, nodeID, ot)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	s.logger.Info("Granted emergency credits",
+		zap.String("nodeID", nodeID.Hex()),
+		zap.String("operatorType", ot.String()),
+		zap.Uint("credits", credits),
+		zap.Int64("remainingCredits", remaining),
+		zap.String("actor", actor),
+	)
+	s.m.Counter("emergency_credits_granted").Inc()
+	return remaining, nil
+}
+
+// EmergencyCreditsAuditEvent records a grant of emergency credits, and who made it.
+type EmergencyCreditsAuditEvent struct {
+	Timestamp    int64          `json:"timestamp"`
+	Actor        string         `json:"actor"`
+	NodeID       common.Address `json:"nodeId"`
+	OperatorType string         `json:"operatorType"`
+	Credits      uint           `json:"credits"`
+}
+
+// Returns the most recent grants of emergency credits, newest first.
+func (s *Service) GetEmergencyCreditsAuditEvents(ctx context.Context) ([]EmergencyCreditsAuditEvent, error) {
+	rows, err := s.getCreditsAuditEventsStmt.QueryContext(ctx, maxAuditEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EmergencyCreditsAuditEvent{}
+	for rows.Next() {
+		var nodeID []byte
+		var ot credentials.OperatorType
+		var e EmergencyCreditsAuditEvent
+		if err := rows.Scan(&e.Timestamp, &e.Actor, &nodeID, &ot, &e.Credits); err != nil {
+			return nil, err
+		}
+		e.NodeID = common.BytesToAddress(nodeID)
+		e.OperatorType, _ = operatorTypeName(ot)
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
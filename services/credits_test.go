@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+func TestEmergencyCredits(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	// Invalid grants are rejected.
+	if _, err := svc.GrantEmergencyCredits(context.Background(), common.Address{}, pb.OperatorType_OT_ROCKETPOOL, 1, "alice"); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected missing node ID to be rejected, got %v", err)
+	}
+	if _, err := svc.GrantEmergencyCredits(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, 0, "alice"); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected zero credits to be rejected, got %v", err)
+	}
+
+	// Grants made in the same second add up.
+	if _, err := svc.GrantEmergencyCredits(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, 1, "alice"); err != nil {
+		t.Fatalf("Could not grant credits: %v", err)
+	}
+	remaining, err := svc.GrantEmergencyCredits(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, 1, "bob")
+	if err != nil {
+		t.Fatalf("Could not grant credits: %v", err)
+	}
+	if remaining != 2 {
+		t.Fatalf("Incorrect remaining credits. Expected 2, got %d", remaining)
+	}
+
+	// Each grant is audited separately, newest first.
+	events, err := svc.GetEmergencyCreditsAuditEvents(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get audit events: %v", err)
+	}
+	actors := []string{}
+	for _, e := range events {
+		if e.NodeID != *node.Address {
+			continue
+		}
+		if e.OperatorType != "rocketpool" || e.Credits != 1 || e.Timestamp != clock.Now().Unix() {
+			t.Fatalf("Unexpected audit event %+v", e)
+		}
+		actors = append(actors, e.Actor)
+	}
+	if len(actors) != 2 || actors[0] != "bob" || actors[1] != "alice" {
+		t.Fatalf("Unexpected audit actors %v", actors)
+	}
+
+	// Credits are not consumed while the node has quota left.
+	validity := svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL)
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get quota: %v", err)
+	}
+	for i := 0; i < int(q.count); i++ {
+		if _, err := createValidCredential(svc, node); err != nil {
+			t.Fatalf("Could not create credential %d: %v", i, err)
+		}
+		clock.Advance(validity)
+		svc.nodes.SetLastUpdated(clock.Now())
+	}
+	info, err := getOperatorInfo(svc, node)
+	if err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if info.EmergencyCredits != 2 {
+		t.Fatalf("Incorrect remaining credits. Expected 2, got %d", info.EmergencyCredits)
+	}
+
+	// Once the quota is exhausted, each new credential consumes a credit.
+	for i := 2; i > 0; i-- {
+		cred, err := createValidCredential(svc, node)
+		if err != nil {
+			t.Fatalf("Could not create credential with emergency credit: %v", err)
+		}
+		if cred.Credential.Timestamp != clock.Now().Unix() {
+			t.Fatalf("Expected a new credential to be issued")
+		}
+
+		// Reissuing a valid credential does not consume a credit.
+		if _, err := createValidCredential(svc, node); err != nil {
+			t.Fatalf("Could not reissue credential: %v", err)
+		}
+		info, err := getOperatorInfo(svc, node)
+		if err != nil {
+			t.Fatalf("Could not get operator info: %v", err)
+		}
+		if info.EmergencyCredits != int64(i-1) {
+			t.Fatalf("Incorrect remaining credits. Expected %d, got %d", i-1, info.EmergencyCredits)
+		}
+
+		clock.Advance(validity)
+		svc.nodes.SetLastUpdated(clock.Now())
+	}
+
+	// Without credits, the quota is enforced again.
+	if _, err := createValidCredential(svc, node); !errors.Is(err, &AuthorizationError{}) {
+		t.Fatalf("Expected quota to be exceeded, got %v", err)
+	}
+
+	// Operator info lists every credential in the window, including those issued with credits.
+	info, err = getOperatorInfo(svc, node)
+	if err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if len(info.CredentialEvents) != int(q.count)+2 {
+		t.Fatalf("Incorrect credential event count. Expected %d, got %d", q.count+2, len(info.CredentialEvents))
+	}
+
+	// Credits are tracked per operator type.
+	soloCredits, err := getEmergencyCredits(context.Background(), svc.getCreditsStmt, *node.Address, pb.OperatorType_OT_SOLO)
+	if err != nil {
+		t.Fatalf("Could not get credits: %v", err)
+	}
+	if soloCredits != 0 {
+		t.Fatalf("Incorrect solo credits. Expected 0, got %d", soloCredits)
+	}
+}
+
+func TestEmergencyCreditRecycled(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get quota: %v", err)
+	}
+
+	// Exhaust the quota, and issue one more credential with a credit.
+	for i := 0; i < int(q.count); i++ {
+		if _, err := createValidCredential(svc, node); err != nil {
+			t.Fatalf("Could not create credential %d: %v", i, err)
+		}
+		clock.Advance(q.authValidityWindow)
+		svc.nodes.SetLastUpdated(clock.Now())
+	}
+	if _, err := svc.GrantEmergencyCredits(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, 2, "alice"); err != nil {
+		t.Fatalf("Could not grant credits: %v", err)
+	}
+	cred, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not create credential with emergency credit: %v", err)
+	}
+
+	// Close to its expiry, the credential is still reissued rather than consuming another credit.
+	clock.Advance(q.authValidityWindow - credsMinValidityWindow)
+	svc.nodes.SetLastUpdated(clock.Now())
+	reissued, err := createValidCredential(svc, node)
+	if err != nil {
+		t.Fatalf("Could not reissue credential: %v", err)
+	}
+	if reissued.Credential.Timestamp != cred.Credential.Timestamp {
+		t.Fatalf("Expected the existing credential to be reissued")
+	}
+	info, err := getOperatorInfo(svc, node)
+	if err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if info.EmergencyCredits != 1 {
+		t.Fatalf("Incorrect remaining credits. Expected 1, got %d", info.EmergencyCredits)
+	}
+}
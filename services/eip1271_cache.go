@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/sync/singleflight"
+)
+
+// Identifies a validation. The signature is hashed to keep keys small,
+// since smart contract wallet signatures can be arbitrarily long.
+type eip1271CacheKey struct {
+	address  common.Address
+	dataHash common.Hash
+	sigHash  common.Hash
+}
+
+func (k eip1271CacheKey) String() string {
+	return k.address.Hex() + ":" + k.dataHash.Hex() + ":" + k.sigHash.Hex()
+}
+
+type eip1271CacheEntry struct {
+	valid   bool
+	expires time.Time
+}
+
+// CachingVerifier wraps a SignatureVerifier, typically an EIP1271Verifier, and remembers its results,
+// both positive and negative, so that clients retrying a request, or sending the same junk signature
+// repeatedly, don't each cost a call to the rescue-proxy or execution client. Errors are not cached.
+//
+// Concurrent checks of the same signature share a single call to the wrapped verifier. That call isn't
+// cancelled with the request that started it, so the wrapped verifier must bound its own calls,
+// as EIP1271Verifier does.
+type CachingVerifier struct {
+	next       SignatureVerifier
+	clock      clockwork.Clock
+	m          *metrics.MetricsRegistry
+	ttl        time.Duration
+	maxEntries int
+
+	lock    sync.Mutex
+	entries map[eip1271CacheKey]eip1271CacheEntry
+
+	group singleflight.Group
+}
+
+// NewCachingVerifier caches the results of next for ttl, keeping at most maxEntries of them.
+// Metrics are reported to m, suffixed with the name of next, so that verifiers can share a registry.
+func NewCachingVerifier(next SignatureVerifier, clock clockwork.Clock, ttl time.Duration, maxEntries int, m *metrics.MetricsRegistry) *CachingVerifier {
+	return &CachingVerifier{
+		next:       next,
+		clock:      clock,
+		m:          m,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[eip1271CacheKey]eip1271CacheEntry),
+	}
+}
+
+func (v *CachingVerifier) Name() string {
+	return v.next.Name()
+}
+
+// get returns the cached result for key, and whether there was one that hasn't expired.
+func (v *CachingVerifier) get(key eip1271CacheKey, now time.Time) (bool, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	entry, ok := v.entries[key]
+	if !ok || !entry.expires.After(now) {
+		return false, false
+	}
+	return entry.valid, true
+}
+
+// put stores a result. When the cache is full, expired entries are removed first,
+// then arbitrary ones. Returns the number of entries that were evicted.
+func (v *CachingVerifier) put(key eip1271CacheKey, valid bool, now time.Time) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	evicted := 0
+	if _, ok := v.entries[key]; !ok && len(v.entries) >= v.maxEntries {
+		for k, e := range v.entries {
+			if !e.expires.After(now) {
+				delete(v.entries, k)
+			}
+		}
+		for k := range v.entries {
+			if len(v.entries) < v.maxEntries {
+				break
+			}
+			delete(v.entries, k)
+			evicted++
+		}
+	}
+	v.entries[key] = eip1271CacheEntry{
+		valid:   valid,
+		expires: now.Add(v.ttl),
+	}
+	return evicted
+}
+
+func (v *CachingVerifier) VerifySignature(ctx context.Context, hash common.Hash, sig []byte, address common.Address) (bool, error) {
+	key := eip1271CacheKey{
+		address:  address,
+		dataHash: hash,
+		sigHash:  crypto.Keccak256Hash(sig),
+	}
+	if valid, ok := v.get(key, v.clock.Now()); ok {
+		v.m.Counter("hit_" + v.Name()).Inc()
+		return valid, nil
+	}
+	v.m.Counter("miss_" + v.Name()).Inc()
+
+	// The validation is shared, so it must not be cancelled when the request that started it is.
+	// Each caller stops waiting when its own request is cancelled instead.
+	ch := v.group.DoChan(key.String(), func() (interface{}, error) {
+		valid, err := v.next.VerifySignature(context.WithoutCancel(ctx), hash, sig, address)
+		if err != nil {
+			return false, err
+		}
+		if evicted := v.put(key, valid, v.clock.Now()); evicted > 0 {
+			v.m.Counter("evicted_" + v.Name()).Add(float64(evicted))
+		}
+		return valid, nil
+	})
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			v.m.Counter("shared_" + v.Name()).Inc()
+		}
+		if res.Err != nil {
+			return false, res.Err
+		}
+		return res.Val.(bool), nil
+	}
+}
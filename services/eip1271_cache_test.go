@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Counts validations, and blocks them until released or cancelled if release is set.
+type countingEIP1271Validator struct {
+	calls   atomic.Int32
+	valid   bool
+	err     error
+	release chan struct{}
+}
+
+func (v *countingEIP1271Validator) ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
+	v.calls.Add(1)
+	if v.release != nil {
+		select {
+		case <-v.release:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	return v.valid, v.err
+}
+
+func TestEIP1271Cache(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	if _, err := setupTestService(t, clock); err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	m := metrics.NewMetricsRegistry("eip1271_cache")
+	validator := &countingEIP1271Validator{valid: true}
+	verifier := NewCachingVerifier(NewEIP1271Verifier(ProxyEIP1271VerifierName, validator, time.Second), clock, time.Minute, 2, m)
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000a11e")
+	hash := common.HexToHash("0x01")
+	verify := func(sig []byte) (bool, error) {
+		return verifier.VerifySignature(context.Background(), hash, sig, wallet)
+	}
+
+	// Positive results are cached.
+	for i := 0; i < 3; i++ {
+		if valid, err := verify([]byte("signature")); err != nil || !valid {
+			t.Fatalf("Expected valid signature, got %v, %v", valid, err)
+		}
+	}
+	if calls := validator.calls.Load(); calls != 1 {
+		t.Fatalf("Expected 1 validation, got %d", calls)
+	}
+	if hits := testutil.ToFloat64(m.Counter("hit_" + ProxyEIP1271VerifierName)); hits != 2 {
+		t.Fatalf("Expected 2 cache hits, got %v", hits)
+	}
+	if misses := testutil.ToFloat64(m.Counter("miss_" + ProxyEIP1271VerifierName)); misses != 1 {
+		t.Fatalf("Expected 1 cache miss, got %v", misses)
+	}
+
+	// Negative results are cached too.
+	validator.valid = false
+	for i := 0; i < 2; i++ {
+		if valid, err := verify([]byte("junk")); err != nil || valid {
+			t.Fatalf("Expected invalid signature, got %v, %v", valid, err)
+		}
+	}
+	if calls := validator.calls.Load(); calls != 2 {
+		t.Fatalf("Expected 2 validations, got %d", calls)
+	}
+
+	// Results expire.
+	clock.Advance(time.Minute)
+	if valid, err := verify([]byte("signature")); err != nil || valid {
+		t.Fatalf("Expected expired result to be validated again, got %v, %v", valid, err)
+	}
+	if calls := validator.calls.Load(); calls != 3 {
+		t.Fatalf("Expected 3 validations, got %d", calls)
+	}
+
+	// Errors are not cached.
+	validator.err = errors.New("timeout")
+	for i := 0; i < 2; i++ {
+		if _, err := verify([]byte("other")); err == nil {
+			t.Fatalf("Expected error")
+		}
+	}
+	if calls := validator.calls.Load(); calls != 5 {
+		t.Fatalf("Expected 5 validations, got %d", calls)
+	}
+	validator.err = nil
+
+	// The cache is bounded.
+	for _, sig := range []string{"a", "b", "c"} {
+		if _, err := verify([]byte(sig)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if n := len(verifier.entries); n > 2 {
+		t.Fatalf("Expected at most 2 cached results, got %d", n)
+	}
+}
+
+func TestEIP1271CacheSingleFlight(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	if _, err := setupTestService(t, clock); err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	m := metrics.NewMetricsRegistry("eip1271_cache")
+	validator := &countingEIP1271Validator{valid: true, release: make(chan struct{})}
+	verifier := NewCachingVerifier(NewEIP1271Verifier(ProxyEIP1271VerifierName, validator, time.Second), clock, time.Minute, 100, m)
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000a11e")
+	hash := common.HexToHash("0x01")
+
+	// Concurrent lookups of the same signature wait for the first one.
+	const lookups = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, lookups)
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			valid, err := verifier.VerifySignature(context.Background(), hash, []byte("signature"), wallet)
+			results <- err == nil && valid
+		}()
+	}
+	// Give the lookups time to reach the in-flight validation before releasing it.
+	for validator.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(validator.release)
+	wg.Wait()
+	close(results)
+
+	for valid := range results {
+		if !valid {
+			t.Fatalf("Expected valid signature")
+		}
+	}
+	if calls := validator.calls.Load(); calls != 1 {
+		t.Fatalf("Expected 1 validation, got %d", calls)
+	}
+}
+
+func TestEIP1271CacheCancellation(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	if _, err := setupTestService(t, clock); err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	m := metrics.NewMetricsRegistry("eip1271_cache")
+	validator := &countingEIP1271Validator{valid: true, release: make(chan struct{})}
+	verifier := NewCachingVerifier(NewEIP1271Verifier(ProxyEIP1271VerifierName, validator, time.Minute), clock, time.Minute, 100, m)
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000a11e")
+	hash := common.HexToHash("0x01")
+
+	// The first request starts the validation, and a second one waits for it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := verifier.VerifySignature(ctx, hash, []byte("signature"), wallet)
+		first <- err
+	}()
+	for validator.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan bool)
+	go func() {
+		valid, err := verifier.VerifySignature(context.Background(), hash, []byte("signature"), wallet)
+		second <- err == nil && valid
+	}()
+
+	// Cancelling the first request doesn't cancel the validation the second one waits for.
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled request to give up, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(validator.release)
+	if !<-second {
+		t.Fatalf("Expected valid signature")
+	}
+	if calls := validator.calls.Load(); calls != 1 {
+		t.Fatalf("Expected 1 validation, got %d", calls)
+	}
+
+	// Validations are bounded by the EIP-1271 verifier's timeout, whether they are shared or not.
+	validator = &countingEIP1271Validator{valid: true, release: make(chan struct{})}
+	uncached := NewEIP1271Verifier(ProxyEIP1271VerifierName, validator, 10*time.Millisecond)
+	if _, err := uncached.VerifySignature(context.Background(), hash, []byte("other"), wallet); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the validation to time out, got %v", err)
+	}
+	verifier = NewCachingVerifier(uncached, clock, time.Minute, 100, m)
+	if _, err := verifier.VerifySignature(context.Background(), hash, []byte("other"), wallet); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the shared validation to time out, got %v", err)
+	}
+}
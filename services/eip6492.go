@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// EIP6492Verifier validates EIP-6492 signatures, i.e. EIP-1271 signatures of smart contract
+// wallets that may not be deployed yet, by simulating their deployment.
+type EIP6492Verifier interface {
+	ValidateEIP6492(ctx context.Context, dataHash *common.Hash, signature *util.EIP6492Signature, address *common.Address) (bool, error)
+}
+
+// validateEIP6492Signature unwraps an EIP-6492 signature and validates it for expectedNodeId.
+// Without an EIP6492Verifier, the wallet must already be deployed, in which case the wrapped
+// signature is checked by the configured signature verifiers like any other.
+func (s *Service) validateEIP6492Signature(ctx context.Context, hash []byte, sig []byte, expectedNodeId common.Address) error {
+	wrapped, err := util.ParseEIP6492Signature(sig)
+	if err != nil {
+		s.logger.Warn("Rejected malformed EIP-6492 signature", zap.Error(err))
+		s.m.Counter("invalid_eip6492_signature").Inc()
+		return &AuthenticationError{"invalid EIP-6492 signature"}
+	}
+
+	dataHash := common.BytesToHash(hash)
+	if s.eip6492Verifier == nil {
+		return s.verifySignature(ctx, dataHash, wrapped.Signature, expectedNodeId)
+	}
+	valid, err := s.eip6492Verifier.ValidateEIP6492(ctx, &dataHash, wrapped, &expectedNodeId)
+	if err != nil {
+		s.m.Counter("eip6492_validation_error").Inc()
+		return &AuthenticationError{fmt.Sprintf("failed to validate EIP-6492 signature: %v", err)}
+	}
+	if !valid {
+		s.m.Counter("failed_auth").Inc()
+		return &AuthenticationError{"invalid signature: EIP-6492 validation failed"}
+	}
+	s.m.Counter("eip6492_signature").Inc()
+	return nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+// Simulates a counterfactual wallet at address, deployed by factory and owned by owner.
+type testEIP6492Verifier struct {
+	factory common.Address
+	address common.Address
+	owner   common.Address
+	calls   int
+}
+
+func (v *testEIP6492Verifier) ValidateEIP6492(ctx context.Context, dataHash *common.Hash, signature *util.EIP6492Signature, address *common.Address) (bool, error) {
+	v.calls++
+	if signature.Factory != v.factory || *address != v.address {
+		return false, nil
+	}
+	signer, err := util.RecoverAddressFromHash(dataHash.Bytes(), signature.Signature)
+	if err != nil {
+		return false, nil
+	}
+	return *signer == v.owner, nil
+}
+
+func TestEIP6492Requests(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	// The node address is that of an undeployed wallet, owned by another key.
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	owner, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	verifier := &testEIP6492Verifier{
+		factory: common.HexToAddress("0x00000000000000000000000000000000000fac70"),
+		address: *node.Address,
+		owner:   *owner.Address,
+	}
+	svc.eip6492Verifier = verifier
+
+	msg := []byte(fmt.Sprintf("Rescue Node %d", clock.Now().Unix()))
+	wrap := func(signer *util.Wallet, factory common.Address) []byte {
+		inner, err := signer.Sign(msg)
+		if err != nil {
+			t.Fatalf("Could not sign message: %v", err)
+		}
+		sig, err := (&util.EIP6492Signature{
+			Factory:         factory,
+			FactoryCalldata: common.FromHex("0xdeadbeef"),
+			Signature:       inner,
+		}).Bytes()
+		if err != nil {
+			t.Fatalf("Could not wrap signature: %v", err)
+		}
+		return sig
+	}
+
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, wrap(owner, verifier.factory), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// A wrapped signature of the node key itself is not valid for the wallet.
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, wrap(node, verifier.factory), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected signature to be rejected, got %v", err)
+	}
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, wrap(owner, common.HexToAddress("0x01")), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected signature with another factory to be rejected, got %v", err)
+	}
+
+	// Malformed wrappers are rejected without calling the verifier.
+	calls := verifier.calls
+	malformed := append(make([]byte, 64), common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")...)
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, malformed, *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected malformed signature to be rejected, got %v", err)
+	}
+	if verifier.calls != calls {
+		t.Fatalf("Verifier should not be called for malformed signatures")
+	}
+
+	// Without a verifier, the wrapped signature is checked by the signature verifiers, here only EOA.
+	svc.eip6492Verifier = nil
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, wrap(owner, verifier.factory), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected signature to be rejected, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/Rocket-Rescue-Node/credentials"
@@ -11,22 +13,38 @@ import (
 
 type OperatorInfo struct {
 	CredentialEvents []int64 `json:"credentialEvents"`
+	// The effective quota for the node, including its quota override.
+	QuotaSettings json.RawMessage `json:"quotaSettings"`
+	// The number of emergency credits the node has left.
+	EmergencyCredits int64 `json:"emergencyCredits"`
 }
 
-func (s *Service) GetOperatorInfo(msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*OperatorInfo, error) {
+func (s *Service) GetOperatorInfo(ctx context.Context, msg []byte, sig []byte, expectedNodeId common.Address, ot credentials.OperatorType) (*OperatorInfo, error) {
 	var err error
 
 	// Validate request
-	nodeID, err := s.validateSignedRequest(msg, sig, expectedNodeId, ot)
+	nodeID, err := s.validateSignedRequest(ctx, msg, sig, expectedNodeId, ot, opGetOperatorInfo)
 	if err != nil {
 		return nil, err
 	}
 
 	// Query credentials issued for this nodeID in the current window.
 	now := s.clock.Now()
-	currentWindowStart := now.Add(-credsQuotaWindow(ot)).Unix()
+	q, err := s.getNodeQuota(ctx, s.getQuotaOverrideStmt, nodeID, ot)
+	if err != nil {
+		return nil, err
+	}
+	quotaSettings, err := quotaJSON(q)
+	if err != nil {
+		return nil, err
+	}
+	currentWindowStart := now.Add(-q.window).Unix()
+	credits, err := getEmergencyCredits(ctx, s.getCreditsStmt, nodeID, ot)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := s.getCredEventTimestampsStmt.Query(nodeID.Bytes(), currentWindowStart, now.Unix(), models.CredentialIssued, ot)
+	rows, err := s.getCredEventTimestampsStmt.QueryContext(ctx, nodeID.Bytes(), currentWindowStart, now.Unix(), models.CredentialIssued, ot)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +52,6 @@ func (s *Service) GetOperatorInfo(msg []byte, sig []byte, expectedNodeId common.
 
 	// Parse credential events
 	var events = []int64{}
-	var credCount int64 = 0
 	for rows.Next() {
 		row_timestamp := int64(0)
 		if err := rows.Scan(&row_timestamp); err != nil {
@@ -42,10 +59,6 @@ func (s *Service) GetOperatorInfo(msg []byte, sig []byte, expectedNodeId common.
 			continue
 		}
 		events = append(events, row_timestamp)
-		credCount += 1
-		if credCount == credsQuota(ot) {
-			break
-		}
 	}
 
 	s.logger.Info(
@@ -55,5 +68,9 @@ func (s *Service) GetOperatorInfo(msg []byte, sig []byte, expectedNodeId common.
 	)
 	s.m.Counter("retrieved_operator_info").Inc()
 
-	return &OperatorInfo{CredentialEvents: events}, nil
+	return &OperatorInfo{
+		CredentialEvents: events,
+		QuotaSettings:    quotaSettings,
+		EmergencyCredits: credits,
+	}, nil
 }
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -21,7 +22,7 @@ func getOperatorInfo(svc *Service, node *util.Wallet) (*OperatorInfo, error) {
 	}
 
 	// Get operator info
-	info, err := svc.GetOperatorInfo(msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	info, err := svc.GetOperatorInfo(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +49,10 @@ func TestGetOperatorInfo(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create node: %v", err)
 	}
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get quota: %v", err)
+	}
 
 	// Test operator info without cred events
 	i0, err := getOperatorInfo(svc, node)
@@ -79,9 +84,9 @@ func TestGetOperatorInfo(t *testing.T) {
 	}
 
 	// Advance the clock just before credsMinValidityWindow expires.
-	clock.Advance(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - credsMinValidityWindow - 1*time.Second)
+	clock.Advance(svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) - credsMinValidityWindow - 1*time.Second)
 	// Make sure that the node registry is considered up-to-date.
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	// Check that the reissued credential matches expected values
 	c1Reissued, err := createValidCredential(svc, node)
 	if err != nil {
@@ -95,9 +100,9 @@ func TestGetOperatorInfo(t *testing.T) {
 	// Create up to the maximum number of credentials, advancing the clock
 	// by authValidityWindow each time, and making sure that new info is retrieved
 	prevInfo := i1
-	for i := 1; i < int(quotas[pb.OperatorType_OT_ROCKETPOOL].count); i++ {
-		clock.Advance(AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL))
-		svc.nodes.LastUpdated = svc.clock.Now()
+	for i := 1; i < int(q.count); i++ {
+		clock.Advance(svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL))
+		svc.nodes.SetLastUpdated(svc.clock.Now())
 		_, err = createValidCredential(svc, node)
 		if err != nil {
 			t.Fatalf("Could not create credential: %v", err)
@@ -118,13 +123,13 @@ func TestGetOperatorInfo(t *testing.T) {
 	i2 := prevInfo
 
 	// Advance the clock just enough so that the oldest credential is not within
-	// credsQuotaWindow anymore. This should decrease cred event count by 1.
+	// the quota window anymore. This should decrease cred event count by 1.
 	if len(i2.CredentialEvents) != 4 {
 		t.Fatalf("Started with incorrect cred count. Expected 4, got %d", len(i2.CredentialEvents))
 	}
-	i2InfoQuotaExpiry := time.Unix(i2.CredentialEvents[len(i2.CredentialEvents)-1], 0).Add(quotas[pb.OperatorType_OT_ROCKETPOOL].window)
+	i2InfoQuotaExpiry := time.Unix(i2.CredentialEvents[len(i2.CredentialEvents)-1], 0).Add(q.window)
 	clock.Advance(i2InfoQuotaExpiry.Sub(clock.Now()))
-	svc.nodes.LastUpdated = svc.clock.Now()
+	svc.nodes.SetLastUpdated(svc.clock.Now())
 	i3, err := getOperatorInfo(svc, node)
 	if err != nil {
 		t.Fatalf("Could not get operator info: %v", err)
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"gopkg.in/yaml.v3"
+)
+
+type quota struct {
+	// Max number of credentials that can be requested in a given time window.
+	count uint
+	// Time window in which the credential quota is calculated.
+	window time.Duration
+	// Duration a credential is valid for
+	authValidityWindow time.Duration
+}
+
+// QuotaPolicy contains the credential quota enforced for each operator type.
+type QuotaPolicy map[credentials.OperatorType]quota
+
+// The names used for each operator type in quota policy files.
+var quotaPolicyOperatorTypes = map[string]credentials.OperatorType{
+	"rocketpool": pb.OperatorType_OT_ROCKETPOOL,
+	"solo":       pb.OperatorType_OT_SOLO,
+}
+
+// ParseOperatorType returns the operator type with the given name, e.g. "solo".
+func ParseOperatorType(name string) (credentials.OperatorType, error) {
+	ot, ok := quotaPolicyOperatorTypes[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown operator type %q", name)
+	}
+	return ot, nil
+}
+
+func operatorTypeName(ot credentials.OperatorType) (string, bool) {
+	for name, t := range quotaPolicyOperatorTypes {
+		if t == ot {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// DefaultQuotaPolicy returns the quota policy used when no policy file is provided.
+func DefaultQuotaPolicy() QuotaPolicy {
+	return QuotaPolicy{
+		pb.OperatorType_OT_ROCKETPOOL: {
+			count:              4,
+			window:             time.Duration(365*24) * time.Hour,
+			authValidityWindow: time.Duration(15*24) * time.Hour,
+		},
+		pb.OperatorType_OT_SOLO: {
+			count:              3,
+			window:             time.Duration(365*24) * time.Hour,
+			authValidityWindow: time.Duration(10*24) * time.Hour,
+		},
+	}
+}
+
+// LoadQuotaPolicy reads a quota policy from a YAML file, e.g.
+//
+//	rocketpool:
+//	  count: 4
+//	  window: 8760h
+//	  authValidityWindow: 360h
+//	solo:
+//	  count: 3
+//	  window: 8760h
+//	  authValidityWindow: 240h
+//
+// Every operator type must be present.
+func LoadQuotaPolicy(path string) (QuotaPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseQuotaPolicy(data)
+}
+
+func parseQuotaPolicy(data []byte) (QuotaPolicy, error) {
+	var file map[string]struct {
+		Count              uint          `yaml:"count"`
+		Window             time.Duration `yaml:"window"`
+		AuthValidityWindow time.Duration `yaml:"authValidityWindow"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid quota policy: %w", err)
+	}
+
+	policy := make(QuotaPolicy)
+	for name, q := range file {
+		ot, err := ParseOperatorType(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota policy: %w", err)
+		}
+		if q.Count == 0 {
+			return nil, fmt.Errorf("invalid quota policy: %s count must be at least 1", name)
+		}
+		if q.Window <= 0 || q.AuthValidityWindow <= 0 {
+			return nil, fmt.Errorf("invalid quota policy: %s windows must be positive", name)
+		}
+		if q.AuthValidityWindow > q.Window {
+			return nil, fmt.Errorf("invalid quota policy: %s authValidityWindow must not exceed window", name)
+		}
+		policy[ot] = quota{
+			count:              q.Count,
+			window:             q.Window,
+			authValidityWindow: q.AuthValidityWindow,
+		}
+	}
+	for name, ot := range quotaPolicyOperatorTypes {
+		if _, ok := policy[ot]; !ok {
+			return nil, fmt.Errorf("invalid quota policy: missing operator type %q", name)
+		}
+	}
+
+	return policy, nil
+}
+
+// SetQuotaPolicy replaces the quota policy enforced by the service.
+// Requests that are already being processed keep using the previous policy.
+func (s *Service) SetQuotaPolicy(quotas QuotaPolicy) {
+	s.quotas.Store(&quotas)
+}
+
+// getQuota returns the quota for an operator type, as a single snapshot of the current policy.
+func (s *Service) getQuota(ot credentials.OperatorType) quota {
+	q, ok := (*s.quotas.Load())[ot]
+	if !ok {
+		// Default to one credential a year, valid for 10 days.
+		return quota{
+			count:              1,
+			window:             time.Duration(365*24) * time.Hour,
+			authValidityWindow: time.Duration(10*24) * time.Hour,
+		}
+	}
+
+	return q
+}
+
+func (s *Service) AuthValidityWindow(ot credentials.OperatorType) time.Duration {
+	return s.getQuota(ot).authValidityWindow
+}
+
+func quotaJSON(q quota) (json.RawMessage, error) {
+	quotaData := map[string]interface{}{
+		"count":              q.count,
+		"window":             int64(q.window.Seconds()),
+		"authValidityWindow": int64(q.authValidityWindow.Seconds()),
+	}
+
+	quotaJson, err := json.Marshal(quotaData)
+	if err != nil {
+		return nil, err
+	}
+
+	return quotaJson, nil
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// QuotaOverride replaces parts of the quota policy for a single node and operator type.
+// Nil fields fall back to the policy. Windows are expressed in seconds.
+//
+// Note that the rescue proxy enforces its own maximum validity window for each operator type,
+// so an AuthValidityWindow longer than the proxy's does not extend how long credentials are accepted.
+type QuotaOverride struct {
+	NodeID             common.Address           `json:"nodeId"`
+	OperatorType       credentials.OperatorType `json:"-"`
+	Count              *uint                    `json:"count,omitempty"`
+	Window             *int64                   `json:"window,omitempty"`
+	AuthValidityWindow *int64                   `json:"authValidityWindow,omitempty"`
+	Reason             string                   `json:"reason,omitempty"`
+	Creator            string                   `json:"creator,omitempty"`
+}
+
+type quotaOverrideJSON struct {
+	OperatorType string `json:"operatorType"`
+	*quotaOverrideAlias
+}
+
+type quotaOverrideAlias QuotaOverride
+
+func (o QuotaOverride) MarshalJSON() ([]byte, error) {
+	name, ok := operatorTypeName(o.OperatorType)
+	if !ok {
+		return nil, fmt.Errorf("unknown operator type %d", o.OperatorType)
+	}
+	return json.Marshal(quotaOverrideJSON{name, (*quotaOverrideAlias)(&o)})
+}
+
+func (o *QuotaOverride) UnmarshalJSON(data []byte) error {
+	aux := quotaOverrideJSON{quotaOverrideAlias: (*quotaOverrideAlias)(o)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&aux); err != nil {
+		return err
+	}
+	ot, err := ParseOperatorType(aux.OperatorType)
+	if err != nil {
+		return err
+	}
+	o.OperatorType = ot
+	return nil
+}
+
+// Applies the override to a policy quota.
+func (o *QuotaOverride) apply(q quota) quota {
+	if o.Count != nil {
+		q.count = *o.Count
+	}
+	if o.Window != nil {
+		q.window = time.Duration(*o.Window) * time.Second
+	}
+	if o.AuthValidityWindow != nil {
+		q.authValidityWindow = time.Duration(*o.AuthValidityWindow) * time.Second
+	}
+	return q
+}
+
+func validateQuotaOverride(o *QuotaOverride) error {
+	if o.NodeID == (common.Address{}) {
+		return &ValidationError{"missing node ID"}
+	}
+	if _, ok := operatorTypeName(o.OperatorType); !ok {
+		return &ValidationError{fmt.Sprintf("unknown operator type %d", o.OperatorType)}
+	}
+	if o.Count == nil && o.Window == nil && o.AuthValidityWindow == nil {
+		return &ValidationError{"override does not change the quota"}
+	}
+	if (o.Window != nil && *o.Window <= 0) || (o.AuthValidityWindow != nil && *o.AuthValidityWindow <= 0) {
+		return &ValidationError{"windows must be positive"}
+	}
+	return nil
+}
+
+// Converts an optional override value to a database value. Nil is stored as NULL.
+func nullableInt64[T uint | int64](v *T) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
+
+// Scans the override columns of a row into o.
+func scanQuotaOverride(scan func(dest ...any) error, o *QuotaOverride, dest ...any) error {
+	var count, window, authValidityWindow sql.NullInt64
+	if err := scan(append(dest, &count, &window, &authValidityWindow, &o.Reason, &o.Creator)...); err != nil {
+		return err
+	}
+	if count.Valid {
+		c := uint(count.Int64)
+		o.Count = &c
+	}
+	if window.Valid {
+		o.Window = &window.Int64
+	}
+	if authValidityWindow.Valid {
+		o.AuthValidityWindow = &authValidityWindow.Int64
+	}
+	return nil
+}
+
+// getNodeQuota returns the effective quota for a node: the policy quota for its operator type,
+// with the node's override applied. gqos must be the getQuotaOverrideStmt, optionally bound to a transaction.
+func (s *Service) getNodeQuota(ctx context.Context, gqos *sql.Stmt, nodeID common.Address, ot credentials.OperatorType) (quota, error) {
+	q := s.getQuota(ot)
+
+	var o QuotaOverride
+	err := scanQuotaOverride(gqos.QueryRowContext(ctx, nodeID.Bytes(), ot).Scan, &o)
+	if errors.Is(err, sql.ErrNoRows) {
+		return q, nil
+	}
+	if err != nil {
+		return quota{}, err
+	}
+
+	s.m.Counter("quota_override_applied").Inc()
+	return o.apply(q), nil
+}
+
+// NodeAuthValidityWindow returns how long credentials issued to a node are valid for,
+// taking its quota override into account.
+func (s *Service) NodeAuthValidityWindow(ctx context.Context, nodeID common.Address, ot credentials.OperatorType) (time.Duration, error) {
+	q, err := s.getNodeQuota(ctx, s.getQuotaOverrideStmt, nodeID, ot)
+	if err != nil {
+		return 0, err
+	}
+	return q.authValidityWindow, nil
+}
+
+// Returns all quota overrides.
+func (s *Service) GetQuotaOverrides(ctx context.Context) ([]QuotaOverride, error) {
+	rows, err := s.getQuotaOverridesStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []QuotaOverride{}
+	for rows.Next() {
+		var nodeID []byte
+		var o QuotaOverride
+		if err := scanQuotaOverride(rows.Scan, &o, &nodeID, &o.OperatorType); err != nil {
+			return nil, err
+		}
+		o.NodeID = common.BytesToAddress(nodeID)
+		overrides = append(overrides, o)
+	}
+
+	return overrides, rows.Err()
+}
+
+// Creates a quota override, replacing any existing override for the same node and operator type.
+// The override's creator is set to actor, and the change is recorded in the audit trail.
+func (s *Service) SetQuotaOverride(ctx context.Context, o QuotaOverride, actor string) (*QuotaOverride, error) {
+	if err := validateQuotaOverride(&o); err != nil {
+		return nil, err
+	}
+	// Fields that aren't overridden fall back to the policy, so check the quota that the node will get.
+	if q := o.apply(s.getQuota(o.OperatorType)); q.authValidityWindow > q.window {
+		return nil, &ValidationError{"authValidityWindow must not exceed window"}
+	}
+	o.Creator = actor
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	sqos := tx.StmtContext(ctx, s.setQuotaOverrideStmt)
+	defer sqos.Close()
+	if _, err := sqos.ExecContext(ctx,
+		o.NodeID.Bytes(),
+		o.OperatorType,
+		nullableInt64(o.Count),
+		nullableInt64(o.Window),
+		nullableInt64(o.AuthValidityWindow),
+		o.Reason,
+		o.Creator,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := s.addQuotaAuditEvent(ctx, tx, actor, authz.RuleCreated, &o); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Set quota override",
+		zap.String("nodeID", o.NodeID.Hex()),
+		zap.String("operatorType", o.OperatorType.String()),
+		zap.Any("count", o.Count),
+		zap.Any("window", o.Window),
+		zap.Any("authValidityWindow", o.AuthValidityWindow),
+		zap.String("reason", o.Reason),
+		zap.String("actor", actor),
+	)
+	s.m.Counter("quota_override_set").Inc()
+	return &o, nil
+}
+
+// Deletes the quota override for a node and operator type.
+// The change is recorded in the audit trail on behalf of actor.
+func (s *Service) DeleteQuotaOverride(ctx context.Context, nodeID common.Address, ot credentials.OperatorType, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	o := QuotaOverride{NodeID: nodeID, OperatorType: ot}
+	dqos := tx.StmtContext(ctx, s.deleteQuotaOverrideStmt)
+	defer dqos.Close()
+	err = scanQuotaOverride(dqos.QueryRowContext(ctx, nodeID.Bytes(), ot).Scan, &o)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{"quota override not found"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.addQuotaAuditEvent(ctx, tx, actor, authz.RuleDeleted, &o); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Deleted quota override",
+		zap.String("nodeID", nodeID.Hex()),
+		zap.String("operatorType", ot.String()),
+		zap.String("actor", actor),
+	)
+	s.m.Counter("quota_override_deleted").Inc()
+	return nil
+}
+
+// QuotaOverrideAuditEvent records a change made to the quota overrides, and who made it.
+type QuotaOverrideAuditEvent struct {
+	Timestamp int64               `json:"timestamp"`
+	Actor     string              `json:"actor"`
+	Operation authz.RuleOperation `json:"operation"`
+	Override  QuotaOverride       `json:"override"`
+}
+
+// Returns the most recent changes made to the quota overrides, newest first.
+func (s *Service) GetQuotaOverrideAuditEvents(ctx context.Context) ([]QuotaOverrideAuditEvent, error) {
+	rows, err := s.getQuotaAuditEventsStmt.QueryContext(ctx, maxAuditEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []QuotaOverrideAuditEvent{}
+	for rows.Next() {
+		var nodeID []byte
+		var count, window, authValidityWindow sql.NullInt64
+		var e QuotaOverrideAuditEvent
+		if err := rows.Scan(
+			&e.Timestamp,
+			&e.Actor,
+			&e.Operation,
+			&nodeID,
+			&e.Override.OperatorType,
+			&count,
+			&window,
+			&authValidityWindow,
+			&e.Override.Reason,
+		); err != nil {
+			return nil, err
+		}
+		e.Override.NodeID = common.BytesToAddress(nodeID)
+		if count.Valid {
+			c := uint(count.Int64)
+			e.Override.Count = &c
+		}
+		if window.Valid {
+			e.Override.Window = &window.Int64
+		}
+		if authValidityWindow.Valid {
+			e.Override.AuthValidityWindow = &authValidityWindow.Int64
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Service) addQuotaAuditEvent(ctx context.Context, tx *sql.Tx, actor string, op authz.RuleOperation, o *QuotaOverride) error {
+	aqas := tx.StmtContext(ctx, s.addQuotaAuditEventStmt)
+	defer aqas.Close()
+	_, err := aqas.ExecContext(ctx,
+		s.clock.Now().Unix(),
+		actor,
+		op,
+		o.NodeID.Bytes(),
+		o.OperatorType,
+		nullableInt64(o.Count),
+		nullableInt64(o.Window),
+		nullableInt64(o.AuthValidityWindow),
+		o.Reason,
+	)
+	return err
+}
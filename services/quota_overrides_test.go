@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+// Returns the quota overrides of a node. Other tests share the database, so only the node's overrides are returned.
+func getNodeQuotaOverrides(t *testing.T, svc *Service, nodeID common.Address) []QuotaOverride {
+	overrides, err := svc.GetQuotaOverrides(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get quota overrides: %v", err)
+	}
+	out := []QuotaOverride{}
+	for _, o := range overrides {
+		if o.NodeID == nodeID {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func TestQuotaOverrideJSON(t *testing.T) {
+	data := `{"nodeId": "0x0000000000000000000000000000000000000001", "operatorType": "solo", "count": 5, "reason": "outage"}`
+	var o QuotaOverride
+	if err := json.Unmarshal([]byte(data), &o); err != nil {
+		t.Fatalf("Could not unmarshal quota override: %v", err)
+	}
+	if o.OperatorType != pb.OperatorType_OT_SOLO || o.Count == nil || *o.Count != 5 || o.Window != nil || o.Reason != "outage" {
+		t.Fatalf("Unexpected quota override %+v", o)
+	}
+
+	out, err := json.Marshal(o)
+	if err != nil {
+		t.Fatalf("Could not marshal quota override: %v", err)
+	}
+	expected := `{"operatorType":"solo","nodeId":"0x0000000000000000000000000000000000000001","count":5,"reason":"outage"}`
+	if string(out) != expected {
+		t.Fatalf("Unexpected quota override json %s", out)
+	}
+
+	for _, data := range []string{
+		`{"nodeId": "0x0000000000000000000000000000000000000001", "operatorType": "whale", "count": 5}`,
+		`{"nodeId": "0x0000000000000000000000000000000000000001", "operatorType": "solo", "burst": 5}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &QuotaOverride{}); err == nil {
+			t.Fatalf("Expected %s to be rejected", data)
+		}
+	}
+}
+
+func TestQuotaOverrides(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	// Invalid overrides are rejected.
+	count := uint(6)
+	zero := int64(0)
+	day := int64(24 * 60 * 60)
+	year := int64(365 * 24 * 60 * 60)
+	twoYears := 2 * year
+	for name, o := range map[string]QuotaOverride{
+		"missing_node":    {OperatorType: pb.OperatorType_OT_ROCKETPOOL, Count: &count},
+		"unknown_type":    {NodeID: *node.Address, OperatorType: 42, Count: &count},
+		"no_changes":      {NodeID: *node.Address, OperatorType: pb.OperatorType_OT_ROCKETPOOL},
+		"zero_window":     {NodeID: *node.Address, OperatorType: pb.OperatorType_OT_ROCKETPOOL, Window: &zero},
+		"validity_window": {NodeID: *node.Address, OperatorType: pb.OperatorType_OT_ROCKETPOOL, Window: &day, AuthValidityWindow: &year},
+		// The policy's authValidityWindow is longer than a day, and its window shorter than two years.
+		"policy_validity_window": {NodeID: *node.Address, OperatorType: pb.OperatorType_OT_ROCKETPOOL, Window: &day},
+		"policy_window":          {NodeID: *node.Address, OperatorType: pb.OperatorType_OT_ROCKETPOOL, AuthValidityWindow: &twoYears},
+	} {
+		if _, err := svc.SetQuotaOverride(context.Background(), o, "alice"); !errors.Is(err, &ValidationError{}) {
+			t.Fatalf("Expected %s override to be rejected, got %v", name, err)
+		}
+	}
+
+	// The node can get more credentials than the policy allows, each valid for a day.
+	policy := svc.getQuota(pb.OperatorType_OT_ROCKETPOOL)
+	created, err := svc.SetQuotaOverride(context.Background(), QuotaOverride{
+		NodeID:             *node.Address,
+		OperatorType:       pb.OperatorType_OT_ROCKETPOOL,
+		Count:              &count,
+		AuthValidityWindow: &day,
+		Reason:             "client outage",
+	}, "alice")
+	if err != nil {
+		t.Fatalf("Could not set quota override: %v", err)
+	}
+	if created.Creator != "alice" {
+		t.Fatalf("Incorrect creator. Expected alice, got %s", created.Creator)
+	}
+	overrides := getNodeQuotaOverrides(t, svc, *node.Address)
+	if len(overrides) != 1 || *overrides[0].Count != count || overrides[0].Window != nil || *overrides[0].AuthValidityWindow != day {
+		t.Fatalf("Unexpected quota overrides %+v", overrides)
+	}
+
+	for i := 0; i < int(count); i++ {
+		if _, err := createValidCredential(svc, node); err != nil {
+			t.Fatalf("Could not create credential %d: %v", i, err)
+		}
+		// Let the credential expire, so that the next request creates a new one.
+		clock.Advance(25 * time.Hour)
+		svc.nodes.SetLastUpdated(clock.Now())
+	}
+	if _, err := createValidCredential(svc, node); !errors.Is(err, &AuthorizationError{}) {
+		t.Fatalf("Expected quota to be exceeded, got %v", err)
+	}
+
+	window, err := svc.NodeAuthValidityWindow(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get auth validity window: %v", err)
+	}
+	if window != 24*time.Hour {
+		t.Fatalf("Incorrect auth validity window. Expected 24h, got %v", window)
+	}
+
+	// The operator info reports the effective quota.
+	info, err := getOperatorInfo(svc, node)
+	if err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if len(info.CredentialEvents) != int(count) {
+		t.Fatalf("Incorrect credential event count. Expected %d, got %d", count, len(info.CredentialEvents))
+	}
+	expected, err := quotaJSON(quota{count: count, window: policy.window, authValidityWindow: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Could not get quota settings json: %v", err)
+	}
+	if string(info.QuotaSettings) != string(expected) {
+		t.Fatalf("Unexpected quota settings %s, expected %s", info.QuotaSettings, expected)
+	}
+
+	// Other operator types of the same node are not affected.
+	soloWindow, err := svc.NodeAuthValidityWindow(context.Background(), *node.Address, pb.OperatorType_OT_SOLO)
+	if err != nil {
+		t.Fatalf("Could not get auth validity window: %v", err)
+	}
+	if soloWindow != svc.AuthValidityWindow(pb.OperatorType_OT_SOLO) {
+		t.Fatalf("Unexpected solo auth validity window %v", soloWindow)
+	}
+
+	// Deleting the override restores the policy quota.
+	if err := svc.DeleteQuotaOverride(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, "bob"); err != nil {
+		t.Fatalf("Could not delete quota override: %v", err)
+	}
+	if err := svc.DeleteQuotaOverride(context.Background(), *node.Address, pb.OperatorType_OT_ROCKETPOOL, "bob"); !errors.Is(err, &NotFoundError{}) {
+		t.Fatalf("Expected NotFoundError, got %v", err)
+	}
+	if overrides := getNodeQuotaOverrides(t, svc, *node.Address); len(overrides) != 0 {
+		t.Fatalf("Unexpected quota overrides %+v", overrides)
+	}
+	q, err := svc.getNodeQuota(context.Background(), svc.getQuotaOverrideStmt, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+	if err != nil {
+		t.Fatalf("Could not get node quota: %v", err)
+	}
+	if q != policy {
+		t.Fatalf("Unexpected node quota %+v, expected %+v", q, policy)
+	}
+
+	// Both changes were audited, newest first. Only look at this node's events.
+	allEvents, err := svc.GetQuotaOverrideAuditEvents(context.Background())
+	if err != nil {
+		t.Fatalf("Could not get audit events: %v", err)
+	}
+	events := []QuotaOverrideAuditEvent{}
+	for _, e := range allEvents {
+		if e.Override.NodeID == *node.Address {
+			events = append(events, e)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("Incorrect audit event count. Expected 2, got %d", len(events))
+	}
+	for i, e := range []struct {
+		actor string
+		op    authz.RuleOperation
+	}{
+		{"bob", authz.RuleDeleted},
+		{"alice", authz.RuleCreated},
+	} {
+		o := events[i].Override
+		if events[i].Actor != e.actor || events[i].Operation != e.op || *o.Count != count || *o.AuthValidityWindow != day || o.Reason != "client outage" {
+			t.Fatalf("Unexpected audit event %d: %+v", i, events[i])
+		}
+	}
+}
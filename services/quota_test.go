@@ -0,0 +1,77 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+)
+
+func TestParseQuotaPolicy(t *testing.T) {
+	valid := `
+rocketpool:
+  count: 5
+  window: 4380h
+  authValidityWindow: 168h
+solo:
+  count: 2
+  window: 8760h
+  authValidityWindow: 240h
+`
+	policy, err := parseQuotaPolicy([]byte(valid))
+	if err != nil {
+		t.Fatalf("Could not parse quota policy: %v", err)
+	}
+	rp := policy[pb.OperatorType_OT_ROCKETPOOL]
+	if rp.count != 5 || rp.window != 4380*time.Hour || rp.authValidityWindow != 168*time.Hour {
+		t.Fatalf("Unexpected rocketpool quota %+v", rp)
+	}
+	solo := policy[pb.OperatorType_OT_SOLO]
+	if solo.count != 2 || solo.window != 8760*time.Hour || solo.authValidityWindow != 240*time.Hour {
+		t.Fatalf("Unexpected solo quota %+v", solo)
+	}
+
+	invalid := map[string]string{
+		"missing_solo":    "rocketpool: {count: 4, window: 8760h, authValidityWindow: 360h}",
+		"unknown_type":    valid + "whale: {count: 1, window: 1h, authValidityWindow: 1h}",
+		"unknown_field":   valid + "  burst: 1",
+		"zero_count":      "rocketpool: {count: 0, window: 8760h, authValidityWindow: 360h}\nsolo: {count: 3, window: 8760h, authValidityWindow: 240h}",
+		"bad_duration":    "rocketpool: {count: 4, window: 1y, authValidityWindow: 360h}\nsolo: {count: 3, window: 8760h, authValidityWindow: 240h}",
+		"validity_window": "rocketpool: {count: 4, window: 24h, authValidityWindow: 360h}\nsolo: {count: 3, window: 8760h, authValidityWindow: 240h}",
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseQuotaPolicy([]byte(data)); err == nil {
+				t.Fatalf("Expected invalid quota policy to be rejected")
+			}
+		})
+	}
+}
+
+func TestServiceUsesQuotaPolicy(t *testing.T) {
+	svc, err := setupTestService(t, nil)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	svc.SetQuotaPolicy(QuotaPolicy{
+		pb.OperatorType_OT_ROCKETPOOL: {count: 7, window: 48 * time.Hour, authValidityWindow: 24 * time.Hour},
+	})
+
+	q := svc.getQuota(pb.OperatorType_OT_ROCKETPOOL)
+	if q.count != 7 {
+		t.Fatalf("Unexpected quota count %d", q.count)
+	}
+	if q.window != 48*time.Hour {
+		t.Fatalf("Unexpected quota window %v", q.window)
+	}
+	if svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL) != 24*time.Hour {
+		t.Fatalf("Unexpected auth validity window %v", svc.AuthValidityWindow(pb.OperatorType_OT_ROCKETPOOL))
+	}
+	quotaJson, err := quotaJSON(q)
+	if err != nil {
+		t.Fatalf("Could not get quota settings json: %v", err)
+	}
+	if string(quotaJson) != `{"authValidityWindow":86400,"count":7,"window":172800}` {
+		t.Fatalf("Unexpected quota settings json %s", quotaJson)
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+)
+
+// Names of the readiness checks.
+const (
+	readinessNodes               = "nodes"
+	readinessWithdrawalAddresses = "withdrawal_addresses"
+	readinessDatabase            = "database"
+	readinessRescueProxy         = "rescue_proxy"
+)
+
+// ConnectionChecker checks the connection to an external service.
+type ConnectionChecker interface {
+	CheckConnection(ctx context.Context) error
+}
+
+// ReadinessCheck is the result of one of the checks that decide whether the service is ready.
+type ReadinessCheck struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+	// The number of entries in a registry, and when it was last updated.
+	Size        *int   `json:"size,omitempty"`
+	LastUpdated *int64 `json:"lastUpdated,omitempty"`
+}
+
+// Readiness tells whether the service can handle requests, and why not.
+type Readiness struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// Checks that a registry was updated recently, and isn't empty.
+// Requests are refused while it isn't, see isNodeRegistered.
+func (s *Service) checkRegistry(registry *models.NodeRegistry) ReadinessCheck {
+	size := registry.Len()
+	lastUpdated := registry.LastUpdated()
+	check := ReadinessCheck{Size: &size}
+	if !lastUpdated.IsZero() {
+		ts := lastUpdated.Unix()
+		check.LastUpdated = &ts
+	}
+
+	switch {
+	case lastUpdated.IsZero():
+		check.Error = "registry was never updated"
+	case s.clock.Now().After(lastUpdated.Add(nodeRegistryMaxAge)):
+		check.Error = "registry is stale"
+	case size == 0:
+		check.Error = "registry is empty"
+	default:
+		check.Ready = true
+	}
+	return check
+}
+
+// CheckReadiness checks the node registries, the database, and the connection to the
+// rescue-proxy. The service is ready if all checks pass.
+// Withdrawal addresses are only checked while solo validators are enabled, since they are only
+// used to authorize solo validators.
+func (s *Service) CheckReadiness(ctx context.Context) *Readiness {
+	checks := map[string]ReadinessCheck{
+		readinessNodes: s.checkRegistry(s.nodes),
+	}
+	if s.enableSoloValidators.Load() {
+		checks[readinessWithdrawalAddresses] = s.checkRegistry(s.withdrawalAddresses)
+	}
+
+	if err := s.db.PingContext(ctx); err != nil {
+		checks[readinessDatabase] = ReadinessCheck{Error: err.Error()}
+	} else {
+		checks[readinessDatabase] = ReadinessCheck{Ready: true}
+	}
+
+	if s.rescueProxy != nil {
+		if err := s.rescueProxy.CheckConnection(ctx); err != nil {
+			checks[readinessRescueProxy] = ReadinessCheck{Error: err.Error()}
+		} else {
+			checks[readinessRescueProxy] = ReadinessCheck{Ready: true}
+		}
+	}
+
+	readiness := &Readiness{Ready: true, Checks: checks}
+	for name, check := range checks {
+		if check.Ready {
+			s.m.Gauge("ready_" + name).Set(1)
+		} else {
+			s.m.Gauge("ready_" + name).Set(0)
+			readiness.Ready = false
+		}
+	}
+	return readiness
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+type testConnectionChecker struct {
+	err error
+}
+
+func (c *testConnectionChecker) CheckConnection(ctx context.Context) error {
+	return c.err
+}
+
+func TestCheckReadiness(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+	proxy := &testConnectionChecker{}
+	svc.rescueProxy = proxy
+
+	expectNotReady := func(check string, reason string) {
+		t.Helper()
+		readiness := svc.CheckReadiness(context.Background())
+		if readiness.Ready {
+			t.Fatalf("Expected service not to be ready")
+		}
+		if c := readiness.Checks[check]; c.Ready || c.Error != reason {
+			t.Fatalf("Unexpected %s check: %+v", check, c)
+		}
+	}
+
+	// The registries were never updated.
+	expectNotReady(readinessNodes, "registry was never updated")
+	expectNotReady(readinessWithdrawalAddresses, "registry was never updated")
+
+	// The registries were updated, but are empty.
+	svc.nodes.SetLastUpdated(clock.Now())
+	svc.withdrawalAddresses.SetLastUpdated(clock.Now())
+	expectNotReady(readinessNodes, "registry is empty")
+
+	if _, err := createTestNode(svc, true); err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	if _, err := createTestWithdrawalAddress(svc, true); err != nil {
+		t.Fatalf("Could not create withdrawal address: %v", err)
+	}
+	readiness := svc.CheckReadiness(context.Background())
+	if !readiness.Ready {
+		t.Fatalf("Expected service to be ready, got %+v", readiness.Checks)
+	}
+	for _, check := range []string{readinessNodes, readinessWithdrawalAddresses, readinessDatabase, readinessRescueProxy} {
+		if !readiness.Checks[check].Ready {
+			t.Fatalf("Expected %s check to pass", check)
+		}
+	}
+	if c := readiness.Checks[readinessNodes]; *c.Size != 1 || *c.LastUpdated != clock.Now().Unix() {
+		t.Fatalf("Unexpected nodes check: %+v", c)
+	}
+
+	// Withdrawal addresses are only needed while solo validators are enabled.
+	svc.withdrawalAddresses.SetLastUpdated(time.Time{})
+	expectNotReady(readinessWithdrawalAddresses, "registry was never updated")
+	svc.SetEnableSoloValidators(false)
+	readiness = svc.CheckReadiness(context.Background())
+	if !readiness.Ready {
+		t.Fatalf("Expected service to be ready without solo validators, got %+v", readiness.Checks)
+	}
+	if _, ok := readiness.Checks[readinessWithdrawalAddresses]; ok {
+		t.Fatalf("Expected withdrawal addresses not to be checked")
+	}
+	svc.SetEnableSoloValidators(true)
+	svc.withdrawalAddresses.SetLastUpdated(clock.Now())
+
+	// The proxy connection is failing.
+	proxy.err = errors.New("connection refused")
+	expectNotReady(readinessRescueProxy, "connection refused")
+	proxy.err = nil
+
+	// The registries are stale.
+	clock.Advance(nodeRegistryMaxAge + time.Second)
+	expectNotReady(readinessNodes, "registry is stale")
+	expectNotReady(readinessWithdrawalAddresses, "registry is stale")
+}
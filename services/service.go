@@ -2,19 +2,20 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
-	"fmt"
 	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	creds "github.com/Rocket-Rescue-Node/credentials"
 	"github.com/Rocket-Rescue-Node/credentials/pb"
-	"github.com/Rocket-Rescue-Node/rescue-api/external"
+	"github.com/Rocket-Rescue-Node/rescue-api/database"
 	"github.com/Rocket-Rescue-Node/rescue-api/models"
 	authz "github.com/Rocket-Rescue-Node/rescue-api/models/authorization"
 	"github.com/Rocket-Rescue-Node/rescue-api/util"
 	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
-	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/jonboulle/clockwork"
 	"go.uber.org/zap"
@@ -64,6 +65,46 @@ func (a *AuthorizationError) Is(err error) bool {
 	return ok
 }
 
+type NotFoundError struct {
+	msg string
+}
+
+func (n *NotFoundError) Error() string {
+	return n.msg
+}
+
+func (n *NotFoundError) Is(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// UnavailableError is returned when a dependency is temporarily unavailable.
+// The request can be retried after RetryAfter.
+type UnavailableError struct {
+	msg        string
+	retryAfter time.Duration
+}
+
+func (u *UnavailableError) Error() string {
+	return u.msg
+}
+
+func (u *UnavailableError) Is(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+func (u *UnavailableError) RetryAfter() time.Duration {
+	return u.retryAfter
+}
+
+// retryableError is implemented by the errors of dependencies that refuse calls for a while,
+// e.g. external.CircuitOpenError.
+type retryableError interface {
+	error
+	RetryAfter() time.Duration
+}
+
 // ServiceConfig contains the configuration for a Service.
 type ServiceConfig struct {
 	DB                   *sql.DB
@@ -73,9 +114,23 @@ type ServiceConfig struct {
 	Logger               *zap.Logger
 	Clock                clockwork.Clock
 	EnableSoloValidators bool
-
-	RescueProxyAddr       string
-	RescueProxySecureGRPC bool
+	// Whether request messages without a challenge nonce are accepted.
+	AllowLegacyRequests bool
+	// The chain that EIP-4361 and EIP-712 messages must be signed for.
+	ChainID uint64
+	// Sign-In with Ethereum messages are only accepted if set.
+	SIWE *SIWEConfig
+	// The credential quota policy. If nil, DefaultQuotaPolicy is used.
+	Quotas QuotaPolicy
+
+	// The verifiers that signatures are checked with, in order.
+	// If empty, only signatures made by the node's private key are accepted.
+	SignatureVerifiers []SignatureVerifier
+	// Validates signatures of undeployed smart contract wallets. If nil, EIP-6492 signatures
+	// are only accepted from wallets that were already deployed.
+	EIP6492Verifier EIP6492Verifier
+	// The connection to the rescue-proxy, checked for readiness. Not checked if nil.
+	RescueProxy ConnectionChecker
 }
 
 // Services contain business logic, are responsible for interacting with the database,
@@ -86,6 +141,20 @@ type Service struct {
 	cm                *creds.CredentialManager
 	credRequestRegexp *regexp.Regexp
 
+	// The key challenge nonces are authenticated with, the serial number of the last one,
+	// and the used nonces, until they expire
+	challengeKey        []byte
+	challengeSerial     atomic.Uint32
+	usedChallenges      map[string]time.Time
+	challengesLock      sync.Mutex
+	allowLegacyRequests atomic.Bool
+
+	// The chain that EIP-4361 and EIP-712 messages must be signed for
+	chainID uint64
+
+	// Expected values for Sign-In with Ethereum messages, or nil if they are not accepted
+	siwe *SIWEConfig
+
 	// Active nodes on the Rocket Pool network
 	nodes *models.NodeRegistry
 
@@ -96,134 +165,238 @@ type Service struct {
 	db                         *sql.DB
 	getCredEventsStmt          *sql.Stmt
 	getCredEventTimestampsStmt *sql.Stmt
+	getCredRevokedStmt         *sql.Stmt
 	addCredEventStmt           *sql.Stmt
-	isNodeAuthorizedStmt       *sql.Stmt
+	grantCreditsStmt           *sql.Stmt
+	getCreditsStmt             *sql.Stmt
+	addCreditsAuditEventStmt   *sql.Stmt
+	getCreditsAuditEventsStmt  *sql.Stmt
+	getNodeAuthzActionsStmt    *sql.Stmt
+	getAuthzRulesStmt          *sql.Stmt
+	setAuthzRuleStmt           *sql.Stmt
+	deleteAuthzRuleStmt        *sql.Stmt
+	addAuthzAuditEventStmt     *sql.Stmt
+	getAuthzAuditEventsStmt    *sql.Stmt
+	getQuotaOverrideStmt       *sql.Stmt
+	getQuotaOverridesStmt      *sql.Stmt
+	setQuotaOverrideStmt       *sql.Stmt
+	deleteQuotaOverrideStmt    *sql.Stmt
+	addQuotaAuditEventStmt     *sql.Stmt
+	getQuotaAuditEventsStmt    *sql.Stmt
 
 	m      *metrics.MetricsRegistry
 	logger *zap.Logger
 
 	clock clockwork.Clock
 
-	enableSoloValidators bool
+	enableSoloValidators atomic.Bool
+
+	quotas atomic.Pointer[QuotaPolicy]
 
-	rescueProxyClient *external.RescueProxyAPIClient
+	signatureVerifiers []SignatureVerifier
+	eip6492Verifier    EIP6492Verifier
+
+	rescueProxy ConnectionChecker
 }
 
 func NewService(config *ServiceConfig) *Service {
 	re := regexp.MustCompile(credentialRequestPattern)
-	return &Service{
-		cm:                   config.CM,
-		db:                   config.DB,
-		nodes:                config.Nodes,
-		withdrawalAddresses:  config.WithdrawalAddresses,
-		credRequestRegexp:    re,
-		logger:               config.Logger,
-		clock:                config.Clock,
-		enableSoloValidators: config.EnableSoloValidators,
-		rescueProxyClient: external.NewRescueProxyAPIClient(
-			config.Logger,
-			config.RescueProxyAddr,
-			config.RescueProxySecureGRPC,
-		),
+	quotas := config.Quotas
+	if quotas == nil {
+		quotas = DefaultQuotaPolicy()
+	}
+	s := &Service{
+		cm:                  config.CM,
+		db:                  config.DB,
+		nodes:               config.Nodes,
+		withdrawalAddresses: config.WithdrawalAddresses,
+		credRequestRegexp:   re,
+		usedChallenges:      make(map[string]time.Time),
+		chainID:             config.ChainID,
+		siwe:                config.SIWE,
+		logger:              config.Logger,
+		clock:               config.Clock,
+		signatureVerifiers:  config.SignatureVerifiers,
+		eip6492Verifier:     config.EIP6492Verifier,
+		rescueProxy:         config.RescueProxy,
 	}
+	if len(s.signatureVerifiers) == 0 {
+		s.signatureVerifiers = []SignatureVerifier{EOAVerifier{}}
+	}
+	s.SetEnableSoloValidators(config.EnableSoloValidators)
+	s.SetAllowLegacyRequests(config.AllowLegacyRequests)
+	s.SetQuotaPolicy(quotas)
+	return s
+}
+
+// SetEnableSoloValidators enables or disables credentials for solo validators.
+// It is safe to call while requests are being processed.
+func (s *Service) SetEnableSoloValidators(enable bool) {
+	s.enableSoloValidators.Store(enable)
 }
 
 func (s *Service) Init() error {
 	s.m = metrics.NewMetricsRegistry("service")
-	if err := s.createTables(); err != nil {
+	s.challengeKey = make([]byte, 32)
+	if _, err := rand.Read(s.challengeKey); err != nil {
+		return err
+	}
+	if err := database.Migrate(s.db); err != nil {
 		return err
 	}
 	return s.prepareStatements()
 }
 
-func (s *Service) migrateTables() error {
+func (s *Service) prepareStatements() error {
+	var err error
 
-	// If operator_type isn't on the table, create it
-	var c int
-	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info("credential_events") where name = "operator_type";`).Scan(&c)
-	if err != nil {
+	if s.getCredEventsStmt, err = s.db.Prepare(`
+		SELECT COALESCE(MAX(timestamp), 0), COUNT(*) FROM credential_events
+		WHERE node_id = ? AND timestamp > ? AND type = ? AND operator_type = ?;
+	`); err != nil {
 		return err
 	}
 
-	if c == 0 {
+	if s.getCredEventTimestampsStmt, err = s.db.Prepare(`
+		SELECT timestamp FROM credential_events WHERE node_id = ? AND timestamp > ? AND timestamp <= ? AND type = ? AND operator_type = ? ORDER BY timestamp DESC;
+	`); err != nil {
+		return err
+	}
 
-		// Update the primary key by copying the table, dropping the old version, and renaming it.
-		// Insert 0s for operator_type, as all events prior to the migration were RP NOs, who have operator_type 0.
-		_, err := s.db.Exec(`
-			CREATE TABLE _credential_events_copy (
-				node_id BLOB(20) NOT NULL,
-				timestamp INTEGER NOT NULL,
-				type INTEGER CHECK (type >= 0 AND type <= 1) NOT NULL,
-				operator_type INTEGER NOT NULL,
-				PRIMARY KEY (node_id, operator_type, timestamp)
-			);
+	if s.getCredRevokedStmt, err = s.db.Prepare(`
+		SELECT COUNT(*) FROM credential_events WHERE node_id = ? AND timestamp = ? AND type = ? AND operator_type = ?;
+	`); err != nil {
+		return err
+	}
 
-			INSERT INTO _credential_events_copy (node_id, timestamp, type, operator_type)
-				SELECT node_id, timestamp, type, 0 FROM credential_events;
-			DROP TABLE credential_events;
-			ALTER TABLE _credential_events_copy RENAME TO credential_events;
-		`)
+	if s.addCredEventStmt, err = s.db.Prepare(`
+		INSERT INTO credential_events (node_id, timestamp, type, operator_type, credits) VALUES (?, ?, ?, ?, ?);
+	`); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	if s.grantCreditsStmt, err = s.db.Prepare(`
+		INSERT INTO credential_events (node_id, timestamp, type, operator_type, credits) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (node_id, operator_type, type, timestamp) DO UPDATE SET
+			credits = credits + excluded.credits;
+	`); err != nil {
+		return err
 	}
 
-	return nil
-}
+	if s.getCreditsStmt, err = s.db.Prepare(`
+		SELECT COALESCE(SUM(credits), 0) FROM credential_events WHERE node_id = ? AND operator_type = ?;
+	`); err != nil {
+		return err
+	}
 
-func (s *Service) createTables() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS credential_events (
-			node_id BLOB(20) NOT NULL,
-			timestamp INTEGER NOT NULL,
-			type INTEGER CHECK (type >= 0 AND type <= 1) NOT NULL,
-			PRIMARY KEY (node_id, timestamp)
-		);
-		CREATE TABLE IF NOT EXISTS authorization_rules (
-			node_id BLOB(20) NOT NULL,
-			resource INTEGER CHECK (resource >= 0 AND resource <=1) NOT NULL,
-			action INTEGER CHECK (action >= 0 AND action <= 1) NOT NULL,
-			PRIMARY KEY (node_id, resource)
-		);
-	`)
-	if err != nil {
+	if s.addCreditsAuditEventStmt, err = s.db.Prepare(`
+		INSERT INTO emergency_credits_audit (timestamp, actor, node_id, operator_type, credits) VALUES (?, ?, ?, ?, ?);
+	`); err != nil {
 		return err
 	}
 
-	err = s.migrateTables()
-	if err != nil {
+	if s.getCreditsAuditEventsStmt, err = s.db.Prepare(`
+		SELECT timestamp, actor, node_id, operator_type, credits FROM emergency_credits_audit
+		ORDER BY id DESC LIMIT ?;
+	`); err != nil {
 		return err
 	}
-	return nil
-}
 
-func (s *Service) prepareStatements() error {
-	var err error
+	if s.getNodeAuthzActionsStmt, err = s.db.Prepare(`
+		SELECT action FROM authorization_rules
+		WHERE node_id = ? AND resource = ?
+		AND (not_before IS NULL OR not_before <= ?)
+		AND (expires_at IS NULL OR expires_at > ?);
+	`); err != nil {
+		return err
+	}
 
-	if s.getCredEventsStmt, err = s.db.Prepare(`
-		SELECT COALESCE(MAX(timestamp), 0), COUNT(*) FROM credential_events
-		WHERE node_id = ? AND timestamp > ? AND type = ? AND operator_type = ?;
+	if s.getAuthzRulesStmt, err = s.db.Prepare(`
+		SELECT node_id, resource, action, not_before, expires_at, reason, creator FROM authorization_rules
+		ORDER BY node_id, resource, action;
 	`); err != nil {
 		return err
 	}
 
-	if s.getCredEventTimestampsStmt, err = s.db.Prepare(`
-		SELECT timestamp FROM credential_events WHERE node_id = ? AND timestamp > ? AND timestamp <= ? AND type = ? AND operator_type = ? ORDER BY timestamp DESC;
+	if s.setAuthzRuleStmt, err = s.db.Prepare(`
+		INSERT INTO authorization_rules (node_id, resource, action, not_before, expires_at, reason, creator)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (node_id, resource, action) DO UPDATE SET
+			not_before = excluded.not_before,
+			expires_at = excluded.expires_at,
+			reason = excluded.reason,
+			creator = excluded.creator;
 	`); err != nil {
 		return err
 	}
 
-	if s.addCredEventStmt, err = s.db.Prepare(`
-		INSERT INTO credential_events (node_id, timestamp, type, operator_type) VALUES (?, ?, ?, ?);
+	if s.deleteAuthzRuleStmt, err = s.db.Prepare(`
+		DELETE FROM authorization_rules WHERE node_id = ? AND resource = ? AND action = ?
+		RETURNING not_before, expires_at, reason, creator;
+	`); err != nil {
+		return err
+	}
+
+	if s.addAuthzAuditEventStmt, err = s.db.Prepare(`
+		INSERT INTO authorization_rules_audit (timestamp, actor, operation, node_id, resource, action, not_before, expires_at, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`); err != nil {
+		return err
+	}
+
+	if s.getAuthzAuditEventsStmt, err = s.db.Prepare(`
+		SELECT timestamp, actor, operation, node_id, resource, action, not_before, expires_at, reason
+		FROM authorization_rules_audit
+		ORDER BY id DESC LIMIT ?;
+	`); err != nil {
+		return err
+	}
+
+	if s.getQuotaOverrideStmt, err = s.db.Prepare(`
+		SELECT count, window_secs, auth_validity_window_secs, reason, creator FROM quota_overrides
+		WHERE node_id = ? AND operator_type = ?;
+	`); err != nil {
+		return err
+	}
+
+	if s.getQuotaOverridesStmt, err = s.db.Prepare(`
+		SELECT node_id, operator_type, count, window_secs, auth_validity_window_secs, reason, creator FROM quota_overrides
+		ORDER BY node_id, operator_type;
 	`); err != nil {
 		return err
 	}
 
-	if s.isNodeAuthorizedStmt, err = s.db.Prepare(`
-		SELECT node_id FROM authorization_rules
-		WHERE node_id = ? AND resource = ? AND action = ?
-		LIMIT 1;
+	if s.setQuotaOverrideStmt, err = s.db.Prepare(`
+		INSERT INTO quota_overrides (node_id, operator_type, count, window_secs, auth_validity_window_secs, reason, creator)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (node_id, operator_type) DO UPDATE SET
+			count = excluded.count,
+			window_secs = excluded.window_secs,
+			auth_validity_window_secs = excluded.auth_validity_window_secs,
+			reason = excluded.reason,
+			creator = excluded.creator;
+	`); err != nil {
+		return err
+	}
+
+	if s.deleteQuotaOverrideStmt, err = s.db.Prepare(`
+		DELETE FROM quota_overrides WHERE node_id = ? AND operator_type = ?
+		RETURNING count, window_secs, auth_validity_window_secs, reason, creator;
+	`); err != nil {
+		return err
+	}
+
+	if s.addQuotaAuditEventStmt, err = s.db.Prepare(`
+		INSERT INTO quota_overrides_audit (timestamp, actor, operation, node_id, operator_type, count, window_secs, auth_validity_window_secs, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`); err != nil {
+		return err
+	}
+
+	if s.getQuotaAuditEventsStmt, err = s.db.Prepare(`
+		SELECT timestamp, actor, operation, node_id, operator_type, count, window_secs, auth_validity_window_secs, reason
+		FROM quota_overrides_audit
+		ORDER BY id DESC LIMIT ?;
 	`); err != nil {
 		return err
 	}
@@ -234,7 +407,7 @@ func (s *Service) prepareStatements() error {
 // isNodeRegistered checks if a Node is registered on the Rocket Pool network.
 func (s *Service) isNodeRegistered(nodeID *models.NodeID) bool {
 	// If the node registry is stale, all nodes are considered unregistered.
-	if s.clock.Now().After(s.nodes.LastUpdated.Add(nodeRegistryMaxAge)) {
+	if s.clock.Now().After(s.nodes.LastUpdated().Add(nodeRegistryMaxAge)) {
 		s.logger.Error("Node registry is too old, refusing access to node",
 			zap.String("nodeID", nodeID.Hex()))
 		s.m.Counter("old_node_registry").Inc()
@@ -246,7 +419,7 @@ func (s *Service) isNodeRegistered(nodeID *models.NodeID) bool {
 // isWithdrawalAddress checks if an address is the withdrawal credential for at least one active validator.
 func (s *Service) isWithdrawalAddress(nodeID *models.NodeID) bool {
 	// If the registry is stale, all nodes are considered invalid.
-	if s.clock.Now().After(s.withdrawalAddresses.LastUpdated.Add(nodeRegistryMaxAge)) {
+	if s.clock.Now().After(s.withdrawalAddresses.LastUpdated().Add(nodeRegistryMaxAge)) {
 		s.logger.Error("Withdrawal Address registry is too old, refusing access to user",
 			zap.String("withdrawal_address", nodeID.Hex()))
 		s.m.Counter("old_withdrawal_address_registry").Inc()
@@ -255,34 +428,50 @@ func (s *Service) isWithdrawalAddress(nodeID *models.NodeID) bool {
 	return s.withdrawalAddresses.Has(*nodeID)
 }
 
-// isNodeAuthorized checks if a Node is authorized to access a Resource.
-func (s *Service) isNodeAuthorized(nodeID *models.NodeID, svc authz.Resource) bool {
-	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
+// getNodeAuthorization returns the action of the authorization rules that apply to a Node
+// trying to access a Resource. Rules outside of their validity period are ignored.
+// If any rule denies access, Deny is returned, even if other rules allow it.
+// The second return value is false if no rule applies to the Node.
+// Errors are treated as Deny, so that database failures do not grant access.
+func (s *Service) getNodeAuthorization(ctx context.Context, nodeID *models.NodeID, svc authz.Resource) (authz.Action, bool) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		s.logger.Error("Failed to begin database transaction", zap.Error(err))
-		return false
+		return authz.Deny, true
 	}
 	defer rollback(tx)
-	stmt := tx.Stmt(s.isNodeAuthorizedStmt)
+	stmt := tx.StmtContext(ctx, s.getNodeAuthzActionsStmt)
 	defer stmt.Close()
-	rows, err := stmt.Query(nodeID.Bytes(), svc, authz.Deny)
+	now := s.clock.Now().Unix()
+	rows, err := stmt.QueryContext(ctx, nodeID.Bytes(), svc, now, now)
 	if err != nil {
 		s.logger.Error("Failed to query database", zap.Error(err))
-		return false
+		return authz.Deny, true
 	}
 	defer rows.Close()
-	return !rows.Next()
+
+	found := false
+	for rows.Next() {
+		var action authz.Action
+		if err := rows.Scan(&action); err != nil {
+			s.logger.Error("Failed to scan row", zap.Error(err))
+			return authz.Deny, true
+		}
+		if action == authz.Deny {
+			return authz.Deny, true
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("Failed to query database", zap.Error(err))
+		return authz.Deny, true
+	}
+	return authz.Allow, found
 }
 
-func (s *Service) checkRequestAge(msg *[]byte) error {
+func (s *Service) checkRequestAge(rm *requestMessage) error {
 	// Check if the request is fresh
-	tsSecs, err := s.getTimestampFromRequest(string(*msg))
-	if err != nil {
-		s.m.Counter("invalid_timestamp").Inc()
-		return &ValidationError{"invalid timestamp"}
-	}
-	ts := time.Unix(tsSecs, 0)
-	if time.Since(ts) > credsRequestMaxAge {
+	if time.Since(rm.issuedAt) > credsRequestMaxAge {
 		s.m.Counter("timestamp_too_old").Inc()
 		return &AuthenticationError{"timestamp is too old"}
 	}
@@ -290,20 +479,37 @@ func (s *Service) checkRequestAge(msg *[]byte) error {
 	return nil
 }
 
-func (s *Service) getNodeID(msg *[]byte, sig *[]byte) (*common.Address, error) {
-	// Recover the nodeID
-	nodeID, err := util.RecoverAddressFromSignature(*msg, *sig)
-	if err != nil {
-		msg := "failed to recover nodeID from signature"
-		s.logger.Warn(msg, zap.Error(err))
-		s.m.Counter("failed_auth").Inc()
-		return nil, &AuthenticationError{msg}
+// checkNodeAuthorization checks whether a node may use the credential service as the given operator type.
+// Authorization rules take precedence over registry membership:
+//   - A Deny rule always refuses access, and wins over any Allow rule.
+//   - An Allow rule grants access even if the node is missing from the registry for its
+//     operator type, e.g. when it registered after the last registry update.
+//   - Without a rule, the node must be present in the registry for its operator type.
+//
+// Solo traffic shedding applies regardless of Allow rules.
+func (s *Service) checkNodeAuthorization(ctx context.Context, nodeID *models.NodeID, ot creds.OperatorType) error {
+	// Make sure that the node is not banned from using the service.
+	action, hasRule := s.getNodeAuthorization(ctx, nodeID, authz.CredentialService)
+	if err := ctx.Err(); err != nil {
+		// The rules couldn't be read because the request was cancelled, not because of a ban.
+		return err
+	}
+	if hasRule && action == authz.Deny {
+		s.m.Counter("user_banned").Inc()
+		return &AuthorizationError{"node is not authorized"}
+	}
+
+	if ot == pb.OperatorType_OT_SOLO && !s.enableSoloValidators.Load() {
+		s.m.Counter("solo_traffic_shedding").Inc()
+		return &AuthorizationError{"solo validators are currently not permitted"}
+	}
+
+	// Explicitly allowed nodes skip the registry checks.
+	if hasRule && action == authz.Allow {
+		s.m.Counter("user_allowed").Inc()
+		return nil
 	}
-	s.logger.Info("Recovered nodeID from signature", zap.String("nodeID", nodeID.Hex()))
-	return nodeID, nil
-}
 
-func (s *Service) checkNodeAuthorization(nodeID *models.NodeID, ot creds.OperatorType) error {
 	// Check if this node is part of Rocket Pool, or a valid 0x01 credential
 	switch ot {
 	case pb.OperatorType_OT_ROCKETPOOL:
@@ -312,52 +518,66 @@ func (s *Service) checkNodeAuthorization(nodeID *models.NodeID, ot creds.Operato
 			return &AuthorizationError{"node is not registered"}
 		}
 	case pb.OperatorType_OT_SOLO:
-		if !s.enableSoloValidators {
-			s.m.Counter("solo_traffic_shedding").Inc()
-			return &AuthorizationError{"solo validators are currently not permitted"}
-		}
 		if !s.isWithdrawalAddress(nodeID) {
 			s.m.Counter("solo_not_withdrawal_address").Inc()
 			return &AuthorizationError{"wallet is not a withdrawal address for any validator"}
 		}
 	}
 
-	// Make sure that the node is not banned from using the service.
-	if !s.isNodeAuthorized(nodeID, authz.CredentialService) {
-		s.m.Counter("user_banned").Inc()
-		return &AuthorizationError{"node is not authorized"}
-	}
-
 	return nil
 }
 
-func (s *Service) validateSignedRequest(msg []byte, sig []byte, expectedNodeId common.Address, ot pb.OperatorType) (common.Address, error) {
-	// Check request age
-	if err := s.checkRequestAge(&msg); err != nil {
+func (s *Service) validateSignedRequest(ctx context.Context, msg []byte, sig []byte, expectedNodeId common.Address, ot pb.OperatorType, op requestOperation) (common.Address, error) {
+	rm, err := s.parseRequestMessage(string(msg))
+	if err != nil {
+		s.m.Counter("invalid_request_message").Inc()
 		return common.Address{}, err
 	}
 
-	// First, assume EOA signature
-	recoveredNodeId, err := s.getNodeID(&msg, &sig)
-	if err != nil {
+	// Check request age
+	if err := s.checkRequestAge(rm); err != nil {
 		return common.Address{}, err
 	}
 
-	// Check if the nodeID matches the expected nodeID
-	if *recoveredNodeId != expectedNodeId {
-		// if not, we probably have an EIP-1271 signature
-		dataHash := common.BytesToHash(accounts.TextHash(msg))
-		valid, err := s.rescueProxyClient.ValidateEIP1271(&dataHash, &sig, &expectedNodeId)
-		if err != nil {
-			return common.Address{}, &AuthenticationError{fmt.Sprintf("failed to validate EIP-1271 signature: %v", err)}
+	// Sign-In with Ethereum messages must be bound to our site and chain.
+	if rm.siwe != nil {
+		if err := s.checkSIWEMessage(rm.siwe, expectedNodeId); err != nil {
+			return common.Address{}, err
+		}
+	}
+
+	// EIP-712 requests must be bound to the operation.
+	if rm.typed != nil {
+		if err := s.checkTypedRequest(rm.typed, op, ot); err != nil {
+			return common.Address{}, err
 		}
-		if !valid {
-			return common.Address{}, &AuthenticationError{"invalid signature: both EOA and EIP-1271 validation failed"}
+	}
+
+	// Revocations can't be undone, so they must not be replayable: they must carry a challenge nonce,
+	// or be EIP-712 requests, which are bound to the operation.
+	if op == opRevokeCredential && rm.nonce == "" && rm.typed == nil {
+		s.m.Counter("legacy_revoke_rejected").Inc()
+		return common.Address{}, &ValidationError{"revocation requests must include a challenge nonce"}
+	}
+
+	// Check the signature with the configured verifiers, e.g. EOA first, then EIP-1271.
+	// Smart contract wallets that aren't deployed yet wrap their signature with deployment data.
+	if util.IsEIP6492Signature(sig) {
+		if err := s.validateEIP6492Signature(ctx, rm.hash, sig, expectedNodeId); err != nil {
+			return common.Address{}, err
 		}
+	} else if err := s.verifySignature(ctx, common.BytesToHash(rm.hash), sig, expectedNodeId); err != nil {
+		return common.Address{}, err
+	}
+
+	// Only consume the nonce once the signature is known to be valid,
+	// so that nonces can't be burnt by anyone who sees them.
+	if err := s.checkRequestNonce(rm); err != nil {
+		return common.Address{}, err
 	}
 
-	// If getNodeID succeeds, check authorization
-	if err := s.checkNodeAuthorization(&expectedNodeId, ot); err != nil {
+	// If the signature is valid, check authorization
+	if err := s.checkNodeAuthorization(ctx, &expectedNodeId, ot); err != nil {
 		// If authorization check passes, we're done
 		return common.Address{}, err
 	}
@@ -369,8 +589,25 @@ func (s *Service) Deinit() {
 	// Close prepared statements
 	for _, stmt := range []**sql.Stmt{
 		&s.getCredEventsStmt,
+		&s.getCredEventTimestampsStmt,
+		&s.getCredRevokedStmt,
 		&s.addCredEventStmt,
-		&s.isNodeAuthorizedStmt,
+		&s.grantCreditsStmt,
+		&s.getCreditsStmt,
+		&s.addCreditsAuditEventStmt,
+		&s.getCreditsAuditEventsStmt,
+		&s.getNodeAuthzActionsStmt,
+		&s.getAuthzRulesStmt,
+		&s.setAuthzRuleStmt,
+		&s.deleteAuthzRuleStmt,
+		&s.addAuthzAuditEventStmt,
+		&s.getAuthzAuditEventsStmt,
+		&s.getQuotaOverrideStmt,
+		&s.getQuotaOverridesStmt,
+		&s.setQuotaOverrideStmt,
+		&s.deleteQuotaOverrideStmt,
+		&s.addQuotaAuditEventStmt,
+		&s.getQuotaAuditEventsStmt,
 	} {
 		if *stmt == nil {
 			continue
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// Names of the built-in signature verifiers, as used in the -signature-verifiers flag and in metrics.
+const (
+	EOAVerifierName          = "eoa"
+	ProxyEIP1271VerifierName = "proxy_eip1271"
+	RPCEIP1271VerifierName   = "rpc_eip1271"
+)
+
+// SignatureVerifier checks whether a signature of a digest was made by, or on behalf of, an address.
+type SignatureVerifier interface {
+	// Name identifies the verifier in logs and metrics.
+	Name() string
+	// VerifySignature returns false if the signature is not valid for the address,
+	// and an error if the verifier could not tell.
+	VerifySignature(ctx context.Context, hash common.Hash, sig []byte, address common.Address) (bool, error)
+}
+
+// EOAVerifier accepts signatures made by the private key of the address.
+type EOAVerifier struct{}
+
+func (EOAVerifier) Name() string {
+	return EOAVerifierName
+}
+
+func (EOAVerifier) VerifySignature(ctx context.Context, hash common.Hash, sig []byte, address common.Address) (bool, error) {
+	// Malformed signatures may still be valid EIP-1271 signatures, so they are not an error.
+	recovered, err := util.RecoverAddressFromHash(hash.Bytes(), sig)
+	if err != nil {
+		return false, nil
+	}
+	return *recovered == address, nil
+}
+
+// EIP1271Validator calls isValidSignature on a smart contract wallet.
+// It is implemented by external.RescueProxyAPIClient and external.ExecutionClient.
+type EIP1271Validator interface {
+	ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error)
+}
+
+// EIP1271Verifier accepts signatures that the smart contract wallet at the address considers valid.
+type EIP1271Verifier struct {
+	name      string
+	validator EIP1271Validator
+	// Bounds each validation, including those that outlive the request that started them,
+	// which happens when a CachingVerifier shares them between concurrent requests.
+	timeout time.Duration
+}
+
+func NewEIP1271Verifier(name string, validator EIP1271Validator, timeout time.Duration) *EIP1271Verifier {
+	return &EIP1271Verifier{
+		name:      name,
+		validator: validator,
+		timeout:   timeout,
+	}
+}
+
+func (v *EIP1271Verifier) Name() string {
+	return v.name
+}
+
+func (v *EIP1271Verifier) VerifySignature(ctx context.Context, hash common.Hash, sig []byte, address common.Address) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+	return v.validator.ValidateEIP1271(ctx, &hash, &sig, &address)
+}
+
+// verifySignature tries the configured verifiers in order, and accepts the signature as soon as
+// one of them does. Verifiers that fail are skipped, and only reported if no other one accepts it.
+func (s *Service) verifySignature(ctx context.Context, hash common.Hash, sig []byte, address common.Address) error {
+	var errs []error
+	for _, v := range s.signatureVerifiers {
+		valid, err := v.VerifySignature(ctx, hash, sig, address)
+		if err != nil {
+			s.logger.Warn("Signature verifier failed", zap.String("verifier", v.Name()), zap.Error(err))
+			s.m.Counter("signature_verifier_error_" + v.Name()).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", v.Name(), err))
+			continue
+		}
+		if valid {
+			s.logger.Info("Verified signature", zap.String("verifier", v.Name()), zap.String("nodeID", address.Hex()))
+			s.m.Counter("signature_verified_" + v.Name()).Inc()
+			return nil
+		}
+	}
+
+	// Don't refuse the signature if it couldn't be checked for now.
+	var retryable retryableError
+	if errors.As(errors.Join(errs...), &retryable) {
+		s.m.Counter("signature_verifier_unavailable").Inc()
+		return &UnavailableError{"signature verification is temporarily unavailable, please retry later", retryable.RetryAfter()}
+	}
+
+	s.m.Counter("failed_auth").Inc()
+	if len(errs) > 0 {
+		return &AuthenticationError{fmt.Sprintf("failed to validate signature: %v", errors.Join(errs...))}
+	}
+	return &AuthenticationError{"invalid signature"}
+}
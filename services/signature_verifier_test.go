@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/Rocket-Rescue-Node/rescue-api/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jonboulle/clockwork"
+)
+
+// Simulates smart contract wallets owned by EOAs.
+type testEIP1271Validator struct {
+	owners map[common.Address]common.Address
+	err    error
+	calls  int
+}
+
+// An error of a dependency that refuses calls for a while.
+type testRetryableError struct{}
+
+func (testRetryableError) Error() string {
+	return "circuit breaker is open"
+}
+
+func (testRetryableError) RetryAfter() time.Duration {
+	return 10 * time.Second
+}
+
+func (v *testEIP1271Validator) ValidateEIP1271(ctx context.Context, dataHash *common.Hash, signature *[]byte, address *common.Address) (bool, error) {
+	v.calls++
+	if v.err != nil {
+		return false, v.err
+	}
+	owner, ok := v.owners[*address]
+	if !ok {
+		return false, nil
+	}
+	signer, err := util.RecoverAddressFromHash(dataHash.Bytes(), *signature)
+	if err != nil {
+		return false, nil
+	}
+	return *signer == owner, nil
+}
+
+func TestEOAVerifier(t *testing.T) {
+	wallet, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	hash := common.BytesToHash(crypto.Keccak256([]byte("digest")))
+	sig, err := wallet.SignHash(hash.Bytes())
+	if err != nil {
+		t.Fatalf("Could not sign hash: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		hash    common.Hash
+		sig     []byte
+		address common.Address
+		valid   bool
+	}{
+		{"Valid signature", hash, sig, *wallet.Address, true},
+		{"Other address", hash, sig, common.HexToAddress("0x01"), false},
+		{"Other hash", common.HexToHash("0x01"), sig, *wallet.Address, false},
+		{"Malformed signature", hash, sig[:63], *wallet.Address, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := EOAVerifier{}.VerifySignature(context.Background(), tt.hash, tt.sig, tt.address)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if valid != tt.valid {
+				t.Fatalf("Expected valid=%v, got %v", tt.valid, valid)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifiers(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	// The node address is that of a smart contract wallet, owned by another key.
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+	owner, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	failing := &testEIP1271Validator{err: errors.New("connection refused")}
+	working := &testEIP1271Validator{owners: map[common.Address]common.Address{*node.Address: *owner.Address}}
+	svc.signatureVerifiers = []SignatureVerifier{
+		EOAVerifier{},
+		NewEIP1271Verifier(ProxyEIP1271VerifierName, failing, time.Second),
+		NewEIP1271Verifier(RPCEIP1271VerifierName, working, time.Second),
+	}
+
+	msg := []byte(fmt.Sprintf("Rescue Node %d", clock.Now().Unix()))
+	request := func(signer *util.Wallet) error {
+		sig, err := signer.Sign(msg)
+		if err != nil {
+			t.Fatalf("Could not sign message: %v", err)
+		}
+		_, err = svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+		return err
+	}
+
+	// EOA signatures are accepted without calling the next verifiers.
+	if err := request(node); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if failing.calls != 0 || working.calls != 0 {
+		t.Fatalf("EIP-1271 verifiers should not be called for EOA signatures")
+	}
+
+	// Verifiers that fail are skipped.
+	if err := request(owner); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+	if failing.calls != 1 || working.calls != 1 {
+		t.Fatalf("Expected both EIP-1271 verifiers to be called")
+	}
+
+	// Failures are reported if no verifier accepts the signature.
+	other, err := util.NewWallet()
+	if err != nil {
+		t.Fatalf("Could not create wallet: %v", err)
+	}
+	err = request(other)
+	if !errors.Is(err, &AuthenticationError{}) || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected verifier failure to be reported, got %v", err)
+	}
+
+	// Signatures aren't refused while a verifier is temporarily unavailable.
+	failing.err = fmt.Errorf("validation failed: %w", testRetryableError{})
+	err = request(other)
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter() != 10*time.Second {
+		t.Fatalf("Expected unavailable error, got %v", err)
+	}
+	if err := request(owner); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// Without EIP-1271 verifiers, only EOA signatures are accepted.
+	svc.signatureVerifiers = []SignatureVerifier{EOAVerifier{}}
+	if err := request(owner); err == nil || err.Error() != "invalid signature" {
+		t.Fatalf("Expected invalid signature, got %v", err)
+	}
+
+	// Without an EIP6492Verifier, the wrapped signature of a deployed wallet is checked by the verifiers.
+	svc.signatureVerifiers = []SignatureVerifier{EOAVerifier{}, NewEIP1271Verifier(RPCEIP1271VerifierName, working, time.Second)}
+	inner, err := owner.Sign(msg)
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	sig, err := (&util.EIP6492Signature{Factory: common.HexToAddress("0x01"), Signature: inner}).Bytes()
+	if err != nil {
+		t.Fatalf("Could not wrap signature: %v", err)
+	}
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), msg, sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+const (
+	siwePreambleSuffix = " wants you to sign in with your Ethereum account:"
+	siweVersion        = "1"
+)
+
+var siweNoncePattern = regexp.MustCompile(`^[a-zA-Z0-9]{8,}$`)
+
+// SIWEConfig contains the values that Sign-In with Ethereum (EIP-4361) messages must match,
+// in addition to the service's chain ID.
+type SIWEConfig struct {
+	// The domain requesting the signature, e.g. "rescuenode.com".
+	Domain string
+	// The scheme and host of the URI the message refers to, e.g. "https://rescuenode.com".
+	// Paths are not compared, so that any page of the site can request credentials.
+	URI string
+}
+
+// An EIP-4361 message. Only the fields that are checked are kept.
+type siweMessage struct {
+	domain         string
+	address        string
+	uri            string
+	version        string
+	chainID        uint64
+	nonce          string
+	issuedAt       time.Time
+	expirationTime time.Time
+	notBefore      time.Time
+}
+
+// parseSIWEMessage parses a message in the format defined by EIP-4361:
+//
+//	rescuenode.com wants you to sign in with your Ethereum account:
+//	0x...
+//
+//	Optional statement
+//
+//	URI: https://rescuenode.com
+//	Version: 1
+//	Chain ID: 1
+//	Nonce: 0123456789abcdef0123456789abcdef
+//	Issued At: 2024-01-01T00:00:00Z
+//	Expiration Time: 2024-01-01T00:05:00Z
+func parseSIWEMessage(msg string) (*siweMessage, error) {
+	lines := strings.Split(msg, "\n")
+	if len(lines) < 4 {
+		return nil, fmt.Errorf("message is too short")
+	}
+
+	m := new(siweMessage)
+	domain, ok := strings.CutSuffix(lines[0], siwePreambleSuffix)
+	if !ok || domain == "" {
+		return nil, fmt.Errorf("invalid preamble")
+	}
+	// The domain may be prefixed with a scheme.
+	if _, host, ok := strings.Cut(domain, "://"); ok {
+		domain = host
+	}
+	m.domain = domain
+	m.address = lines[1]
+	if lines[2] != "" {
+		return nil, fmt.Errorf("missing empty line after address")
+	}
+
+	// Skip the optional statement, which is followed by an empty line.
+	rest := lines[3:]
+	switch {
+	case len(rest) > 0 && strings.HasPrefix(rest[0], "URI: "):
+	case len(rest) > 1 && rest[0] == "" && strings.HasPrefix(rest[1], "URI: "):
+		rest = rest[1:]
+	case len(rest) > 2 && rest[0] != "" && rest[1] == "" && strings.HasPrefix(rest[2], "URI: "):
+		rest = rest[2:]
+	default:
+		return nil, fmt.Errorf("invalid statement")
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range rest {
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			// Resources are the last field, and are not used.
+			if line == "Resources:" {
+				break
+			}
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field %q", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "URI":
+			m.uri = value
+		case "Version":
+			m.version = value
+		case "Chain ID":
+			m.chainID, err = strconv.ParseUint(value, 10, 64)
+		case "Nonce":
+			m.nonce = value
+		case "Issued At":
+			m.issuedAt, err = time.Parse(time.RFC3339, value)
+		case "Expiration Time":
+			m.expirationTime, err = time.Parse(time.RFC3339, value)
+		case "Not Before":
+			m.notBefore, err = time.Parse(time.RFC3339, value)
+		case "Request ID":
+		default:
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	for _, name := range []string{"URI", "Version", "Chain ID", "Nonce", "Issued At"} {
+		if !seen[name] {
+			return nil, fmt.Errorf("missing field %q", name)
+		}
+	}
+	if !siweNoncePattern.MatchString(m.nonce) {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	return m, nil
+}
+
+// checkSIWEMessage checks the fields of an EIP-4361 message against the configured expectations.
+// The issue time is checked by checkRequestAge, and the nonce by checkRequestNonce.
+func (s *Service) checkSIWEMessage(m *siweMessage, expectedNodeId common.Address) error {
+	fail := func(msg string) error {
+		s.logger.Warn("Rejected Sign-In with Ethereum message", zap.String("reason", msg))
+		s.m.Counter("siwe_message_rejected").Inc()
+		return &AuthenticationError{msg}
+	}
+
+	if m.domain != s.siwe.Domain {
+		return fail("message domain does not match")
+	}
+	uri, err := url.Parse(m.uri)
+	if err != nil {
+		return fail("invalid message URI")
+	}
+	if uri.Scheme+"://"+uri.Host != s.siwe.URI {
+		return fail("message URI does not match")
+	}
+	if m.version != siweVersion {
+		return fail("unsupported message version")
+	}
+	if m.chainID != s.chainID {
+		return fail("message chain ID does not match")
+	}
+	// EIP-4361 requires the address to be EIP-55 checksummed.
+	if m.address != expectedNodeId.Hex() {
+		return fail("message address does not match")
+	}
+
+	now := s.clock.Now()
+	if !m.expirationTime.IsZero() && !m.expirationTime.After(now) {
+		return fail("message has expired")
+	}
+	if !m.notBefore.IsZero() && m.notBefore.After(now) {
+		return fail("message is not valid yet")
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+)
+
+// Builds an EIP-4361 message, with extra fields appended after the required ones.
+func siweTestMessage(domain string, address common.Address, chainID uint64, nonce string, issuedAt time.Time, extra ...string) string {
+	lines := []string{
+		domain + siwePreambleSuffix,
+		address.Hex(),
+		"",
+		"Request Rescue Node credentials",
+		"",
+		"URI: https://rescuenode.com/credentials",
+		"Version: 1",
+		fmt.Sprintf("Chain ID: %d", chainID),
+		"Nonce: " + nonce,
+		"Issued At: " + issuedAt.UTC().Format(time.RFC3339),
+	}
+	return strings.Join(append(lines, extra...), "\n")
+}
+
+func TestParseSIWEMessage(t *testing.T) {
+	address := common.HexToAddress("0xC0ffee254729296a45a3885639AC7E10F9d54979")
+	issuedAt := time.Unix(1700000000, 0)
+	msg := siweTestMessage("https://rescuenode.com", address, 1, "0123456789abcdef", issuedAt,
+		"Expiration Time: 2023-11-14T22:18:20Z",
+		"Request ID: 42",
+		"Resources:",
+		"- https://rescuenode.com/terms",
+	)
+
+	m, err := parseSIWEMessage(msg)
+	if err != nil {
+		t.Fatalf("Could not parse message: %v", err)
+	}
+	if m.domain != "rescuenode.com" || m.address != address.Hex() || m.uri != "https://rescuenode.com/credentials" ||
+		m.version != "1" || m.chainID != 1 || m.nonce != "0123456789abcdef" || !m.issuedAt.Equal(issuedAt) ||
+		m.expirationTime.Unix() != 1700000300 || !m.notBefore.IsZero() {
+		t.Fatalf("Unexpected message %+v", m)
+	}
+
+	// The statement is optional.
+	noStatement := strings.Replace(msg, "Request Rescue Node credentials\n\n", "\n", 1)
+	if _, err := parseSIWEMessage(noStatement); err != nil {
+		t.Fatalf("Could not parse message without statement: %v", err)
+	}
+
+	valid := siweTestMessage("rescuenode.com", address, 1, "0123456789abcdef", issuedAt)
+	invalid := map[string]string{
+		"preamble":        strings.Replace(valid, " wants you", " would like you", 1),
+		"missing_nonce":   strings.Replace(valid, "Nonce: 0123456789abcdef\n", "", 1),
+		"short_nonce":     strings.Replace(valid, "0123456789abcdef", "0123", 1),
+		"chain_id":        strings.Replace(valid, "Chain ID: 1", "Chain ID: mainnet", 1),
+		"issued_at":       strings.Replace(valid, "Issued At: ", "Issued At: yesterday ", 1),
+		"duplicate_field": valid + "\nVersion: 2",
+		"unknown_field":   valid + "\nFoo: bar",
+		"statement":       strings.Replace(valid, "credentials\n\n", "credentials\nmore\n\n", 1),
+	}
+	for name, msg := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseSIWEMessage(msg); err == nil {
+				t.Fatalf("Expected invalid message to be rejected")
+			}
+		})
+	}
+}
+
+func TestSIWERequests(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	svc.siwe = &SIWEConfig{Domain: "rescuenode.com", URI: "https://rescuenode.com"}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	request := func(msg string) error {
+		sig, err := node.Sign([]byte(msg))
+		if err != nil {
+			t.Fatalf("Could not sign message: %v", err)
+		}
+		_, err = svc.CreateCredentialWithRetry(context.Background(), []byte(msg), sig, *node.Address, pb.OperatorType_OT_ROCKETPOOL)
+		return err
+	}
+	challenge := func() string {
+		nonce, _, err := svc.CreateChallenge()
+		if err != nil {
+			t.Fatalf("Could not create challenge: %v", err)
+		}
+		return nonce
+	}
+
+	now := clock.Now()
+	if err := request(siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now)); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	rejected := map[string]string{
+		"domain":     siweTestMessage("evil.com", *node.Address, 1, challenge(), now),
+		"chain_id":   siweTestMessage("rescuenode.com", *node.Address, 5, challenge(), now),
+		"address":    siweTestMessage("rescuenode.com", common.HexToAddress("0x01"), 1, challenge(), now),
+		"uri":        strings.Replace(siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now), "https://rescuenode.com", "https://evil.com", 1),
+		"old":        siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now.Add(-time.Hour)),
+		"expired":    siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now, "Expiration Time: "+now.Add(-time.Minute).UTC().Format(time.RFC3339)),
+		"not_before": siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now, "Not Before: "+now.Add(time.Hour).UTC().Format(time.RFC3339)),
+		"nonce":      siweTestMessage("rescuenode.com", *node.Address, 1, "0123456789abcdef", now),
+	}
+	for name, msg := range rejected {
+		t.Run(name, func(t *testing.T) {
+			if err := request(msg); !errors.Is(err, &AuthenticationError{}) {
+				t.Fatalf("Expected message to be rejected, got %v", err)
+			}
+		})
+	}
+
+	// SIWE messages are not accepted unless configured.
+	svc.siwe = nil
+	if err := request(siweTestMessage("rescuenode.com", *node.Address, 1, challenge(), now)); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected message to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Rocket-Rescue-Node/credentials"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"go.uber.org/zap"
+)
+
+// The operation a signed request is for. EIP-712 requests are bound to an operation,
+// so that a signature for one endpoint can't be used for another.
+type requestOperation string
+
+const (
+	opCreateCredential requestOperation = "CreateCredential"
+	opRevokeCredential requestOperation = "RevokeCredential"
+	opGetOperatorInfo  requestOperation = "GetOperatorInfo"
+)
+
+const (
+	typedRequestDomainName    = "Rescue Node"
+	typedRequestDomainVersion = "1"
+	typedRequestPrimaryType   = "RescueNodeRequest"
+)
+
+// The EIP-712 types of a request. Requests must use exactly these types.
+var typedRequestTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+	},
+	typedRequestPrimaryType: {
+		{Name: "operation", Type: "string"},
+		{Name: "timestamp", Type: "uint256"},
+		{Name: "operatorType", Type: "string"},
+		{Name: "nonce", Type: "string"},
+	},
+}
+
+// The message of an EIP-712 request.
+type typedRequest struct {
+	operation    requestOperation
+	timestamp    int64
+	operatorType credentials.OperatorType
+	// The challenge nonce, or empty for legacy requests.
+	nonce string
+}
+
+// parseTypedRequest parses an EIP-712 request, given as the JSON object passed to eth_signTypedData_v4:
+//
+//	{
+//	  "types": {"EIP712Domain": [...], "RescueNodeRequest": [...]},
+//	  "primaryType": "RescueNodeRequest",
+//	  "domain": {"name": "Rescue Node", "version": "1", "chainId": 1},
+//	  "message": {"operation": "CreateCredential", "timestamp": 1700000000, "operatorType": "rocketpool", "nonce": "0123456789abcdef0123456789abcdef"}
+//	}
+//
+// Returns the request and its EIP-712 digest.
+func parseTypedRequest(msg []byte, chainID uint64) (*typedRequest, []byte, error) {
+	var td apitypes.TypedData
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&td); err != nil {
+		return nil, nil, err
+	}
+
+	if td.PrimaryType != typedRequestPrimaryType || !reflect.DeepEqual(td.Types, typedRequestTypes) {
+		return nil, nil, fmt.Errorf("unexpected types")
+	}
+	if td.Domain.Name != typedRequestDomainName || td.Domain.Version != typedRequestDomainVersion ||
+		td.Domain.VerifyingContract != "" || td.Domain.Salt != "" {
+		return nil, nil, fmt.Errorf("unexpected domain")
+	}
+	if td.Domain.ChainId == nil || (*big.Int)(td.Domain.ChainId).Cmp(new(big.Int).SetUint64(chainID)) != 0 {
+		return nil, nil, fmt.Errorf("unexpected chain ID")
+	}
+	if len(td.Message) != len(typedRequestTypes[typedRequestPrimaryType]) {
+		return nil, nil, fmt.Errorf("unexpected message fields")
+	}
+
+	req := new(typedRequest)
+	operation, ok := td.Message["operation"].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid operation")
+	}
+	req.operation = requestOperation(operation)
+	operatorType, ok := td.Message["operatorType"].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid operator type")
+	}
+	ot, err := ParseOperatorType(operatorType)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.operatorType = ot
+	nonce, ok := td.Message["nonce"].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid nonce")
+	}
+	if nonce != "" {
+		if b, err := hex.DecodeString(nonce); err != nil || len(b) != challengeNonceBytes {
+			return nil, nil, fmt.Errorf("invalid nonce")
+		}
+	}
+	req.nonce = strings.ToLower(nonce)
+	// JSON numbers are decoded as float64, but wallets may also encode uint256 values as strings.
+	switch ts := td.Message["timestamp"].(type) {
+	case float64:
+		req.timestamp = int64(ts)
+		if float64(req.timestamp) != ts {
+			err = fmt.Errorf("invalid timestamp")
+		}
+	case string:
+		req.timestamp, err = strconv.ParseInt(ts, 10, 64)
+	default:
+		err = fmt.Errorf("invalid timestamp")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, hash, nil
+}
+
+// checkTypedRequest checks that an EIP-712 request was signed for the operation being performed.
+func (s *Service) checkTypedRequest(req *typedRequest, op requestOperation, ot credentials.OperatorType) error {
+	fail := func(msg string) error {
+		s.logger.Warn("Rejected EIP-712 request", zap.String("reason", msg))
+		s.m.Counter("typed_request_rejected").Inc()
+		return &AuthenticationError{msg}
+	}
+
+	if req.operation != op {
+		return fail("request was signed for a different operation")
+	}
+	if req.operatorType != ot {
+		return fail("request was signed for a different operator type")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/credentials/pb"
+	"github.com/jonboulle/clockwork"
+)
+
+const typedRequestTestTypes = `{
+	"EIP712Domain": [
+		{"name": "name", "type": "string"},
+		{"name": "version", "type": "string"},
+		{"name": "chainId", "type": "uint256"}
+	],
+	"RescueNodeRequest": [
+		{"name": "operation", "type": "string"},
+		{"name": "timestamp", "type": "uint256"},
+		{"name": "operatorType", "type": "string"},
+		{"name": "nonce", "type": "string"}
+	]
+}`
+
+// Builds an EIP-712 request, as passed to eth_signTypedData_v4.
+func typedTestRequest(chainID uint64, operation string, timestamp int64, operatorType string, nonce string) string {
+	return fmt.Sprintf(`{
+		"types": %s,
+		"primaryType": "RescueNodeRequest",
+		"domain": {"name": "Rescue Node", "version": "1", "chainId": %d},
+		"message": {"operation": "%s", "timestamp": %d, "operatorType": "%s", "nonce": "%s"}
+	}`, typedRequestTestTypes, chainID, operation, timestamp, operatorType, nonce)
+}
+
+func TestParseTypedRequest(t *testing.T) {
+	const nonce = "0123456789abcdef0123456789abcdef"
+	msg := typedTestRequest(1, "CreateCredential", 1700000000, "solo", nonce)
+	req, hash, err := parseTypedRequest([]byte(msg), 1)
+	if err != nil {
+		t.Fatalf("Could not parse request: %v", err)
+	}
+	if req.operation != opCreateCredential || req.timestamp != 1700000000 || req.operatorType != pb.OperatorType_OT_SOLO || req.nonce != nonce {
+		t.Fatalf("Unexpected request %+v", req)
+	}
+	if len(hash) != 32 {
+		t.Fatalf("Unexpected digest length %d", len(hash))
+	}
+
+	// Timestamps may also be encoded as strings, without changing the digest.
+	_, stringHash, err := parseTypedRequest([]byte(strings.Replace(msg, "1700000000", `"1700000000"`, 1)), 1)
+	if err != nil {
+		t.Fatalf("Could not parse request: %v", err)
+	}
+	if string(stringHash) != string(hash) {
+		t.Fatalf("Digest changed with the timestamp encoding")
+	}
+
+	invalid := map[string]string{
+		"chain_id":      typedTestRequest(5, "CreateCredential", 1700000000, "solo", ""),
+		"operator_type": typedTestRequest(1, "CreateCredential", 1700000000, "whale", ""),
+		"domain_name":   strings.Replace(msg, `"Rescue Node"`, `"Rescue Proxy"`, 1),
+		"primary_type":  strings.Replace(msg, `"primaryType": "RescueNodeRequest"`, `"primaryType": "EIP712Domain"`, 1),
+		"types":         strings.Replace(msg, `{"name": "timestamp", "type": "uint256"}`, `{"name": "timestamp", "type": "uint64"}`, 1),
+		"extra_field":   strings.Replace(msg, `"operatorType": "solo"`, `"operatorType": "solo", "extra": 1`, 1),
+		"nonce":         typedTestRequest(1, "CreateCredential", 1700000000, "solo", "0123"),
+		"nonce_type":    strings.Replace(msg, `"`+nonce+`"`, "1", 1),
+		"contract":      strings.Replace(msg, `"chainId": 1`, `"chainId": 1, "verifyingContract": "0x0000000000000000000000000000000000000001"`, 1),
+		"timestamp":     strings.Replace(msg, "1700000000", "-1", 1),
+	}
+	for name, msg := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := parseTypedRequest([]byte(msg), 1); err == nil {
+				t.Fatalf("Expected invalid request to be rejected")
+			}
+		})
+	}
+}
+
+func TestTypedRequests(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	svc, err := setupTestService(t, clock)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err = svc.Init(); err != nil {
+		t.Fatalf("Could not initialize service: %v", err)
+	}
+	defer svc.Deinit()
+
+	node, err := createTestNode(svc, true)
+	if err != nil {
+		t.Fatalf("Could not create node: %v", err)
+	}
+
+	sign := func(msg string) []byte {
+		_, hash, err := parseTypedRequest([]byte(msg), 1)
+		if err != nil {
+			t.Fatalf("Could not parse request: %v", err)
+		}
+		sig, err := node.SignHash(hash)
+		if err != nil {
+			t.Fatalf("Could not sign request: %v", err)
+		}
+		return sig
+	}
+
+	now := clock.Now().Unix()
+	msg := typedTestRequest(1, "CreateCredential", now, "rocketpool", "")
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not create credential: %v", err)
+	}
+
+	// The digest is what is signed, so a personal_sign signature of the JSON is rejected.
+	personalSig, err := node.Sign([]byte(msg))
+	if err != nil {
+		t.Fatalf("Could not sign message: %v", err)
+	}
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), []byte(msg), personalSig, *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected personal_sign signature to be rejected, got %v", err)
+	}
+
+	// Requests are bound to an operation and operator type.
+	msg = typedTestRequest(1, "GetOperatorInfo", now, "rocketpool", "")
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected request for another operation to be rejected, got %v", err)
+	}
+	if _, err := svc.GetOperatorInfo(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	msg = typedTestRequest(1, "CreateCredential", now, "solo", "")
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected request for another operator type to be rejected, got %v", err)
+	}
+
+	// Since they are bound to the operation, revocations may be sent without a nonce.
+	msg = typedTestRequest(1, "RevokeCredential", now, "rocketpool", "")
+	if _, err := svc.RevokeCredential(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not revoke credential: %v", err)
+	}
+
+	// Old requests are rejected.
+	msg = typedTestRequest(1, "CreateCredential", now-3600, "rocketpool", "")
+	if _, err := svc.CreateCredentialWithRetry(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected old request to be rejected, got %v", err)
+	}
+
+	// Challenge nonces are accepted once.
+	nonce, _, err := svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	msg = typedTestRequest(1, "GetOperatorInfo", now, "rocketpool", nonce)
+	if _, err := svc.GetOperatorInfo(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not get operator info: %v", err)
+	}
+	if _, err := svc.GetOperatorInfo(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &AuthenticationError{}) {
+		t.Fatalf("Expected reused nonce to be rejected, got %v", err)
+	}
+
+	// Requests without a nonce are rejected once legacy requests are disabled.
+	svc.SetAllowLegacyRequests(false)
+	msg = typedTestRequest(1, "GetOperatorInfo", now, "rocketpool", "")
+	if _, err := svc.GetOperatorInfo(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); !errors.Is(err, &ValidationError{}) {
+		t.Fatalf("Expected request without a nonce to be rejected, got %v", err)
+	}
+	nonce, _, err = svc.CreateChallenge()
+	if err != nil {
+		t.Fatalf("Could not create challenge: %v", err)
+	}
+	msg = typedTestRequest(1, "GetOperatorInfo", now, "rocketpool", nonce)
+	if _, err := svc.GetOperatorInfo(context.Background(), []byte(msg), sign(msg), *node.Address, pb.OperatorType_OT_ROCKETPOOL); err != nil {
+		t.Fatalf("Could not get operator info with a nonce: %v", err)
+	}
+}
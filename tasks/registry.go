@@ -0,0 +1,34 @@
+package tasks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"go.uber.org/zap"
+)
+
+// replaceRegistry replaces the contents of registry with ids, fetched at now, and records the size and churn of the update.
+// It returns the ids that were added and removed.
+// An empty list is refused, since it is more likely to come from a broken source than from the
+// registry really being empty, and would refuse access to everyone.
+func replaceRegistry(registry *models.NodeRegistry, ids []models.NodeID, now time.Time, m *metrics.MetricsRegistry, logger *zap.Logger) ([]models.NodeID, []models.NodeID, error) {
+	if len(ids) == 0 {
+		m.Counter("empty_updates").Inc()
+		return nil, nil, errors.New("refusing to replace registry with an empty list")
+	}
+
+	added, removed := registry.Replace(ids, now)
+	m.Gauge("size").Set(float64(registry.Len()))
+	m.Counter("added").Add(float64(len(added)))
+	m.Counter("removed").Add(float64(len(removed)))
+
+	if len(added) > 0 || len(removed) > 0 {
+		logger.Debug("Registry changed",
+			zap.Stringers("added", added),
+			zap.Stringers("removed", removed),
+		)
+	}
+	return added, removed, nil
+}
@@ -0,0 +1,188 @@
+package tasks
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+// Task is a job that a Scheduler runs periodically.
+type Task interface {
+	// Name identifies the task in logs, metrics and statuses.
+	Name() string
+	// Run runs the task once. ctx is cancelled when the scheduler stops.
+	Run(ctx context.Context) error
+}
+
+// Schedule tells when a task runs.
+type Schedule struct {
+	// How long to wait after a successful run.
+	Interval time.Duration
+	// How long to wait after a failed run. The delay doubles with each consecutive failure,
+	// up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Delays are randomized by up to this fraction, so that retries don't run in lockstep.
+	Jitter float64
+}
+
+// DefaultSchedule updates every 5 minutes, and retries failed updates after 10 seconds, backing
+// off up to the same 5 minutes.
+var DefaultSchedule = Schedule{
+	Interval:   5 * time.Minute,
+	MinBackoff: 10 * time.Second,
+	MaxBackoff: 5 * time.Minute,
+	Jitter:     0.1,
+}
+
+// delay returns how long to wait after a run, given the number of consecutive failures.
+func (s Schedule) delay(failures int, random float64) time.Duration {
+	delay := s.Interval
+	if failures > 0 {
+		backoff := float64(s.MinBackoff) * math.Pow(2, float64(failures-1))
+		delay = time.Duration(math.Min(backoff, float64(s.MaxBackoff)))
+	}
+	// random is in [0, 1), and spreads the delay over [1 - Jitter, 1 + Jitter).
+	return time.Duration(float64(delay) * (1 + s.Jitter*(2*random-1)))
+}
+
+// TaskStatus tells how the last runs of a task went.
+type TaskStatus struct {
+	LastSuccess time.Time
+	LastFailure time.Time
+	// The error of the last run, or empty if it succeeded.
+	LastError           string
+	ConsecutiveFailures int
+	NextRun             time.Time
+}
+
+type scheduledTask struct {
+	task     Task
+	schedule Schedule
+	status   TaskStatus
+}
+
+// Scheduler runs tasks periodically, each on its own schedule, until it is stopped.
+// Tasks run once as soon as the scheduler starts.
+type Scheduler struct {
+	clock  clockwork.Clock
+	logger *zap.Logger
+	m      *metrics.MetricsRegistry
+	// Returns a random number in [0, 1), to jitter delays.
+	random func() float64
+
+	lock   sync.Mutex
+	tasks  []*scheduledTask
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(clock clockwork.Clock, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		clock:  clock,
+		logger: logger,
+		m:      metrics.NewMetricsRegistry("tasks"),
+		random: rand.Float64,
+	}
+}
+
+// Add schedules a task. It must be called before Start.
+func (s *Scheduler) Add(task Task, schedule Schedule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tasks = append(s.tasks, &scheduledTask{task: task, schedule: schedule})
+}
+
+// Start runs the tasks in the background until ctx is done, or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.run(ctx, t)
+	}
+}
+
+// Stop cancels the tasks, and waits until they returned.
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Status returns the status of each task, keyed by task name.
+func (s *Scheduler) Status() map[string]TaskStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	statuses := make(map[string]TaskStatus, len(s.tasks))
+	for _, t := range s.tasks {
+		statuses[t.task.Name()] = t.status
+	}
+	return statuses
+}
+
+// Records the result of a run, and returns how long to wait until the next one.
+func (s *Scheduler) record(t *scheduledTask, err error) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := t.task.Name()
+	now := s.clock.Now()
+	s.m.Counter(name + "_runs").Inc()
+	if err != nil {
+		t.status.LastFailure = now
+		t.status.LastError = err.Error()
+		t.status.ConsecutiveFailures++
+		s.m.Counter(name + "_failures").Inc()
+		s.m.Gauge(name + "_last_failure").Set(float64(now.Unix()))
+	} else {
+		t.status.LastSuccess = now
+		t.status.LastError = ""
+		t.status.ConsecutiveFailures = 0
+		s.m.Gauge(name + "_last_success").Set(float64(now.Unix()))
+	}
+	s.m.Gauge(name + "_consecutive_failures").Set(float64(t.status.ConsecutiveFailures))
+
+	delay := t.schedule.delay(t.status.ConsecutiveFailures, s.random())
+	t.status.NextRun = now.Add(delay)
+	if err != nil {
+		// Tasks log their own errors.
+		s.logger.Info("Retrying failed task",
+			zap.String("task", name),
+			zap.Int("failures", t.status.ConsecutiveFailures),
+			zap.Duration("retry_in", delay),
+		)
+	}
+	return delay
+}
+
+func (s *Scheduler) run(ctx context.Context, t *scheduledTask) {
+	defer s.wg.Done()
+	var delay time.Duration
+	for {
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logger.Info("Task stopped", zap.String("task", t.task.Name()))
+			return
+		case <-timer.Chan():
+		}
+
+		err := t.task.Run(ctx)
+		if ctx.Err() != nil {
+			// The run was interrupted, which says nothing about the task.
+			s.logger.Info("Task stopped", zap.String("task", t.task.Name()))
+			return
+		}
+		delay = s.record(t, err)
+	}
+}
@@ -0,0 +1,198 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+)
+
+// A task that returns the errors it is given, in order, and succeeds once they are used up.
+type testTask struct {
+	errs []error
+	runs chan struct{}
+}
+
+func (t *testTask) Name() string {
+	return "test"
+}
+
+func (t *testTask) Run(ctx context.Context) error {
+	t.runs <- struct{}{}
+	if len(t.errs) == 0 {
+		return nil
+	}
+	err := t.errs[0]
+	t.errs = t.errs[1:]
+	return err
+}
+
+// A task that runs until it is cancelled.
+type blockingTask struct {
+	running chan struct{}
+}
+
+func (t *blockingTask) Name() string {
+	return "blocking"
+}
+
+func (t *blockingTask) Run(ctx context.Context) error {
+	close(t.running)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func initTestMetrics(t *testing.T) {
+	namespace := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, t.Name())
+	if _, err := metrics.Init(namespace); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(metrics.Deinit)
+}
+
+func TestScheduleDelay(t *testing.T) {
+	schedule := Schedule{
+		Interval:   5 * time.Minute,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: time.Minute,
+		Jitter:     0.1,
+	}
+	tests := []struct {
+		failures int
+		random   float64
+		delay    time.Duration
+	}{
+		{0, 0.5, 5 * time.Minute},
+		{0, 0, 4*time.Minute + 30*time.Second},
+		{0, 1, 5*time.Minute + 30*time.Second},
+		{1, 0.5, 10 * time.Second},
+		{2, 0.5, 20 * time.Second},
+		{3, 0.5, 40 * time.Second},
+		{4, 0.5, time.Minute},
+		{100, 0.5, time.Minute},
+		{1, 0, 9 * time.Second},
+	}
+	for _, tt := range tests {
+		if delay := schedule.delay(tt.failures, tt.random); delay != tt.delay {
+			t.Errorf("Expected a delay of %s after %d failures, got %s", tt.delay, tt.failures, delay)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	initTestMetrics(t)
+	clock := clockwork.NewFakeClock()
+	scheduler := NewScheduler(clock, zap.NewNop())
+	scheduler.random = func() float64 { return 0.5 }
+
+	failure := errors.New("rescue-proxy is unavailable")
+	task := &testTask{
+		errs: []error{failure, failure, failure},
+		runs: make(chan struct{}),
+	}
+	scheduler.Add(task, Schedule{
+		Interval:   5 * time.Minute,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: 30 * time.Second,
+	})
+
+	// Waits for the task to run, and to be scheduled again.
+	expectRun := func() {
+		t.Helper()
+		select {
+		case <-task.runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the task to run")
+		}
+		clock.BlockUntil(1)
+	}
+	expectNoRun := func() {
+		t.Helper()
+		select {
+		case <-task.runs:
+			t.Fatalf("Expected the task not to run")
+		default:
+		}
+	}
+	expectStatus := func(failures int, nextRun time.Duration) TaskStatus {
+		t.Helper()
+		status := scheduler.Status()[task.Name()]
+		if status.ConsecutiveFailures != failures {
+			t.Fatalf("Expected %d consecutive failures, got %d", failures, status.ConsecutiveFailures)
+		}
+		if !status.NextRun.Equal(clock.Now().Add(nextRun)) {
+			t.Fatalf("Expected the next run in %s, got %s", nextRun, status.NextRun.Sub(clock.Now()))
+		}
+		return status
+	}
+
+	// The task runs as soon as the scheduler starts.
+	scheduler.Start(context.Background())
+	defer scheduler.Stop()
+	expectRun()
+	status := expectStatus(1, 10*time.Second)
+	if status.LastError != failure.Error() || !status.LastFailure.Equal(clock.Now()) || !status.LastSuccess.IsZero() {
+		t.Fatalf("Unexpected status after a failure: %+v", status)
+	}
+
+	// Failures are retried with exponential backoff, up to MaxBackoff.
+	clock.Advance(10*time.Second - 1)
+	expectNoRun()
+	clock.Advance(1)
+	expectRun()
+	expectStatus(2, 20*time.Second)
+	clock.Advance(20 * time.Second)
+	expectRun()
+	expectStatus(3, 30*time.Second)
+	clock.Advance(30 * time.Second)
+	expectRun()
+
+	// Once the task succeeds, it runs at the regular interval.
+	status = expectStatus(0, 5*time.Minute)
+	if status.LastError != "" || !status.LastSuccess.Equal(clock.Now()) {
+		t.Fatalf("Unexpected status after a success: %+v", status)
+	}
+	clock.Advance(5*time.Minute - 1)
+	expectNoRun()
+	clock.Advance(1)
+	expectRun()
+	expectStatus(0, 5*time.Minute)
+}
+
+func TestSchedulerStop(t *testing.T) {
+	initTestMetrics(t)
+	clock := clockwork.NewFakeClock()
+	scheduler := NewScheduler(clock, zap.NewNop())
+
+	task := &blockingTask{running: make(chan struct{})}
+	scheduler.Add(task, DefaultSchedule)
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.Start(ctx)
+	<-task.running
+
+	// Cancelling the context stops the task, and the interrupted run isn't recorded as a failure.
+	cancel()
+	scheduler.Stop()
+	status := scheduler.Status()[task.Name()]
+	if status.ConsecutiveFailures != 0 || status.LastError != "" {
+		t.Fatalf("Expected the interrupted run not to be recorded, got %+v", status)
+	}
+
+	// Stop returns when tasks are waiting for their next run, too.
+	scheduler = NewScheduler(clock, zap.NewNop())
+	scheduler.Add(&testTask{runs: make(chan struct{}, 1)}, DefaultSchedule)
+	scheduler.Start(context.Background())
+	clock.BlockUntil(1)
+	scheduler.Stop()
+}
@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/database"
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"go.uber.org/zap"
+)
+
+// Names of the registry snapshots in the database.
+const (
+	nodesSnapshot               = "nodes"
+	withdrawalAddressesSnapshot = "withdrawal_addresses"
+)
+
+// registrySnapshot saves the contents of a registry in the database, so that they are known
+// after a restart before the registry can be updated.
+type registrySnapshot struct {
+	db     *sql.DB
+	name   string
+	logger *zap.Logger
+	// Whether the snapshot has the same contents as the registry, so that only the changes of
+	// an update need to be written. Otherwise, the whole snapshot is rewritten.
+	synced bool
+}
+
+func newRegistrySnapshot(db *sql.DB, name string, logger *zap.Logger) *registrySnapshot {
+	return &registrySnapshot{
+		db:     db,
+		name:   name,
+		logger: logger,
+	}
+}
+
+// load fills registry with the snapshot saved by the last successful update, if any.
+// LastUpdated is restored too, so a snapshot that is too old is refused like a stale registry.
+func (s *registrySnapshot) load(registry *models.NodeRegistry) error {
+	ids, lastUpdated, err := database.LoadRegistrySnapshot(s.db, s.name)
+	if err != nil {
+		return err
+	}
+	if lastUpdated.IsZero() {
+		s.logger.Info("No registry snapshot to load", zap.String("registry", s.name))
+		return nil
+	}
+
+	registry.Replace(ids, lastUpdated)
+	s.synced = true
+	s.logger.Info("Loaded registry snapshot",
+		zap.String("registry", s.name),
+		zap.Int("size", len(ids)),
+		zap.Time("last_updated", lastUpdated),
+	)
+	return nil
+}
+
+// save saves a successful update, which replaced the registry with ids.
+// Failures are only logged, since the registry itself was updated.
+func (s *registrySnapshot) save(ids []models.NodeID, added []models.NodeID, removed []models.NodeID, lastUpdated time.Time) {
+	var err error
+	if s.synced {
+		err = database.UpdateRegistrySnapshot(s.db, s.name, added, removed, lastUpdated)
+	} else {
+		err = database.SaveRegistrySnapshot(s.db, s.name, ids, lastUpdated)
+	}
+	// The snapshot may not have all the changes until the next full rewrite.
+	s.synced = err == nil
+	if err != nil {
+		s.logger.Warn("Failed to save registry snapshot", zap.String("registry", s.name), zap.Error(err))
+	}
+}
@@ -0,0 +1,75 @@
+package tasks
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/database"
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+func TestRegistrySnapshot(t *testing.T) {
+	db, err := database.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+
+	a := common.HexToAddress("0x01")
+	b := common.HexToAddress("0x02")
+	c := common.HexToAddress("0x03")
+	stale := common.HexToAddress("0x04")
+	now := time.Unix(1700000000, 0)
+	expectSnapshot := func(expected ...common.Address) {
+		t.Helper()
+		ids, lastUpdated, err := database.LoadRegistrySnapshot(db, nodesSnapshot)
+		if err != nil {
+			t.Fatalf("Could not load snapshot: %v", err)
+		}
+		slices.SortFunc(ids, func(x, y common.Address) int { return x.Cmp(y) })
+		if !slices.Equal(ids, expected) || !lastUpdated.Equal(now) {
+			t.Fatalf("Unexpected snapshot %v at %v", ids, lastUpdated)
+		}
+	}
+
+	// A snapshot that wasn't loaded may not match the registry, so it is rewritten.
+	if err := database.SaveRegistrySnapshot(db, nodesSnapshot, []common.Address{a, stale}, now); err != nil {
+		t.Fatalf("Could not save snapshot: %v", err)
+	}
+	snapshot := newRegistrySnapshot(db, nodesSnapshot, zap.NewNop())
+	snapshot.save([]models.NodeID{a, b}, []models.NodeID{a, b}, nil, now)
+	expectSnapshot(a, b)
+
+	// Once in sync, only the changes are written.
+	if err := database.UpdateRegistrySnapshot(db, nodesSnapshot, []common.Address{stale}, nil, now); err != nil {
+		t.Fatalf("Could not update snapshot: %v", err)
+	}
+	now = now.Add(time.Minute)
+	snapshot.save([]models.NodeID{b, c}, []models.NodeID{c}, []models.NodeID{a}, now)
+	expectSnapshot(b, c, stale)
+
+	// Loading a snapshot puts it in sync with the registry.
+	registry := models.NewNodeRegistry()
+	snapshot = newRegistrySnapshot(db, nodesSnapshot, zap.NewNop())
+	if err := snapshot.load(registry); err != nil {
+		t.Fatalf("Could not load snapshot: %v", err)
+	}
+	if registry.Len() != 3 || !registry.LastUpdated().Equal(now) || !snapshot.synced {
+		t.Fatalf("Unexpected registry of %d nodes at %v", registry.Len(), registry.LastUpdated())
+	}
+
+	// Failures are rewritten in full by the next save.
+	if _, err := db.Exec(`DROP TABLE registry_snapshot_entries;`); err != nil {
+		t.Fatalf("Could not drop table: %v", err)
+	}
+	snapshot.save([]models.NodeID{a}, []models.NodeID{a}, []models.NodeID{b, c, stale}, now)
+	if snapshot.synced {
+		t.Fatalf("Expected the snapshot to be out of sync after a failure")
+	}
+}
@@ -1,47 +1,51 @@
 package tasks
 
 import (
-	"time"
+	"context"
+	"database/sql"
 
 	"github.com/Rocket-Rescue-Node/rescue-api/external"
 	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
 	"go.uber.org/zap"
 )
 
 // UpdateNodesTask periodically updates the registry of known Rocket Pool nodes.
 // It uses the Rescue Proxy to retrieve the list of nodes.
 type UpdateNodesTask struct {
-	rescueProxyAddr string
-	nodes           *models.NodeRegistry
-	done            chan bool
-	secureGRPC      bool
-	logger          *zap.Logger
+	rescueProxy *external.RescueProxyAPIClient
+	nodes       *models.NodeRegistry
+	snapshot    *registrySnapshot
+	clock       clockwork.Clock
+	logger      *zap.Logger
+	m           *metrics.MetricsRegistry
 }
 
 func NewUpdateNodesTask(
-	proxy string,
+	rescueProxy *external.RescueProxyAPIClient,
 	nodes *models.NodeRegistry,
-	secureGRPC bool,
+	db *sql.DB,
+	clock clockwork.Clock,
 	logger *zap.Logger,
 ) *UpdateNodesTask {
 	return &UpdateNodesTask{
-		proxy,
+		rescueProxy,
 		nodes,
-		make(chan bool),
-		secureGRPC,
+		newRegistrySnapshot(db, nodesSnapshot, logger),
+		clock,
 		logger,
+		metrics.NewMetricsRegistry("node_registry"),
 	}
 }
 
 // updateUsingRescueProxy updates the node registry using the Rescue Proxy API.
-func (t *UpdateNodesTask) updateUsingRescueProxy() error {
+func (t *UpdateNodesTask) updateUsingRescueProxy(ctx context.Context) error {
 	src := "rescue-proxy"
 	t.logger.Info("Updating Rocket Pool node registry...", zap.String("source", src))
 
-	rescueProxyAPI := external.NewRescueProxyAPIClient(t.logger, t.rescueProxyAddr, t.secureGRPC)
-	defer rescueProxyAPI.Close()
-	nodes, err := rescueProxyAPI.GetRocketPoolNodes()
+	nodes, err := t.rescueProxy.GetRocketPoolNodes(ctx)
 	if err != nil {
 		t.logger.Warn("Failed to update node registry", zap.String("source", src), zap.Error(err))
 		return err
@@ -50,35 +54,35 @@ func (t *UpdateNodesTask) updateUsingRescueProxy() error {
 	for _, n := range nodes {
 		newList = append(newList, common.BytesToAddress(n))
 	}
-	t.nodes.Add(newList)
+	// Only successful updates count, so that a registry that can't be updated goes stale.
+	now := t.clock.Now()
+	added, removed, err := replaceRegistry(t.nodes, newList, now, t.m, t.logger)
+	if err != nil {
+		t.logger.Warn("Failed to update node registry", zap.String("source", src), zap.Error(err))
+		return err
+	}
+	t.snapshot.save(newList, added, removed, now)
 
-	t.logger.Info("Node registry successfully updated", zap.String("source", src))
+	t.logger.Info("Node registry successfully updated",
+		zap.String("source", src),
+		zap.Int("size", t.nodes.Len()),
+		zap.Int("added", len(added)),
+		zap.Int("removed", len(removed)),
+	)
 
 	return nil
 }
 
-func (t *UpdateNodesTask) Run() {
-	ticker := time.NewTicker(time.Duration(1) * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-t.done:
-			t.logger.Info("Update nodes task stopped")
-			return
-		case <-ticker.C:
-			// Try to update using the Rescue Proxy API.
-			err := t.updateUsingRescueProxy()
-			if err != nil { // If sources fail, try again quickly.
-				ticker.Reset(time.Duration(30) * time.Second)
-			}
-			// Otherwise, wait longer
-			t.nodes.LastUpdated = time.Now()
-			ticker.Reset(time.Duration(300) * time.Second)
-		}
-	}
+// LoadSnapshot fills the node registry with the nodes saved by the last successful update,
+// so that they are known before the Rescue Proxy is reached. It must be called before the task runs.
+func (t *UpdateNodesTask) LoadSnapshot() error {
+	return t.snapshot.load(t.nodes)
 }
 
-func (t *UpdateNodesTask) Stop() error {
-	t.done <- true
-	return nil
+func (t *UpdateNodesTask) Name() string {
+	return "update_nodes"
+}
+
+func (t *UpdateNodesTask) Run(ctx context.Context) error {
+	return t.updateUsingRescueProxy(ctx)
 }
@@ -0,0 +1,83 @@
+package tasks
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Rocket-Rescue-Node/rescue-api/database"
+	"github.com/Rocket-Rescue-Node/rescue-api/external"
+	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	proxy "github.com/Rocket-Rescue-Node/rescue-proxy/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type testProxyServer struct {
+	proxy.UnimplementedApiServer
+	nodes [][]byte
+}
+
+func (s *testProxyServer) GetRocketPoolNodes(context.Context, *proxy.RocketPoolNodesRequest) (*proxy.RocketPoolNodes, error) {
+	return &proxy.RocketPoolNodes{NodeIds: s.nodes}, nil
+}
+
+func (s *testProxyServer) GetSoloValidators(context.Context, *proxy.SoloValidatorsRequest) (*proxy.SoloValidators, error) {
+	return &proxy.SoloValidators{WithdrawalAddresses: s.nodes}, nil
+}
+
+func TestUpdateTasks(t *testing.T) {
+	initTestMetrics(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := grpc.NewServer()
+	proxy.RegisterApiServer(server, &testProxyServer{nodes: [][]byte{common.HexToAddress("0x01").Bytes()}})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	client, err := external.NewRescueProxyAPIClient(zap.NewNop(), listener.Addr().String(), 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	defer client.Close()
+
+	db, err := database.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Could not migrate database: %v", err)
+	}
+
+	// Updates are timed with the clock the tasks are given.
+	clock := clockwork.NewFakeClockAt(time.Unix(1700000000, 0))
+	nodes := models.NewNodeRegistry()
+	withdrawalAddresses := models.NewNodeRegistry()
+	for _, task := range []Task{
+		NewUpdateNodesTask(client, nodes, db, clock, zap.NewNop()),
+		NewUpdateWithdrawalAddressesTask(client, withdrawalAddresses, db, clock, zap.NewNop()),
+	} {
+		if err := task.Run(context.Background()); err != nil {
+			t.Fatalf("Could not run %s: %v", task.Name(), err)
+		}
+	}
+	for name, registry := range map[string]*models.NodeRegistry{
+		nodesSnapshot:               nodes,
+		withdrawalAddressesSnapshot: withdrawalAddresses,
+	} {
+		if !registry.Has(common.HexToAddress("0x01")) || !registry.LastUpdated().Equal(clock.Now()) {
+			t.Fatalf("Unexpected %s registry of %d nodes at %v", name, registry.Len(), registry.LastUpdated())
+		}
+		if _, lastUpdated, err := database.LoadRegistrySnapshot(db, name); err != nil || !lastUpdated.Equal(clock.Now()) {
+			t.Fatalf("Unexpected %s snapshot at %v: %v", name, lastUpdated, err)
+		}
+	}
+}
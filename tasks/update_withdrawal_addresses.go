@@ -1,45 +1,49 @@
 package tasks
 
 import (
-	"time"
+	"context"
+	"database/sql"
 
 	"github.com/Rocket-Rescue-Node/rescue-api/external"
 	"github.com/Rocket-Rescue-Node/rescue-api/models"
+	"github.com/Rocket-Rescue-Node/rescue-proxy/metrics"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jonboulle/clockwork"
 	"go.uber.org/zap"
 )
 
 // UpdateWithdrawalAddressesTask periodically updates the registry of known validators' withdrawal addreses
 // It uses the Rescue Proxy APIsto retrieve the list of addresses.
 type UpdateWithdrawalAddressesTask struct {
-	rescueProxyAddr     string
+	rescueProxy         *external.RescueProxyAPIClient
 	withdrawalAddresses *models.NodeRegistry
-	done                chan bool
-	secureGRPC          bool
+	snapshot            *registrySnapshot
+	clock               clockwork.Clock
 	logger              *zap.Logger
+	m                   *metrics.MetricsRegistry
 }
 
 func NewUpdateWithdrawalAddressesTask(
-	proxy string,
+	rescueProxy *external.RescueProxyAPIClient,
 	withdrawalAddresses *models.NodeRegistry,
-	secureGRPC bool,
+	db *sql.DB,
+	clock clockwork.Clock,
 	logger *zap.Logger,
 ) *UpdateWithdrawalAddressesTask {
 	return &UpdateWithdrawalAddressesTask{
-		proxy,
+		rescueProxy,
 		withdrawalAddresses,
-		make(chan bool),
-		secureGRPC,
+		newRegistrySnapshot(db, withdrawalAddressesSnapshot, logger),
+		clock,
 		logger,
+		metrics.NewMetricsRegistry("withdrawal_address_registry"),
 	}
 }
 
-func (t *UpdateWithdrawalAddressesTask) updateUsingRescueProxy() error {
+func (t *UpdateWithdrawalAddressesTask) updateUsingRescueProxy(ctx context.Context) error {
 	t.logger.Info("Updating Withdrawal Address registry...")
 
-	rescueProxyAPI := external.NewRescueProxyAPIClient(t.logger, t.rescueProxyAddr, t.secureGRPC)
-	defer rescueProxyAPI.Close()
-	addresses, err := rescueProxyAPI.GetWithdrawalAddresses()
+	addresses, err := t.rescueProxy.GetWithdrawalAddresses(ctx)
 	if err != nil {
 		t.logger.Warn("Failed to update Withdrawal Address registry", zap.Error(err))
 		return err
@@ -48,38 +52,34 @@ func (t *UpdateWithdrawalAddressesTask) updateUsingRescueProxy() error {
 	for _, n := range addresses {
 		newList = append(newList, common.BytesToAddress(n))
 	}
-	t.withdrawalAddresses.Reset()
-	t.withdrawalAddresses.Add(newList)
+	// Only successful updates count, so that a registry that can't be updated goes stale.
+	now := t.clock.Now()
+	added, removed, err := replaceRegistry(t.withdrawalAddresses, newList, now, t.m, t.logger)
+	if err != nil {
+		t.logger.Warn("Failed to update Withdrawal Address registry", zap.Error(err))
+		return err
+	}
+	t.snapshot.save(newList, added, removed, now)
 
-	t.logger.Info("Withdrawal Address registry successfully updated")
+	t.logger.Info("Withdrawal Address registry successfully updated",
+		zap.Int("size", t.withdrawalAddresses.Len()),
+		zap.Int("added", len(added)),
+		zap.Int("removed", len(removed)),
+	)
 
 	return nil
 }
 
-func (t *UpdateWithdrawalAddressesTask) Run() {
-	ticker := time.NewTicker(time.Duration(1) * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-t.done:
-			t.logger.Info("Update withdrawal addresses task stopped")
-			return
-		case <-ticker.C:
-			// Update using the Rescue Proxy API.
-			err := t.updateUsingRescueProxy()
-			if err != nil {
-				// Try again soon
-				ticker.Reset(time.Duration(30) * time.Second)
-			}
+// LoadSnapshot fills the registry with the addresses saved by the last successful update,
+// so that they are known before the Rescue Proxy is reached. It must be called before the task runs.
+func (t *UpdateWithdrawalAddressesTask) LoadSnapshot() error {
+	return t.snapshot.load(t.withdrawalAddresses)
+}
 
-			// If we succeed, try again after a longer pause
-			ticker.Reset(time.Duration(300) * time.Second)
-			t.withdrawalAddresses.LastUpdated = time.Now()
-		}
-	}
+func (t *UpdateWithdrawalAddressesTask) Name() string {
+	return "update_withdrawal_addresses"
 }
 
-func (t *UpdateWithdrawalAddressesTask) Stop() error {
-	t.done <- true
-	return nil
+func (t *UpdateWithdrawalAddressesTask) Run(ctx context.Context) error {
+	return t.updateUsingRescueProxy(ctx)
 }
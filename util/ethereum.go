@@ -1,10 +1,17 @@
 package util
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
@@ -21,11 +28,48 @@ type Wallet struct {
 // This is the format currently used to sign messages by the Rocket Pool smartnode stack:
 // https://github.com/rocket-pool/smartnode/blob/9ded8d070bdd81798813e16b53657f600bab781e/shared/services/wallet/wallet.go#L305
 func RecoverAddressFromSignature(msg []byte, sig []byte) (*common.Address, error) {
-	if len(sig) != crypto.SignatureLength {
+	return RecoverAddressFromHash(accounts.TextHash(msg), sig)
+}
+
+// Recovers the address of the signer from a digest and signature, e.g. an EIP-712 digest.
+// Accepts the same signature formats as NormalizeSignature.
+func RecoverAddressFromHash(hash []byte, sig []byte) (*common.Address, error) {
+	sig, err := NormalizeSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recover the public key from the signature.
+	pubKey, err := crypto.SigToPub(hash, sig)
+
+	if err != nil {
+		return nil, err
+	}
+	address := crypto.PubkeyToAddress(*pubKey)
+	return &address, nil
+}
+
+// Returns a copy of sig in the [R || S || V] format expected by crypto.SigToPub, with V = 0 or 1.
+// Accepts signatures in the format returned by eth_sign, and EIP-2098 compact signatures:
+// https://eips.ethereum.org/EIPS/eip-2098
+// Signatures with a high S value are rejected, since they are malleable: for any valid
+// signature (R, S, V), (R, N-S, V^1) is another valid signature of the same digest.
+func NormalizeSignature(sig []byte) ([]byte, error) {
+	var normalized []byte
+	switch len(sig) {
+	case crypto.SignatureLength:
+		normalized = append([]byte{}, sig...)
+	case crypto.SignatureLength - 1:
+		// EIP-2098 compact signatures are [R || yParity << 255 | S].
+		normalized = make([]byte, crypto.SignatureLength)
+		copy(normalized, sig)
+		normalized[32] &= 0x7f
+		normalized[crypto.RecoveryIDOffset] = sig[32] >> 7
+	default:
 		return nil, secp256k1.ErrInvalidSignatureLen
 	}
 
-	v := &sig[crypto.RecoveryIDOffset]
+	v := &normalized[crypto.RecoveryIDOffset]
 
 	// According to the Ethereum Yellow Paper, the signature format must be
 	// [R || S || V], and V (the Recovery ID) must be 27 or 28. This was apparently
@@ -41,25 +85,38 @@ func RecoverAddressFromSignature(msg []byte, sig []byte) (*common.Address, error
 	case 27, 28:
 		// Subtract 27 to get the actual recovery ID.
 		*v -= 27
-		// Restore V to its original value at the end of the function.
-		defer func() {
-			*v += 27
-		}()
 	case 0, 1:
 		// Do nothing.
 	default:
 		return nil, fmt.Errorf("invalid recovery ID: %d", *v)
 	}
 
-	// Recover the public key from the signature.
-	hash := accounts.TextHash(msg)
-	pubKey, err := crypto.SigToPub(hash, sig)
+	r := new(big.Int).SetBytes(normalized[:32])
+	s := new(big.Int).SetBytes(normalized[32:64])
+	if !crypto.ValidateSignatureValues(*v, r, s, true) {
+		return nil, errors.New("invalid signature values, or malleable signature with a high S value")
+	}
+	return normalized, nil
+}
 
-	if err != nil {
-		return nil, err
+// Decodes a signature given as hex, with or without a 0x prefix, or as base64.
+// Strings that are valid hex are decoded as hex.
+func DecodeSignature(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, errors.New("empty signature")
 	}
-	address := crypto.PubkeyToAddress(*pubKey)
-	return &address, nil
+	if strings.HasPrefix(encoded, "0x") || strings.HasPrefix(encoded, "0X") {
+		return hex.DecodeString(encoded[2:])
+	}
+	if sig, err := hex.DecodeString(encoded); err == nil {
+		return sig, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if sig, err := encoding.DecodeString(encoded); err == nil {
+			return sig, nil
+		}
+	}
+	return nil, errors.New("signature is neither hex nor base64")
 }
 
 // Generates a new wallet with a random private key.
@@ -79,7 +136,13 @@ func NewWallet() (*Wallet, error) {
 // Return a signature in the format used by eth_sign.
 // See RecoverAddressFromSignature() for more details.
 func (w *Wallet) Sign(msg []byte) ([]byte, error) {
-	sig, err := crypto.Sign(accounts.TextHash(msg), w.Key)
+	return w.SignHash(accounts.TextHash(msg))
+}
+
+// Signs a digest with the wallet's private key, e.g. an EIP-712 digest.
+// Return a signature in the format used by eth_sign.
+func (w *Wallet) SignHash(hash []byte) ([]byte, error) {
+	sig, err := crypto.Sign(hash, w.Key)
 	if err != nil {
 		return nil, err
 	}
@@ -88,3 +151,54 @@ func (w *Wallet) Sign(msg []byte) ([]byte, error) {
 	sig[crypto.RecoveryIDOffset] += 27
 	return sig, nil
 }
+
+// The suffix that marks a signature as an EIP-6492 counterfactual signature.
+var eip6492MagicSuffix = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+
+// The ABI encoding of EIP-6492 signatures, before the magic suffix is appended.
+var eip6492Arguments = func() abi.Arguments {
+	addressType, _ := abi.NewType("address", "", nil)
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	return abi.Arguments{{Type: addressType}, {Type: bytesType}, {Type: bytesType}}
+}()
+
+// A signature of a smart contract wallet that may not be deployed yet, as defined by EIP-6492:
+// https://eips.ethereum.org/EIPS/eip-6492
+type EIP6492Signature struct {
+	// The factory that deploys the wallet.
+	Factory common.Address
+	// The calldata the factory must be called with to deploy the wallet.
+	FactoryCalldata []byte
+	// The EIP-1271 signature to validate once the wallet is deployed.
+	Signature []byte
+}
+
+// Returns true if sig ends with the EIP-6492 magic suffix.
+func IsEIP6492Signature(sig []byte) bool {
+	return bytes.HasSuffix(sig, eip6492MagicSuffix)
+}
+
+// Unwraps an EIP-6492 signature, i.e. abi.encode(factory, factoryCalldata, signature) followed by the magic suffix.
+func ParseEIP6492Signature(sig []byte) (*EIP6492Signature, error) {
+	if !IsEIP6492Signature(sig) {
+		return nil, fmt.Errorf("missing EIP-6492 magic suffix")
+	}
+	values, err := eip6492Arguments.Unpack(sig[:len(sig)-len(eip6492MagicSuffix)])
+	if err != nil {
+		return nil, fmt.Errorf("invalid EIP-6492 signature: %w", err)
+	}
+	return &EIP6492Signature{
+		Factory:         values[0].(common.Address),
+		FactoryCalldata: values[1].([]byte),
+		Signature:       values[2].([]byte),
+	}, nil
+}
+
+// Returns the wrapped signature, including the magic suffix.
+func (s *EIP6492Signature) Bytes() ([]byte, error) {
+	packed, err := eip6492Arguments.Pack(s.Factory, s.FactoryCalldata, s.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return append(packed, eip6492MagicSuffix...), nil
+}
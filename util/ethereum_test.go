@@ -1,11 +1,16 @@
 package util
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/hex"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -70,3 +75,186 @@ func TestRecoverAddressFromSignature(t *testing.T) {
 		})
 	}
 }
+
+func TestRecoverAddressFromHash(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+
+	hash := crypto.Keccak256([]byte("typed data digest"))
+	sig, err := wallet.SignHash(hash)
+	assert.NoError(t, err)
+
+	address, err := RecoverAddressFromHash(hash, sig)
+	assert.NoError(t, err)
+	assert.Equal(t, *wallet.Address, *address)
+
+	// The digest is used as-is, without the personal_sign prefix.
+	address, err = RecoverAddressFromSignature(hash, sig)
+	assert.NoError(t, err)
+	assert.NotEqual(t, *wallet.Address, *address)
+}
+
+func TestParseEIP6492Signature(t *testing.T) {
+	wrapped := &EIP6492Signature{
+		Factory:         common.HexToAddress("0x4e59b44847b379578588920cA78FbF26c0B4956C"),
+		FactoryCalldata: common.FromHex("0xdeadbeef"),
+		Signature:       bytes.Repeat([]byte{0x01}, 65),
+	}
+	sig, err := wrapped.Bytes()
+	assert.NoError(t, err)
+	assert.True(t, IsEIP6492Signature(sig))
+	assert.Equal(t, "6492649264926492649264926492649264926492649264926492649264926492", hex.EncodeToString(sig[len(sig)-32:]))
+
+	parsed, err := ParseEIP6492Signature(sig)
+	assert.NoError(t, err)
+	assert.Equal(t, wrapped, parsed)
+
+	tests := []struct {
+		name      string
+		signature []byte
+	}{
+		{
+			name:      "EOA signature",
+			signature: wrapped.Signature,
+		},
+		{
+			name:      "Magic suffix only",
+			signature: eip6492MagicSuffix,
+		},
+		{
+			name:      "Truncated encoding",
+			signature: append(append([]byte{}, sig[:96]...), eip6492MagicSuffix...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseEIP6492Signature(tt.signature)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSignatureEncodings(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	msg := []byte("Rescue Node 1700000000")
+	sig, err := wallet.Sign(msg)
+	assert.NoError(t, err)
+
+	// EIP-2098 compact form: [R || yParity << 255 | S].
+	compact := append([]byte{}, sig[:64]...)
+	compact[32] |= (sig[64] - 27) << 7
+
+	// The malleable counterpart of sig: (R, N-S, V^1).
+	highS := append([]byte{}, sig...)
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64]))
+	s.FillBytes(highS[32:64])
+	highS[64] = 27 + 28 - highS[64]
+
+	lowV := append([]byte{}, sig...)
+	lowV[64] -= 27
+
+	tests := []struct {
+		name    string
+		encoded string
+		valid   bool
+	}{
+		{
+			name:    "Hex with 0x prefix",
+			encoded: hexutil.Encode(sig),
+			valid:   true,
+		},
+		{
+			name:    "Hex without prefix",
+			encoded: hex.EncodeToString(sig),
+			valid:   true,
+		},
+		{
+			name:    "Base64",
+			encoded: base64.StdEncoding.EncodeToString(sig),
+			valid:   true,
+		},
+		{
+			name:    "Unpadded URL-safe base64",
+			encoded: base64.RawURLEncoding.EncodeToString(sig),
+			valid:   true,
+		},
+		{
+			name:    "Low V",
+			encoded: hexutil.Encode(lowV),
+			valid:   true,
+		},
+		{
+			name:    "EIP-2098 compact hex",
+			encoded: hexutil.Encode(compact),
+			valid:   true,
+		},
+		{
+			name:    "EIP-2098 compact base64",
+			encoded: base64.StdEncoding.EncodeToString(compact),
+			valid:   true,
+		},
+		{
+			name:    "High S",
+			encoded: hexutil.Encode(highS),
+		},
+		{
+			name:    "Invalid V",
+			encoded: hexutil.Encode(append(append([]byte{}, sig[:64]...), 29)),
+		},
+		{
+			name:    "Truncated",
+			encoded: hexutil.Encode(sig[:63]),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeSignature(tt.encoded)
+			assert.NoError(t, err)
+			address, err := RecoverAddressFromSignature(msg, decoded)
+			if !tt.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, *wallet.Address, *address)
+		})
+	}
+
+	// The high S signature does recover the same key, which is why it must be rejected.
+	highS[64] -= 27
+	pub, err := crypto.SigToPub(accounts.TextHash(msg), highS)
+	assert.NoError(t, err)
+	assert.Equal(t, *wallet.Address, crypto.PubkeyToAddress(*pub))
+}
+
+func TestDecodeSignature(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoded  string
+		expected []byte
+	}{
+		{"Hex with 0x prefix", "0xdeadbeef", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"Hex with 0X prefix", "0XDEADBEEF", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"Hex without prefix", "deadbeef", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"Base64", "3q2+7w==", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"URL-safe base64", "3q2-7w", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"Empty", "", nil},
+		{"Invalid hex with prefix", "0xdeadbeefz", nil},
+		{"Neither", "not a signature!", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeSignature(tt.encoded)
+			if tt.expected == nil {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, decoded)
+		})
+	}
+}
@@ -3,7 +3,10 @@ package util
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 )
 
 const (
@@ -36,3 +39,41 @@ func HTTPLimitedGet(url string, maxSize int64) ([]byte, error) {
 	}
 	return body, nil
 }
+
+// Returns the IP address of the client that sent a request.
+// X-Forwarded-For is only honoured when the request comes from a trusted proxy. The header is
+// read from right to left, skipping trusted proxies, since clients can prepend arbitrary values.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q: %w", r.RemoteAddr, err)
+	}
+	ip = ip.Unmap()
+
+	isTrusted := func(ip netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0 && isTrusted(ip); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Anything before a malformed entry can't be trusted.
+			break
+		}
+		ip = next.Unmap()
+	}
+	return ip, nil
+}
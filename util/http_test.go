@@ -0,0 +1,89 @@
+package util
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{
+			name:       "Direct client",
+			remoteAddr: "203.0.113.1:1234",
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "Untrusted client spoofing X-Forwarded-For",
+			remoteAddr: "203.0.113.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, 10.0.0.3", "10.0.0.2"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "Client prepending to X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"192.0.2.1, 198.51.100.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "Malformed entry",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, garbage, 10.0.0.2"},
+			expected:   "10.0.0.2",
+		},
+		{
+			name:       "Trusted proxy without X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[2001:db8::1]:1234",
+			forwarded:  []string{"2001:db9::1"},
+			expected:   "2001:db9::1",
+		},
+		{
+			name:       "IPv4-mapped IPv6",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			forwarded:  []string{"198.51.100.1"},
+			expected:   "198.51.100.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			ip, err := ClientIP(r, trusted)
+			assert.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.expected), ip)
+		})
+	}
+}
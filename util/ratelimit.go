@@ -0,0 +1,111 @@
+package util
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// The maximum number of keys a RateLimiter tracks. When it is reached, the buckets that have
+// refilled are forgotten, then arbitrary ones, so that memory stays bounded when clients use
+// many distinct keys.
+const maxRateLimiterKeys = 100000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter enforces a token bucket per key, e.g. per client IP: up to burst requests
+// are allowed at once, and tokens are refilled at rate per second.
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	clock   clockwork.Clock
+	maxKeys int
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(rate float64, burst int, clock clockwork.Clock) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clock,
+		maxKeys: maxRateLimiterKeys,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Returns the tokens in a bucket at now.
+func (l *RateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// Returns how long it takes for a bucket with tokens to have one.
+func (l *RateLimiter) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / l.rate * float64(time.Second))
+}
+
+// Allow takes a token from the bucket of key.
+// If none is left, it returns false, and how long it will take for one to be available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxKeys {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, l.wait(b.tokens)
+}
+
+// Peek tells whether the bucket of key has a token, like Allow, without taking it.
+func (l *RateLimiter) Peek(key string) (bool, time.Duration) {
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return true, 0
+	}
+	if tokens := l.refill(b, now); tokens < 1 {
+		return false, l.wait(tokens)
+	}
+	return true, 0
+}
+
+// prune forgets buckets that have refilled, since a new bucket is equivalent.
+// If that is not enough, arbitrary buckets are forgotten too, leaving room for a tenth of
+// maxKeys, so that pruning doesn't happen again for each new key.
+func (l *RateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	for key := range l.buckets {
+		if len(l.buckets) < l.maxKeys-l.maxKeys/10 {
+			break
+		}
+		delete(l.buckets, key)
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter := NewRateLimiter(0.5, 3, clock)
+
+	// The burst is available at once.
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := limiter.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// Keys have their own buckets.
+	ok, _ = limiter.Allow("b")
+	assert.True(t, ok)
+
+	// Tokens are refilled at the rate.
+	clock.Advance(time.Second)
+	ok, wait = limiter.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+	clock.Advance(time.Second)
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+
+	// Up to the burst.
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow("a")
+	assert.False(t, ok)
+
+	// Peeking doesn't take tokens.
+	ok, wait = limiter.Peek("a")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+	ok, _ = limiter.Peek("c")
+	assert.True(t, ok)
+	clock.Advance(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		ok, _ = limiter.Peek("a")
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+	ok, _ = limiter.Peek("a")
+	assert.False(t, ok)
+
+	// Refilled buckets are forgotten when pruning.
+	limiter.prune(clock.Now())
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "a")
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter := NewRateLimiter(0.5, 1, clock)
+	limiter.maxKeys = 100
+
+	// Buckets that are still empty are forgotten too once there are too many keys.
+	for i := 0; i < 1000; i++ {
+		ok, _ := limiter.Allow(fmt.Sprint(i))
+		assert.True(t, ok)
+		assert.LessOrEqual(t, len(limiter.buckets), limiter.maxKeys)
+	}
+}